@@ -22,11 +22,19 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/url"
 	"os"
+	"strconv"
 
 	"github.com/apex/log"
+	"github.com/bullettime/lora-mqtt/parser"
 	"github.com/bullettime/lora-mqtt/parser/factory"
+	"github.com/bullettime/lora-mqtt/parser/javascript"
+	"github.com/bullettime/lora-mqtt/parser/jsonmap"
+	"github.com/bullettime/lora-mqtt/parser/layout"
+	"github.com/bullettime/lora-mqtt/topic"
+	"github.com/pkg/errors"
 	"github.com/segmentio/go-prompt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -34,13 +42,37 @@ import (
 )
 
 type yamlConfig struct {
-	Parser   parserConfig   `yaml:"parser"`
-	InfluxDB influxdbConfig `yaml:"influxdb"`
-	MQTT     mqttConfig     `yaml:"mqtt"`
+	Input      string                       `yaml:"input"`
+	Parser     parserConfig                 `yaml:"parser"`
+	InfluxDB   influxdbConfig               `yaml:"influxdb"`
+	MQTT       mqttConfig                   `yaml:"mqtt,omitempty"`
+	Semtech    semtechConfig                `yaml:"semtech,omitempty"`
+	LoRaWAN    lorawanConfig                `yaml:"lorawan,omitempty"`
+	Routes     []routeConfig                `yaml:"routes,omitempty"`
+	Outputs    map[string]influxdbConfig    `yaml:"outputs,omitempty"`
+	Schemas    map[string]map[string]string `yaml:"schemas,omitempty"`
+	DeadLetter deadletterConfig             `yaml:"deadletter,omitempty"`
+}
+
+type deadletterConfig struct {
+	File     string `yaml:"file,omitempty"`
+	MaxSize  int64  `yaml:"max_size,omitempty"`
+	MaxFiles int    `yaml:"max_files,omitempty"`
+	Topic    string `yaml:"topic,omitempty"`
 }
 
 type parserConfig struct {
-	Type int `yaml:"type"`
+	Type       string           `yaml:"type"`
+	CayenneLPP bool             `yaml:"cayennelpp,omitempty"`
+	Location   string           `yaml:"location,omitempty"`
+	Layouts    []layout.Layout  `yaml:"layouts,omitempty"`
+	JavaScript javascriptConfig `yaml:"javascript,omitempty"`
+	JSONMap    *jsonmap.Config  `yaml:"jsonmap,omitempty"`
+}
+
+type javascriptConfig struct {
+	javascript.Options `yaml:",inline"`
+	Scripts            []javascript.Script `yaml:"scripts,omitempty"`
 }
 
 type serverConfig struct {
@@ -50,9 +82,45 @@ type serverConfig struct {
 }
 
 type influxdbConfig struct {
+	Type      string       `yaml:"type,omitempty"`
 	Server    serverConfig `yaml:"server"`
-	Database  string       `yaml:"database"`
+	Database  string       `yaml:"database,omitempty"`
+	Token     string       `yaml:"token,omitempty"`
+	Org       string       `yaml:"org,omitempty"`
+	Bucket    string       `yaml:"bucket,omitempty"`
+	Gzip      bool         `yaml:"gzip,omitempty"`
 	Precision string       `yaml:"precision"`
+
+	BatchSize     int    `yaml:"batch_size,omitempty"`
+	FlushInterval string `yaml:"flush_interval,omitempty"`
+	FlushJitter   string `yaml:"flush_jitter,omitempty"`
+	QueueSize     int    `yaml:"queue_size,omitempty"`
+
+	// Retries is a pointer, 0 disables retrying while unset retries 5 times
+	Retries         *int   `yaml:"retries,omitempty"`
+	RetryBackoff    string `yaml:"retry_backoff,omitempty"`
+	RetryMaxBackoff string `yaml:"retry_max_backoff,omitempty"`
+	RetryTimeout    string `yaml:"retry_timeout,omitempty"`
+
+	WALDir           string `yaml:"wal_dir,omitempty"`
+	WALMaxSize       int64  `yaml:"wal_max_size,omitempty"`
+	WALSegmentSize   int64  `yaml:"wal_segment_size,omitempty"`
+	WALRetryInterval string `yaml:"wal_retry_interval,omitempty"`
+}
+
+type semtechConfig struct {
+	Bind    string   `yaml:"bind"`
+	Outputs []string `yaml:"outputs,omitempty"`
+}
+
+type lorawanConfig struct {
+	Keys []lorawanKeyConfig `yaml:"keys,omitempty"`
+}
+
+type lorawanKeyConfig struct {
+	DevAddr string `yaml:"dev_addr" mapstructure:"dev_addr"`
+	NwkSKey string `yaml:"nwk_s_key" mapstructure:"nwk_s_key"`
+	AppSKey string `yaml:"app_s_key" mapstructure:"app_s_key"`
 }
 
 type mqttConfig struct {
@@ -60,6 +128,8 @@ type mqttConfig struct {
 	QoS      int          `yaml:"qos"`
 	ClientID string       `yaml:"clientid"`
 	Topic    string       `yaml:"topic"`
+	Template string       `yaml:"template,omitempty"`
+	Outputs  []string     `yaml:"outputs,omitempty"`
 	Debug    bool         `yaml:"debug"`
 }
 
@@ -73,16 +143,26 @@ Various different values for settings that are needed to use this tool are asked
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("configure called")
 
-		parser := setupParser()
-		influx := setupInflux()
-		mqtt := setupMQTT()
+		if migrateParserType() {
+			return
+		}
 
 		newConfig := &yamlConfig{
-			Parser:   parser,
-			InfluxDB: influx,
-			MQTT:     mqtt,
+			Input: setupInput(),
 		}
 
+		switch newConfig.Input {
+		case inputSemtech:
+			newConfig.InfluxDB = setupInflux()
+			newConfig.Semtech = setupSemtech()
+			newConfig.LoRaWAN = setupLoRaWAN()
+		default:
+			newConfig.Parser = setupParser()
+			newConfig.InfluxDB = setupInflux()
+			newConfig.MQTT = setupMQTT()
+		}
+		newConfig.DeadLetter = setupDeadLetter(newConfig.Input)
+
 		output, err := yaml.Marshal(newConfig)
 		if err != nil {
 			log.WithError(err).Fatal("failed generating yaml config")
@@ -118,6 +198,77 @@ func init() {
 	// configureCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
+// migrateParserType offers to rewrite the integer parser.type of an older
+// config file as the name of the parser, instead of creating a new config.
+func migrateParserType() bool {
+	path := viper.ConfigFileUsed()
+	typeParser := viper.GetString("parser.type")
+
+	name, ok := factory.MigrateType(typeParser)
+	if len(path) == 0 || !ok {
+		return false
+	}
+
+	if !prompt.Confirm("[Parser] rewrite type %s as %s in %s (Y/N)", typeParser, name, path) {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		log.WithError(err).Fatal("failed reading config file")
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithError(err).Fatal("failed reading config file")
+	}
+
+	output, err := renameParserType(buf, name)
+	if err != nil {
+		log.WithError(err).Fatal("failed rewriting parser type")
+	}
+
+	if err := ioutil.WriteFile(path, output, info.Mode()); err != nil {
+		log.WithError(err).Fatal("failed writing config file")
+	}
+
+	log.WithFields(log.Fields{
+		"path": path,
+		"type": name,
+	}).Info("parser type rewritten")
+
+	return true
+}
+
+// renameParserType sets parser.type of a yaml config, keeping the order of
+// the other settings.
+func renameParserType(buf []byte, name string) ([]byte, error) {
+	var config yaml.MapSlice
+	if err := yaml.Unmarshal(buf, &config); err != nil {
+		return nil, err
+	}
+
+	for i := range config {
+		if config[i].Key != "parser" {
+			continue
+		}
+
+		parserConfig, ok := config[i].Value.(yaml.MapSlice)
+		if !ok {
+			return nil, errors.New("parser is not a mapping")
+		}
+
+		for j := range parserConfig {
+			if parserConfig[j].Key == "type" {
+				parserConfig[j].Value = name
+				return yaml.Marshal(config)
+			}
+		}
+	}
+
+	return nil, errors.New("no parser.type in config")
+}
+
 func printHeader(text string) {
 	fmt.Printf("[===] %s [===]\n", text)
 }
@@ -126,17 +277,53 @@ func printFooter() {
 	fmt.Printf("\n")
 }
 
+func setupInput() string {
+	inputs := []string{inputMQTT, inputSemtech}
+
+	printHeader("Configure Input")
+	defer printFooter()
+
+	return inputs[prompt.Choose("[Input] type", inputs)]
+}
+
 func setupParser() parserConfig {
 	var config parserConfig
 
 	printHeader("Configure Parser")
 	defer printFooter()
 
-	config.Type = prompt.Choose("[Parser] type", factory.GetTypesList())
+	types := factory.GetTypesList()
+	config.Type = types[prompt.Choose("[Parser] type", types)]
+	if config.Type == jsonmap.Name {
+		config.JSONMap = setupJSONMap()
+	}
+	config.CayenneLPP = prompt.Confirm("[Parser] decode payloads as Cayenne LPP when the network server doesn't (Y/N)")
+
+	codecs := parser.LocationCodecNames()
+	config.Location = codecs[prompt.Choose("[Parser] location codec of coverage payloads", codecs)]
 
 	return config
 }
 
+func setupJSONMap() *jsonmap.Config {
+	var config jsonmap.Config
+	var name = "JSONMap"
+
+	fmt.Println("JSONPath expressions select the values, e.g. $.rxInfo (gateways) and @.rssi (per gateway)")
+
+	config.DeviceID = prompt.String("[%s] device id path", name)
+	config.Time = prompt.String("[%s] time path (default now)", name)
+	config.Payload = prompt.StringRequired("[%s] payload path (required)", name)
+	encodings := []string{jsonmap.EncodingBase64, jsonmap.EncodingHex}
+	config.Encoding = encodings[prompt.Choose("[JSONMap] payload encoding", encodings)]
+	config.Gateways = prompt.String("[%s] gateways array path", name)
+	config.Gateway.ID = prompt.String("[%s] gateway id path", name)
+	config.Gateway.RSSI = prompt.String("[%s] gateway rssi path", name)
+	config.Gateway.SNR = prompt.String("[%s] gateway snr path", name)
+
+	return &config
+}
+
 func setupInflux() influxdbConfig {
 	var config influxdbConfig
 	var name = "InfluxDB"
@@ -144,14 +331,51 @@ func setupInflux() influxdbConfig {
 	printHeader("Configure InfluxDB")
 	defer printFooter()
 
+	types := []string{outputInfluxDB, outputInfluxDB2}
+	config.Type = types[prompt.Choose("[InfluxDB] version (influxdb2 for 2.x and 3.x)", types)]
+
+	if config.Type == outputInfluxDB2 {
+		for !isValidServer(config.Server.Url) {
+			config.Server.Url = prompt.StringRequired("[%s] server in `scheme://host:port` format (required)", name)
+		}
+
+		config.Token = prompt.PasswordMasked("[%s] API token", name)
+		config.Org = prompt.String("[%s] organization", name)
+		config.Bucket = prompt.StringRequired("[%s] bucket (required)", name)
+		config.Gzip = prompt.Confirm("[%s] gzip writes (Y/N)", name)
+		config.Precision = prompt.String("[%s] precision: ns, us, ms or s (default `ms`)", name)
+		setupWrites(&config, name)
+
+		return config
+	}
+
 	setupServer(&config.Server, name)
 
 	config.Database = prompt.StringRequired("[%s] database (required)", name)
 	config.Precision = prompt.String("[%s] precision (default `ms`)", name)
+	setupWrites(&config, name)
 
 	return config
 }
 
+func setupWrites(config *influxdbConfig, name string) {
+	config.WALDir = prompt.String("[%s] directory to buffer metrics during outages (default none)", name)
+
+	if !prompt.Confirm("[%s] batch writes in the background (Y/N)", name) {
+		return
+	}
+
+	size, err := strconv.Atoi(prompt.String("[%s] batch size (default `1000`)", name))
+	if err != nil {
+		size = 1000
+	}
+	config.BatchSize = size
+	config.FlushInterval = prompt.String("[%s] flush interval (default `1s`)", name)
+	if len(config.FlushInterval) == 0 {
+		config.FlushInterval = "1s"
+	}
+}
+
 func setupMQTT() mqttConfig {
 	var config mqttConfig
 	var name = "MQTT"
@@ -164,12 +388,71 @@ func setupMQTT() mqttConfig {
 	config.QoS = prompt.Choose("[MQTT] Quality of Service (default `0`)", []string{"0", "1", "2"})
 	config.ClientID = prompt.String("[%s] Client ID", name)
 	config.Topic = prompt.StringRequired("[%s] Topic (eg. `+/devices/+/up`)", name)
+	for {
+		config.Template = prompt.String("[%s] Topic template to extract tags (eg. `{app_id}/devices/{device_id}/up`)", name)
+		if len(config.Template) == 0 {
+			break
+		}
+		if _, err := topic.Compile(config.Template); err != nil {
+			log.WithError(err).Warn("invalid topic template")
+			continue
+		}
+		break
+	}
 
 	config.Debug = prompt.Confirm("[%s] Debug (Y/N)", name)
 
 	return config
 }
 
+func setupDeadLetter(input string) deadletterConfig {
+	var config deadletterConfig
+	var name = "DeadLetter"
+
+	printHeader("Configure Dead Letters")
+	defer printFooter()
+
+	config.File = prompt.String("[%s] file for messages that fail (default none)", name)
+	if input == inputMQTT {
+		config.Topic = prompt.String("[%s] mqtt topic for messages that fail (default none)", name)
+	}
+
+	return config
+}
+
+func setupSemtech() semtechConfig {
+	var config semtechConfig
+	var name = "Semtech"
+
+	printHeader("Configure Semtech Packet Forwarder")
+	defer printFooter()
+
+	config.Bind = prompt.String("[%s] udp bind address (default `:1700`)", name)
+	if len(config.Bind) == 0 {
+		config.Bind = ":1700"
+	}
+
+	return config
+}
+
+func setupLoRaWAN() lorawanConfig {
+	var config lorawanConfig
+	var name = "LoRaWAN"
+
+	printHeader("Configure LoRaWAN Session Keys")
+	defer printFooter()
+
+	for prompt.Confirm("[%s] add session keys for a device (Y/N)", name) {
+		config.Keys = append(config.Keys, lorawanKeyConfig{
+			DevAddr: prompt.StringRequired("[%s] device address (hex, required)", name),
+			NwkSKey: prompt.StringRequired("[%s] NwkSKey (hex, required)", name),
+			AppSKey: prompt.StringRequired("[%s] AppSKey (hex, required)", name),
+		})
+	}
+
+	return config
+}
+
 func setupServer(config *serverConfig, name string) {
 	for !isValidServer(config.Url) {
 		config.Url = prompt.StringRequired("[%s] server in `scheme://host:port` format (required)", name)
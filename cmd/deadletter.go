@@ -0,0 +1,192 @@
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-mqtt/database"
+	"github.com/bullettime/lora-mqtt/deadletter"
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/bullettime/lora-mqtt/router"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var deadletterReason string
+
+// deadletterCmd represents the deadletter command
+var deadletterCmd = &cobra.Command{
+	Use:   "deadletter",
+	Short: "List and re-ingest dead letters",
+	Long: `lora-mqtt deadletter works with the messages in the dead letter file (deadletter.file),
+the messages that could not be parsed, had values that don't fit the schemas or could not be
+written to the database.`,
+}
+
+var deadletterListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the dead letters",
+	Long:  `lora-mqtt deadletter list prints the time, reason, route, output, topic and error of every dead letter.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		checkConfig()
+
+		letters := readDeadLetters()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tREASON\tROUTE\tOUTPUT\tTOPIC\tSIZE\tERROR")
+		for _, letter := range letters {
+			if !matchReason(letter) {
+				continue
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", letter.Time.Format(time.RFC3339), letter.Reason,
+				letter.Route, letter.Output, letter.Topic, len(letter.Payload), letter.Error)
+		}
+		w.Flush()
+	},
+}
+
+var deadletterReingestCmd = &cobra.Command{
+	Use:   "reingest",
+	Short: "Parse and write the dead letters again",
+	Long: `lora-mqtt deadletter reingest routes, parses and writes the dead letters again with the
+current config, e.g. after a decoder is fixed. The metrics an output rejected are written to
+that output again. The outputs write without batching or a wal, the letters that still fail are
+kept.
+
+Stop lora-mqtt first, the dead letters it stores while re-ingesting are lost.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		checkConfig()
+
+		path := viper.GetString("deadletter.file")
+		letters := readDeadLetters()
+
+		setupParsing()
+
+		// the letters are only removed when the output confirmed the write of
+		// their metrics, not when they're batched or buffered on disk
+		direct = true
+		outputs := make(outputs)
+
+		var r *router.Router
+		var semtech parser.Parser
+
+		var failed []deadletter.Letter
+		ingested := 0
+		for _, letter := range letters {
+			if !matchReason(letter) {
+				failed = append(failed, letter)
+				continue
+			}
+
+			var again deadletter.Letter
+			var ok bool
+			switch {
+			case len(letter.Output) > 0:
+				again, ok = reingestMetrics(outputs, letter)
+			case letter.Route == inputSemtech:
+				if semtech == nil {
+					semtech = newSemtechParser()
+				}
+				again, ok = ingestPacket(semtech, outputs.all(viper.GetStringSlice("semtech.outputs")), letter.Topic, letter.Payload)
+			default:
+				if r == nil {
+					r = createRouter(outputs)
+				}
+				if _, found := r.Route(letter.Topic); !found {
+					log.WithField("topic", letter.Topic).Warn("no route for dead letter")
+					again, ok = letter, true
+					break
+				}
+				again, ok = ingest(r, letter.Topic, letter.Payload)
+			}
+
+			if ok {
+				again.Time = letter.Time
+				failed = append(failed, again)
+				continue
+			}
+			ingested++
+		}
+
+		outputs.close()
+
+		if err := deadletter.Replace(path, failed); err != nil {
+			log.WithError(err).Fatal("can't save the remaining dead letters")
+		}
+
+		log.WithFields(log.Fields{
+			"ingested":  ingested,
+			"remaining": len(failed),
+		}).Info("re-ingested dead letters")
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(deadletterCmd)
+	deadletterCmd.AddCommand(deadletterListCmd)
+	deadletterCmd.AddCommand(deadletterReingestCmd)
+
+	deadletterCmd.PersistentFlags().StringVar(&deadletterReason, "reason", "",
+		fmt.Sprintf("only dead letters with this reason (%s, %s, %s or %s)", deadletter.ReasonParse, deadletter.ReasonUnclassified, deadletter.ReasonSchema, deadletter.ReasonWrite))
+	deadletterReingestCmd.Flags().StringVarP(&metricName, "metric-name", "m", parser.LocationData, "define custom metric name")
+}
+
+func readDeadLetters() []deadletter.Letter {
+	path := viper.GetString("deadletter.file")
+	if len(path) == 0 {
+		log.Fatal("no dead letter file configured (deadletter.file)")
+	}
+
+	letters, err := deadletter.Read(path)
+	if err != nil {
+		log.WithError(err).Fatal("can't read dead letters")
+	}
+
+	return letters
+}
+
+// reingestMetrics writes the metrics of a letter that were rejected by an
+// output to that output again.
+func reingestMetrics(outputs outputs, letter deadletter.Letter) (deadletter.Letter, bool) {
+	metrics, err := letter.Metrics()
+	if err != nil {
+		log.WithError(err).WithField("output", letter.Output).Warn("invalid dead letter")
+		return letter, true
+	}
+
+	err = outputs.get(letter.Output).Write(metrics)
+	if err != nil {
+		log.WithError(err).WithField("output", letter.Output).Error("could not write metrics to database")
+		return deadletter.NewRejected(letter.Output, database.Failed(metrics, err), err), true
+	}
+
+	return deadletter.Letter{}, false
+}
+
+func matchReason(letter deadletter.Letter) bool {
+	return len(deadletterReason) == 0 || letter.Reason == deadletterReason
+}
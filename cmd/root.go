@@ -26,6 +26,7 @@ import (
 	"os/signal"
 	"path"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/apex/log"
@@ -33,23 +34,39 @@ import (
 	textHandler "github.com/apex/log/handlers/logfmt"
 	multiHandler "github.com/apex/log/handlers/multi"
 	"github.com/bullettime/lora-mqtt/database"
-	"github.com/bullettime/lora-mqtt/database/influxdb"
+	"github.com/bullettime/lora-mqtt/database/multi"
+	"github.com/bullettime/lora-mqtt/deadletter"
 	"github.com/bullettime/lora-mqtt/input"
+	"github.com/bullettime/lora-mqtt/model"
 	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/bullettime/lora-mqtt/parser/autodetect"
+	"github.com/bullettime/lora-mqtt/parser/cayennelpp"
 	"github.com/bullettime/lora-mqtt/parser/factory"
+	"github.com/bullettime/lora-mqtt/parser/javascript"
+	"github.com/bullettime/lora-mqtt/parser/layout"
+	"github.com/bullettime/lora-mqtt/parser/lorawan"
+	"github.com/bullettime/lora-mqtt/parser/semtechjson"
+	"github.com/bullettime/lora-mqtt/router"
 	"github.com/bullettime/lora-mqtt/util"
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"regexp"
+)
+
+const (
+	inputMQTT    = "mqtt"
+	inputSemtech = "semtech"
 )
 
 var (
-	cfgFile    string
-	logFile    *os.File
-	verbose    bool
-	debug      bool
-	metricName string
+	cfgFile     string
+	logFile     *os.File
+	verbose     bool
+	debug       bool
+	metricName  string
+	schemas     model.Schemas
+	deadLetters deadletter.Store
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -125,7 +142,11 @@ func init() {
 	// when this action is called directly.
 	RootCmd.Flags().StringVarP(&metricName, "metric-name", "m", parser.LocationData, "define custom metric name")
 
+	viper.SetDefault("input", inputMQTT)
+	viper.SetDefault("parser.type", factory.DefaultType)
 	viper.SetDefault("influxdb.precision", "ms")
+	viper.SetDefault("semtech.bind", ":1700")
+	viper.SetDefault("parser.location", parser.DefaultLocationCodec)
 	viper.SetDefault("mqtt.clientid", fmt.Sprintf("lora-mqtt-%s", util.RandomString(4)))
 }
 
@@ -167,40 +188,51 @@ func checkConfig() {
 }
 
 func start() {
+	setupParsing()
+
+	outputs := make(outputs)
+
+	switch viper.GetString("input") {
+	case inputSemtech:
+		startSemtech(outputs)
+	case inputMQTT:
+		startMQTT(outputs)
+	default:
+		log.Fatalf("unknown input: %s", viper.GetString("input"))
+	}
+}
+
+// setupParsing loads the schemas of the config.
+func setupParsing() {
+	schemas = loadSchemas()
+}
+
+// newSemtechParser returns the parser of the semtech packets, with the
+// session keys of the config.
+func newSemtechParser() parser.Parser {
 	if len(metricName) == 0 {
 		log.Fatal("you need to specify a valid metric name")
 	}
 	log.WithField("name", metricName).Debug("metric")
 
-	p, err := factory.CreateParser(factory.TypeParser(viper.GetInt("parser.type")), metricName)
+	keys, err := loadKeys()
 	if err != nil {
-		log.WithError(err).Fatal("can't create parser")
+		log.WithError(err).Fatal("invalid lorawan session keys")
 	}
+	log.WithField("devices", len(keys)).Debug("LoRaWAN session keys")
 
-	influxOptions := influxdb.InfluxOptions{
-		Server:    viper.GetString("influxdb.server.url"),
-		Username:  viper.GetString("influxdb.server.username"),
-		Password:  viper.GetString("influxdb.server.password"),
-		Database:  viper.GetString("influxdb.database"),
-		Precision: viper.GetString("influxdb.precision"),
-	}
-	log.WithFields(log.Fields{
-		"Server":    influxOptions.Server,
-		"Username":  influxOptions.Username,
-		"Database":  influxOptions.Database,
-		"Precision": influxOptions.Precision,
-	}).Debug("InfluxDB Options")
-	db := influxdb.New(influxOptions)
-
-	err = db.Connect()
+	p, err := semtechjson.NewWithKeys(metricName, keys)
 	if err != nil {
-		log.WithError(err).Fatal("can't connect to influxdb")
+		log.WithError(err).Fatal("can't create parser")
 	}
-	defer db.Close()
-	log.WithFields(log.Fields{
-		"server":   influxOptions.Server,
-		"database": influxOptions.Database,
-	}).Info("connected to influxdb")
+	setFieldDecoder(p, newFieldDecoder())
+	setLocationCodec(p, viper.GetString("parser.location"))
+
+	return p
+}
+
+func startMQTT(outputs outputs) {
+	r := createRouter(outputs)
 
 	mqttOptions := input.MQTTOptions{
 		Server:   viper.GetString("mqtt.server.url"),
@@ -219,23 +251,280 @@ func start() {
 	}).Debug("MQTT Options")
 	mqtt := input.New(mqttOptions)
 
-	err = mqtt.Connect()
+	err := mqtt.Connect()
 	if err != nil {
 		log.WithError(err).Fatal("can't connect to mqtt")
 	}
 	defer mqtt.Close()
 
-	err = mqtt.Subscribe(viper.GetString("mqtt.topic"))
+	// the outputs are closed first, the metrics they reject in the last
+	// flush are dead letters
+	deadLetters = openDeadLetters(mqtt)
+	defer deadLetters.Close()
+	defer outputs.close()
+
+	for _, filter := range r.Filters() {
+		err = mqtt.Subscribe(filter)
+		if err != nil {
+			log.WithError(err).Fatalf("can't subscribe to topic: %s", filter)
+		}
+	}
+
+	go receiver(mqtt, r)
+
+	waitForSignal()
+
+	for _, route := range r.Routes() {
+		if counter, ok := route.Parser.(unclassifiedCounter); ok {
+			log.WithFields(log.Fields{
+				"route": route.Name,
+				"count": counter.Unclassified(),
+			}).Info("messages that could not be classified")
+		}
+
+		logOutputStatus(route.Name, route.Output)
+	}
+}
+
+func startSemtech(outputs outputs) {
+	db := outputs.all(viper.GetStringSlice("semtech.outputs"))
+	p := newSemtechParser()
+
+	semtechOptions := input.SemtechOptions{
+		Bind: viper.GetString("semtech.bind"),
+	}
+	log.WithField("Bind", semtechOptions.Bind).Debug("Semtech Options")
+	semtech := input.NewSemtech(semtechOptions)
+
+	err := semtech.Connect()
 	if err != nil {
-		log.WithError(err).Fatalf("can't subscribe to topic: %s", viper.GetString("mqtt.topic"))
+		log.WithError(err).Fatal("can't start semtech packet forwarder server")
 	}
+	defer semtech.Close()
+
+	deadLetters = openDeadLetters(nil)
+	defer deadLetters.Close()
+	defer outputs.close()
 
-	go receiver(mqtt, p, db)
+	go semtechReceiver(semtech, p, db)
 
 	waitForSignal()
+
+	logOutputStatus(inputSemtech, db)
+}
+
+func createParser(typeParser, metric string) parser.Parser {
+	if name, ok := factory.MigrateType(typeParser); ok {
+		log.WithFields(log.Fields{
+			"old": typeParser,
+			"new": name,
+		}).Warn("the parser type should be the name of the parser, run lora-mqtt configure to update your config")
+		typeParser = name
+	}
+	log.WithFields(log.Fields{
+		"type":   typeParser,
+		"metric": metric,
+	}).Debug("parser")
+
+	p, err := factory.CreateParser(typeParser, metric, viperConfig("parser."+typeParser))
+	if err != nil {
+		log.WithError(err).Fatal("can't create parser")
+	}
+
+	return p
+}
+
+// unclassifiedCounter is implemented by the parser that detects the format
+// of the messages.
+type unclassifiedCounter interface {
+	Unclassified() uint64
+}
+
+// logOutputStatus logs the writes to every output of a route that writes to
+// more than one.
+func logOutputStatus(name string, db database.Database) {
+	m, ok := db.(*multi.Multi)
+	if !ok {
+		return
+	}
+
+	for _, status := range m.Status() {
+		entry := log.WithFields(log.Fields{
+			"route":    name,
+			"output":   status.Name,
+			"writes":   status.Writes,
+			"failures": status.Failures,
+		})
+		if status.LastError != nil {
+			entry = entry.WithError(status.LastError)
+		}
+		entry.Info("output status")
+	}
+}
+
+// viperConfig decodes the config block at its key for a parser.
+type viperConfig string
+
+func (c viperConfig) Decode(v interface{}) error {
+	return viper.UnmarshalKey(string(c), v)
+}
+
+// loadSchemas returns the field types of the measurements in the schemas
+// section of the config.
+func loadSchemas() model.Schemas {
+	var config map[string]map[string]string
+
+	if err := viper.UnmarshalKey("schemas", &config); err != nil {
+		log.WithError(err).Fatal("can't read schemas")
+	}
+
+	s := make(model.Schemas, len(config))
+	for measurement, types := range config {
+		schema, err := model.NewSchema(types)
+		if err != nil {
+			log.WithError(err).Fatalf("invalid schema for %s", measurement)
+		}
+		s[measurement] = schema
+	}
+
+	return s
+}
+
+// applySchemas returns the metrics with typed fields. Values that don't fit
+// the schema are removed so they can't fail the whole batch, the metrics
+// without fields left are dropped. The error has the rejected values.
+func applySchemas(metrics []model.Metric) ([]model.Metric, error) {
+	var rejected []string
+
+	valid := metrics[:0]
+	for _, metric := range metrics {
+		err := schemas.Apply(metric)
+		if err != nil {
+			log.WithError(err).Warn("rejected fields")
+			rejected = append(rejected, err.Error())
+		}
+
+		if e, ok := err.(*model.FieldsError); ok && e.Empty {
+			continue
+		}
+		valid = append(valid, metric)
+	}
+
+	if len(rejected) > 0 {
+		return valid, errors.New(strings.Join(rejected, "; "))
+	}
+
+	return valid, nil
+}
+
+// newFieldDecoder returns the configured payload decoders, or nil when there
+// are none.
+func newFieldDecoder() parser.FieldDecoder {
+	var decoders parser.FieldDecoders
+
+	var scripts []javascript.Script
+	if err := viper.UnmarshalKey("parser.javascript.scripts", &scripts); err != nil {
+		log.WithError(err).Fatal("can't read javascript payload formatters")
+	}
+
+	if len(scripts) > 0 {
+		var options javascript.Options
+		if err := viper.UnmarshalKey("parser.javascript", &options); err != nil {
+			log.WithError(err).Fatal("can't read javascript options")
+		}
+
+		decoder, err := javascript.New(scripts, options)
+		if err != nil {
+			log.WithError(err).Fatal("invalid javascript payload formatter")
+		}
+		decoders = append(decoders, decoder)
+		log.WithField("scripts", len(scripts)).Debug("decoding payloads with javascript")
+	}
+
+	var layouts []layout.Layout
+	if err := viper.UnmarshalKey("parser.layouts", &layouts); err != nil {
+		log.WithError(err).Fatal("can't read payload layouts")
+	}
+
+	if len(layouts) > 0 {
+		decoder, err := layout.New(layouts)
+		if err != nil {
+			log.WithError(err).Fatal("invalid payload layout")
+		}
+		decoders = append(decoders, decoder)
+		log.WithField("layouts", len(layouts)).Debug("decoding payloads with layouts")
+	}
+
+	if viper.GetBool("parser.cayennelpp") {
+		decoders = append(decoders, cayennelpp.New())
+		log.Debug("decoding payloads as cayenne lpp")
+	}
+
+	switch len(decoders) {
+	case 0:
+		return nil
+	case 1:
+		return decoders[0]
+	default:
+		return decoders
+	}
+}
+
+func setFieldDecoder(p parser.Parser, decoder parser.FieldDecoder) {
+	if decoder == nil {
+		return
+	}
+
+	setter, ok := p.(parser.FieldDecoderSetter)
+	if !ok {
+		log.Warn("parser doesn't support payload decoding, ignoring payload decoders")
+		return
+	}
+
+	setter.SetFieldDecoder(decoder)
+}
+
+// setLocationCodec sets the location codec with the name on the parser, which
+// decodes the location of coverage uplinks with it.
+func setLocationCodec(p parser.Parser, name string) {
+	codec, err := parser.GetLocationCodec(name)
+	if err != nil {
+		log.WithError(err).Fatal("invalid location codec")
+	}
+	log.WithField("codec", name).Debug("location codec")
+
+	if setter, ok := p.(parser.LocationCodecSetter); ok {
+		setter.SetLocationCodec(codec)
+	}
 }
 
-func receiver(m *input.MQTT, p parser.Parser, db database.Database) {
+func loadKeys() (lorawan.KeyTable, error) {
+	var config []lorawanKeyConfig
+
+	if err := viper.UnmarshalKey("lorawan.keys", &config); err != nil {
+		return nil, err
+	}
+
+	if len(config) == 0 {
+		return nil, nil
+	}
+
+	table := lorawan.KeyTable{}
+	for _, c := range config {
+		keys, err := lorawan.NewKeys(c.NwkSKey, c.AppSKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "device %s", c.DevAddr)
+		}
+
+		if err := table.Add(c.DevAddr, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	return table, nil
+}
+
+func receiver(m *input.MQTT, r *router.Router) {
 	for {
 		select {
 		case <-m.Done:
@@ -247,33 +536,160 @@ func receiver(m *input.MQTT, p parser.Parser, db database.Database) {
 				"payload": string(msg.Payload()),
 			}).Debug("received message")
 
-			re := regexp.MustCompile(`\/devices\/(\w+)\/up`)
-			submatch := re.FindStringSubmatch(msg.Topic())
-
-			deviceID := "unkown"
-			if len(submatch) > 1 {
-				deviceID = submatch[1]
+			// the dead letters published by this tool can't be parsed
+			if msg.Topic() == viper.GetString("deadletter.topic") {
+				continue
 			}
 
-			switch factory.TypeParser(viper.GetInt("parser.type")) {
-			case factory.DingNet:
-				p.SetDefaultTags(map[string]string{"device_id": deviceID})
+			if letter, failed := ingest(r, msg.Topic(), msg.Payload()); failed {
+				storeDeadLetter(letter)
 			}
+		}
+	}
+}
 
-			metrics, err := p.Parse(msg.Payload())
-			if err != nil {
-				log.WithError(err).Warnf("could not parse payload: %s", string(msg.Payload()))
-				continue
-			}
+// ingest parses a message and writes its metrics to the output of its route,
+// and returns a dead letter when the message can't be parsed, classified or
+// written.
+func ingest(r *router.Router, topic string, payload []byte) (deadletter.Letter, bool) {
+	route, ok := r.Route(topic)
+	if !ok {
+		log.WithField("topic", topic).Debug("no route for topic")
+		return deadletter.Letter{}, false
+	}
 
-			err = db.Write(metrics)
-			if err != nil {
-				log.WithError(err).Error("could not write metrics to database")
+	if route.Template != nil {
+		tags, ok := route.TopicTags(topic)
+		if !ok {
+			log.WithFields(log.Fields{
+				"route":    route.Name,
+				"topic":    topic,
+				"template": route.Template.String(),
+			}).Warn("topic doesn't match the template")
+
+			writeUnmatchedTopic(route, topic)
+		}
+		route.Parser.SetDefaultTags(tags)
+	}
+
+	metrics, err := parser.ParseTopic(route.Parser, topic, payload)
+	if errors.Cause(err) == autodetect.UnclassifiedError {
+		return deadletter.New(route.Name, topic, payload, deadletter.ReasonUnclassified, err), true
+	}
+	if err != nil {
+		log.WithError(err).Warnf("could not parse payload: %s", string(payload))
+		return deadletter.New(route.Name, topic, payload, deadletter.ReasonParse, err), true
+	}
+
+	metrics, rejected := applySchemas(metrics)
+
+	err = route.Output.Write(metrics)
+	if err != nil {
+		log.WithError(err).WithField("route", route.Name).Error("could not write metrics to database")
+		return deadletter.New(route.Name, topic, payload, deadletter.ReasonWrite, err), true
+	}
+
+	if rejected != nil {
+		return deadletter.New(route.Name, topic, payload, deadletter.ReasonSchema, rejected), true
+	}
+
+	return deadletter.Letter{}, false
+}
+
+// openDeadLetters opens the dead letter file and topic of the config, the
+// topic is only used when there is a publisher.
+func openDeadLetters(publisher deadletter.Publisher) deadletter.Stores {
+	var stores deadletter.Stores
+
+	if path := viper.GetString("deadletter.file"); len(path) > 0 {
+		store, err := deadletter.NewFileStore(path, viper.GetInt64("deadletter.max_size"), viper.GetInt("deadletter.max_files"))
+		if err != nil {
+			log.WithError(err).Fatal("can't open dead letter file")
+		}
+		log.WithField("file", path).Debug("dead letters")
+		stores = append(stores, store)
+	}
+
+	if topic := viper.GetString("deadletter.topic"); len(topic) > 0 && publisher != nil {
+		store, err := deadletter.NewMQTTStore(publisher, topic)
+		if err != nil {
+			log.WithError(err).Fatal("invalid dead letter topic")
+		}
+		log.WithField("topic", topic).Debug("dead letters")
+		stores = append(stores, store)
+	}
+
+	return stores
+}
+
+func storeDeadLetter(letter deadletter.Letter) {
+	if deadLetters == nil {
+		return
+	}
+
+	if err := deadLetters.Store(letter); err != nil {
+		log.WithError(err).WithField("topic", letter.Topic).Error("could not store dead letter")
+	}
+}
+
+func writeUnmatchedTopic(route router.Route, topic string) {
+	metric, err := route.UnmatchedTopic(topic)
+	if err != nil {
+		log.WithError(err).Error("could not create unmatched topic metric")
+		return
+	}
+
+	err = route.Output.Write([]model.Metric{metric})
+	if err != nil {
+		log.WithError(err).WithField("route", route.Name).Error("could not write unmatched topic metric to database")
+	}
+}
+
+func semtechReceiver(s *input.Semtech, p parser.Parser, db database.Database) {
+	for {
+		select {
+		case <-s.Done:
+			log.Debug("shutting down semtech receiver")
+			return
+		case packet := <-s.Incoming:
+			log.WithFields(log.Fields{
+				"gateway": packet.GatewayEUI,
+				"payload": string(packet.Payload),
+			}).Debug("received packet")
+
+			if letter, ok := ingestPacket(p, db, packet.GatewayEUI, packet.Payload); ok {
+				storeDeadLetter(letter)
 			}
 		}
 	}
 }
 
+// ingestPacket parses and writes a packet forwarded by gateway, and returns
+// the dead letter when that fails.
+func ingestPacket(p parser.Parser, db database.Database, gateway string, payload []byte) (deadletter.Letter, bool) {
+	p.SetDefaultTags(map[string]string{"gateway_id": gateway})
+
+	metrics, err := p.Parse(payload)
+	if err != nil {
+		log.WithError(err).Warnf("could not parse payload: %s", string(payload))
+		return deadletter.New(inputSemtech, gateway, payload, deadletter.ReasonParse, err), true
+	}
+
+	metrics, rejected := applySchemas(metrics)
+
+	err = db.Write(metrics)
+	if err != nil {
+		log.WithError(err).Error("could not write metrics to database")
+		return deadletter.New(inputSemtech, gateway, payload, deadletter.ReasonWrite, err), true
+	}
+
+	if rejected != nil {
+		return deadletter.New(inputSemtech, gateway, payload, deadletter.ReasonSchema, rejected), true
+	}
+
+	return deadletter.Letter{}, false
+}
+
 func waitForSignal() {
 	ch := make(chan os.Signal)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
@@ -0,0 +1,416 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-mqtt/database"
+	"github.com/bullettime/lora-mqtt/database/batch"
+	"github.com/bullettime/lora-mqtt/database/influxdb"
+	"github.com/bullettime/lora-mqtt/database/influxdb2"
+	"github.com/bullettime/lora-mqtt/database/multi"
+	"github.com/bullettime/lora-mqtt/database/retry"
+	"github.com/bullettime/lora-mqtt/database/wal"
+	"github.com/bullettime/lora-mqtt/deadletter"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/router"
+	"github.com/bullettime/lora-mqtt/topic"
+	"github.com/spf13/viper"
+)
+
+type routeConfig struct {
+	Name     string            `yaml:"name,omitempty" mapstructure:"name"`
+	Topic    string            `yaml:"topic,omitempty" mapstructure:"topic"`
+	Template string            `yaml:"template,omitempty" mapstructure:"template"`
+	Parser   string            `yaml:"parser,omitempty" mapstructure:"parser"`
+	Metric   string            `yaml:"metric,omitempty" mapstructure:"metric"`
+	Location string            `yaml:"location,omitempty" mapstructure:"location"`
+	Tags     map[string]string `yaml:"tags,omitempty" mapstructure:"tags"`
+	Output   string            `yaml:"output,omitempty" mapstructure:"output"`
+	Outputs  []string          `yaml:"outputs,omitempty" mapstructure:"outputs"`
+}
+
+// loadRoutes returns the configured routes, or a single route for mqtt.topic,
+// mqtt.template and parser.type when there are none.
+func loadRoutes() []routeConfig {
+	var routes []routeConfig
+
+	if err := viper.UnmarshalKey("routes", &routes); err != nil {
+		log.WithError(err).Fatal("can't read routes")
+	}
+
+	if len(routes) == 0 {
+		routes = append(routes, routeConfig{
+			Topic:    viper.GetString("mqtt.topic"),
+			Template: viper.GetString("mqtt.template"),
+			Outputs:  viper.GetStringSlice("mqtt.outputs"),
+		})
+	}
+
+	return routes
+}
+
+func createRouter(outputs outputs) *router.Router {
+	r := router.New()
+	decoder := newFieldDecoder()
+
+	for i, config := range loadRoutes() {
+		var template *topic.Template
+		if len(config.Template) > 0 {
+			var err error
+			template, err = topic.Compile(config.Template)
+			if err != nil {
+				log.WithError(err).Fatalf("route %d has an invalid topic template", i)
+			}
+
+			// the template is enough to subscribe to the topic
+			if len(config.Topic) == 0 {
+				config.Topic = template.Filter()
+			}
+		}
+
+		if len(config.Name) == 0 {
+			config.Name = config.Topic
+		}
+
+		// routes fall back on the parser and location codec of the config and
+		// the metric name of the command line
+		if len(config.Parser) == 0 {
+			config.Parser = viper.GetString("parser.type")
+		}
+		if len(config.Location) == 0 {
+			config.Location = viper.GetString("parser.location")
+		}
+		if len(config.Metric) == 0 {
+			config.Metric = metricName
+		}
+		if len(config.Metric) == 0 {
+			log.Fatalf("route %d (%s) needs a metric name", i, config.Name)
+		}
+
+		p := createParser(config.Parser, config.Metric)
+		setFieldDecoder(p, decoder)
+		setLocationCodec(p, config.Location)
+		if len(config.Tags) > 0 {
+			p.SetDefaultTags(config.Tags)
+		}
+
+		err := r.Add(router.Route{
+			Name:     config.Name,
+			Filter:   config.Topic,
+			Template: template,
+			Tags:     config.Tags,
+			Parser:   p,
+			Output:   outputs.all(append([]string{config.Output}, config.Outputs...)),
+		})
+		if err != nil {
+			log.WithError(err).Fatal("invalid route")
+		}
+
+		log.WithFields(log.Fields{
+			"topic":    config.Topic,
+			"template": config.Template,
+			"parser":   config.Parser,
+			"metric":   config.Metric,
+			"location": config.Location,
+			"output":   config.Output,
+			"outputs":  config.Outputs,
+		}).Debug("route")
+	}
+
+	return r
+}
+
+const (
+	outputInfluxDB  = "influxdb"
+	outputInfluxDB2 = "influxdb2"
+)
+
+// outputs connects to the outputs the first time they are used. The default
+// output (without a name) is the influxdb section of the config, the others
+// are defined in the outputs section. The type of an output is influxdb (1.x)
+// or influxdb2 (2.x and 3.x). Writes can be batched (batch_size,
+// flush_interval) and failed writes are buffered on disk (wal_dir) or
+// retried.
+//
+// When retries isn't set a write is retried 5 times, 0 disables retrying and
+// a negative number retries forever. retry_timeout limits the time a write
+// is retried. A write that isn't batched blocks the messages that are
+// received while it's retried, so it's retried for at most 5s when
+// retry_timeout isn't set.
+type outputs map[string]database.Database
+
+// defaultRetryTimeout is the maximum time a write that isn't batched is
+// retried.
+const defaultRetryTimeout = 5 * time.Second
+
+// direct makes the outputs write to the database without batching or a wal,
+// so every write has succeeded or failed when it returns (deadletter
+// reingest).
+var direct bool
+
+func (o outputs) get(name string) database.Database {
+	// in a list of outputs the default output is called influxdb
+	if name == outputInfluxDB && !viper.IsSet("outputs."+name) {
+		name = ""
+	}
+
+	if db, ok := o[name]; ok {
+		return db
+	}
+
+	key := "influxdb"
+	if len(name) > 0 {
+		key = "outputs." + name
+		if !viper.IsSet(key) {
+			log.Fatalf("unknown output: %s", name)
+		}
+	}
+
+	precision := viper.GetString(key + ".precision")
+	if len(precision) == 0 {
+		precision = viper.GetString("influxdb.precision")
+	}
+
+	var db database.Database
+	var fields log.Fields
+	switch viper.GetString(key + ".type") {
+	case "", outputInfluxDB:
+		db, fields = newInfluxDB(key, precision)
+	case outputInfluxDB2:
+		db, fields = newInfluxDB2(key, precision)
+	default:
+		log.Fatalf("unknown output type: %s", viper.GetString(key+".type"))
+	}
+
+	// writes are batched in the background when the output has a batch size
+	// or a flush interval
+	batched := !direct && (viper.IsSet(key+".batch_size") || viper.IsSet(key+".flush_interval"))
+
+	// failed writes are buffered on disk when the output has a wal directory,
+	// otherwise they're retried unless retries is 0
+	if !direct && viper.IsSet(key+".wal_dir") {
+		walOptions := wal.Options{
+			Dir:           viper.GetString(key + ".wal_dir"),
+			MaxSize:       viper.GetInt64(key + ".wal_max_size"),
+			SegmentSize:   viper.GetInt64(key + ".wal_segment_size"),
+			RetryInterval: viper.GetDuration(key + ".wal_retry_interval"),
+			Rejected:      rejected(name),
+			Invalid:       invalid(name),
+		}
+		log.WithFields(log.Fields{
+			"Output":        name,
+			"Dir":           walOptions.Dir,
+			"MaxSize":       walOptions.MaxSize,
+			"SegmentSize":   walOptions.SegmentSize,
+			"RetryInterval": walOptions.RetryInterval,
+		}).Debug("WAL Options")
+
+		var err error
+		db, err = wal.New(db, walOptions)
+		if err != nil {
+			log.WithError(err).Fatal("invalid wal")
+		}
+	} else if !viper.IsSet(key+".retries") || viper.GetInt(key+".retries") != 0 {
+		retryOptions := retry.Options{
+			MaxRetries:     viper.GetInt(key + ".retries"),
+			InitialBackoff: viper.GetDuration(key + ".retry_backoff"),
+			MaxBackoff:     viper.GetDuration(key + ".retry_max_backoff"),
+			MaxElapsed:     viper.GetDuration(key + ".retry_timeout"),
+		}
+		if !batched && retryOptions.MaxElapsed <= 0 {
+			retryOptions.MaxElapsed = defaultRetryTimeout
+		}
+		log.WithFields(log.Fields{
+			"Output":         name,
+			"MaxRetries":     retryOptions.MaxRetries,
+			"InitialBackoff": retryOptions.InitialBackoff,
+			"MaxBackoff":     retryOptions.MaxBackoff,
+			"MaxElapsed":     retryOptions.MaxElapsed,
+		}).Debug("Retry Options")
+		db = retry.New(db, retryOptions)
+	}
+
+	if batched {
+		batchOptions := batch.Options{
+			Size:          viper.GetInt(key + ".batch_size"),
+			FlushInterval: viper.GetDuration(key + ".flush_interval"),
+			Jitter:        viper.GetDuration(key + ".flush_jitter"),
+			QueueSize:     viper.GetInt(key + ".queue_size"),
+			Rejected:      rejected(name),
+		}
+		log.WithFields(log.Fields{
+			"Output":        name,
+			"Size":          batchOptions.Size,
+			"FlushInterval": batchOptions.FlushInterval,
+			"Jitter":        batchOptions.Jitter,
+			"QueueSize":     batchOptions.QueueSize,
+		}).Debug("Batch Options")
+		db = batch.New(db, batchOptions)
+	}
+
+	err := db.Connect()
+	if err != nil {
+		log.WithError(err).WithField("output", name).Fatal("can't connect to output")
+	}
+	log.WithFields(fields).WithField("output", name).Info("connected to output")
+
+	o[name] = db
+	return db
+}
+
+func newInfluxDB(key, precision string) (database.Database, log.Fields) {
+	influxOptions := influxdb.InfluxOptions{
+		Server:    viper.GetString(key + ".server.url"),
+		Username:  viper.GetString(key + ".server.username"),
+		Password:  viper.GetString(key + ".server.password"),
+		Database:  viper.GetString(key + ".database"),
+		Precision: precision,
+	}
+	log.WithFields(log.Fields{
+		"Server":    influxOptions.Server,
+		"Username":  influxOptions.Username,
+		"Database":  influxOptions.Database,
+		"Precision": influxOptions.Precision,
+	}).Debug("InfluxDB Options")
+
+	return influxdb.New(influxOptions), log.Fields{
+		"server":   influxOptions.Server,
+		"database": influxOptions.Database,
+	}
+}
+
+func newInfluxDB2(key, precision string) (database.Database, log.Fields) {
+	influxOptions := influxdb2.Options{
+		Server:    viper.GetString(key + ".server.url"),
+		Token:     viper.GetString(key + ".token"),
+		Org:       viper.GetString(key + ".org"),
+		Bucket:    viper.GetString(key + ".bucket"),
+		Precision: precision,
+		Gzip:      viper.GetBool(key + ".gzip"),
+	}
+	log.WithFields(log.Fields{
+		"Server":    influxOptions.Server,
+		"Org":       influxOptions.Org,
+		"Bucket":    influxOptions.Bucket,
+		"Precision": influxOptions.Precision,
+		"Gzip":      influxOptions.Gzip,
+	}).Debug("InfluxDB2 Options")
+
+	return influxdb2.New(influxOptions), log.Fields{
+		"server": influxOptions.Server,
+		"org":    influxOptions.Org,
+		"bucket": influxOptions.Bucket,
+	}
+}
+
+// rejected stores the metrics that an output rejected after the write
+// succeeded as a dead letter.
+func rejected(name string) func([]model.Metric, error) {
+	if len(name) == 0 {
+		name = outputInfluxDB
+	}
+
+	return func(metrics []model.Metric, err error) {
+		storeDeadLetter(deadletter.NewRejected(name, metrics, err))
+	}
+}
+
+// invalid stores the lines that the wal of an output couldn't read back as a
+// dead letter.
+func invalid(name string) func([]byte, error) {
+	if len(name) == 0 {
+		name = outputInfluxDB
+	}
+
+	return func(lines []byte, err error) {
+		storeDeadLetter(deadletter.NewInvalid(name, lines, err))
+	}
+}
+
+// unwrapWAL returns the wal of an output, which can be batched.
+func unwrapWAL(db database.Database) (*wal.WAL, bool) {
+	if b, ok := db.(*batch.Batch); ok {
+		db = b.Database()
+	}
+
+	w, ok := db.(*wal.WAL)
+	return w, ok
+}
+
+// all returns the output that writes to every named output, empty names are
+// skipped unless there are no others.
+func (o outputs) all(names []string) database.Database {
+	dbs := make(map[string]database.Database)
+	for _, name := range names {
+		if len(name) > 0 {
+			dbs[name] = o.get(name)
+		}
+	}
+
+	switch len(dbs) {
+	case 0:
+		return o.get("")
+	case 1:
+		for _, db := range dbs {
+			return db
+		}
+	}
+
+	db, err := multi.New(dbs)
+	if err != nil {
+		log.WithError(err).Fatal("can't create outputs")
+	}
+
+	return db
+}
+
+func (o outputs) close() {
+	for name, db := range o {
+		db.Close()
+
+		// the stats are complete when the last batch is flushed
+		if b, ok := db.(*batch.Batch); ok {
+			stats := b.Stats()
+			log.WithFields(log.Fields{
+				"output":      name,
+				"flushes":     stats.Flushes,
+				"failures":    stats.Failures,
+				"metrics":     stats.Metrics,
+				"dropped":     stats.Dropped,
+				"max_batch":   stats.MaxBatchSize,
+				"max_latency": stats.MaxFlushLatency,
+			}).Info("batch stats")
+		}
+
+		if w, ok := unwrapWAL(db); ok {
+			log.WithFields(log.Fields{
+				"output":   name,
+				"buffered": w.Buffered(),
+				"dropped":  w.Dropped(),
+			}).Info("wal stats")
+		}
+	}
+}
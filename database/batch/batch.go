@@ -0,0 +1,296 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batch
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-mqtt/database"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultSize          = 1000
+	DefaultFlushInterval = time.Second
+	DefaultQueueSize     = 10000
+	DefaultCloseTimeout  = 5 * time.Second
+)
+
+var (
+	QueueFullError = errors.New("[Batch] queue is full, dropping metrics")
+	ClosedError    = errors.New("[Batch] trying to write after close")
+)
+
+// Batch collects the metrics of the writes and writes them to the database in
+// the background, when there are Size metrics or every FlushInterval.
+type Batch struct {
+	db      database.Database
+	options Options
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan []model.Metric
+	done   chan struct{}
+
+	statsMu sync.Mutex
+	stats   Stats
+}
+
+type Options struct {
+	// Size is the maximum number of metrics in a write to the database
+	Size int
+	// FlushInterval is the maximum time metrics wait before they're written
+	FlushInterval time.Duration
+	// Jitter is the maximum random time added to the flush interval, so
+	// several writers don't flush at the same time
+	Jitter time.Duration
+	// QueueSize is the number of writes that can wait to be batched, later
+	// writes fail with QueueFullError
+	QueueSize int
+	// Rejected is called with the metrics of a batch that could not be
+	// written, only the dropped ones when the other metrics of the batch
+	// were written
+	Rejected func(metrics []model.Metric, err error)
+	// CloseTimeout is the time Close waits for the last flush, after which
+	// the database is closed to stop the writes that are being retried
+	CloseTimeout time.Duration
+}
+
+// Stats of the flushes to the database
+type Stats struct {
+	Flushes          uint64
+	Failures         uint64
+	Metrics          uint64
+	Dropped          uint64
+	LastBatchSize    int
+	MaxBatchSize     int
+	LastFlushLatency time.Duration
+	MaxFlushLatency  time.Duration
+}
+
+func New(db database.Database, options Options) *Batch {
+	if options.Size <= 0 {
+		options.Size = DefaultSize
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = DefaultFlushInterval
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.CloseTimeout <= 0 {
+		options.CloseTimeout = DefaultCloseTimeout
+	}
+
+	return &Batch{
+		db:      db,
+		options: options,
+	}
+}
+
+// Connect connects to the database and starts writing in the background.
+func (b *Batch) Connect() error {
+	if err := b.db.Connect(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.queue != nil && !b.closed {
+		return nil
+	}
+
+	b.closed = false
+	b.queue = make(chan []model.Metric, b.options.QueueSize)
+	b.done = make(chan struct{})
+	go b.run(b.queue, b.done)
+
+	return nil
+}
+
+// Write queues the metrics without waiting for the database.
+func (b *Batch) Write(metrics []model.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed || b.queue == nil {
+		return ClosedError
+	}
+
+	select {
+	case b.queue <- metrics:
+		return nil
+	default:
+		b.statsMu.Lock()
+		b.stats.Dropped += uint64(len(metrics))
+		b.statsMu.Unlock()
+		return QueueFullError
+	}
+}
+
+// Close flushes the queued metrics and closes the database. The last flush
+// is stopped by closing the database when it takes longer than CloseTimeout.
+func (b *Batch) Close() error {
+	b.mu.Lock()
+	if b.closed || b.queue == nil {
+		b.mu.Unlock()
+		return b.db.Close()
+	}
+	b.closed = true
+	close(b.queue)
+	done := b.done
+	b.mu.Unlock()
+
+	timer := time.NewTimer(b.options.CloseTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return b.db.Close()
+	case <-timer.C:
+	}
+
+	log.WithField("timeout", b.options.CloseTimeout).Warn("[Batch] last flush takes too long, closing database")
+	err := b.db.Close()
+	<-done
+
+	return err
+}
+
+// Database returns the database the batches are written to.
+func (b *Batch) Database() database.Database {
+	return b.db
+}
+
+func (b *Batch) Stats() Stats {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	return b.stats
+}
+
+func (b *Batch) run(queue chan []model.Metric, done chan struct{}) {
+	defer close(done)
+
+	var pending []model.Metric
+	timer := time.NewTimer(b.interval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case metrics, ok := <-queue:
+			if !ok {
+				b.flush(pending)
+				return
+			}
+
+			pending = append(pending, metrics...)
+			if len(pending) < b.options.Size {
+				continue
+			}
+		case <-timer.C:
+		}
+
+		pending = b.flush(pending)
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(b.interval())
+	}
+}
+
+// flush writes the pending metrics in batches of at most Size metrics and
+// returns the slice to reuse for the next metrics.
+func (b *Batch) flush(pending []model.Metric) []model.Metric {
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > b.options.Size {
+			n = b.options.Size
+		}
+
+		start := time.Now()
+		err := b.db.Write(pending[:n])
+		latency := time.Since(start)
+
+		b.record(n, latency, err)
+
+		if err != nil {
+			log.WithError(err).WithField("metrics", n).Error("[Batch] error writing batch")
+			if b.options.Rejected != nil {
+				b.options.Rejected(database.Failed(pending[:n], err), err)
+			}
+		} else {
+			log.WithFields(log.Fields{
+				"metrics": n,
+				"latency": latency,
+			}).Debug("[Batch] flushed batch")
+		}
+
+		pending = pending[n:]
+	}
+
+	return make([]model.Metric, 0, b.options.Size)
+}
+
+func (b *Batch) record(size int, latency time.Duration, err error) {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	b.stats.Flushes++
+	if err != nil {
+		b.stats.Failures++
+	} else {
+		b.stats.Metrics += uint64(size)
+	}
+
+	b.stats.LastBatchSize = size
+	if size > b.stats.MaxBatchSize {
+		b.stats.MaxBatchSize = size
+	}
+
+	b.stats.LastFlushLatency = latency
+	if latency > b.stats.MaxFlushLatency {
+		b.stats.MaxFlushLatency = latency
+	}
+}
+
+func (b *Batch) interval() time.Duration {
+	if b.options.Jitter <= 0 {
+		return b.options.FlushInterval
+	}
+
+	return b.options.FlushInterval + time.Duration(rand.Int63n(int64(b.options.Jitter)))
+}
@@ -0,0 +1,278 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package batch
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/database/retry"
+	"github.com/bullettime/lora-mqtt/model"
+)
+
+type testDatabase struct {
+	mu      sync.Mutex
+	err     error
+	batches [][]model.Metric
+	block   chan struct{}
+	closed  bool
+}
+
+func (d *testDatabase) Connect() error {
+	return nil
+}
+
+func (d *testDatabase) Write(metrics []model.Metric) error {
+	if d.block != nil {
+		<-d.block
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+	d.batches = append(d.batches, append([]model.Metric(nil), metrics...))
+	return nil
+}
+
+func (d *testDatabase) Close() error {
+	d.closed = true
+	return nil
+}
+
+func (d *testDatabase) sizes() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sizes := make([]int, len(d.batches))
+	for i, batch := range d.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func newMetrics(t *testing.T, n int) []model.Metric {
+	metrics := make([]model.Metric, n)
+	for i := range metrics {
+		m, err := model.NewMetric("test", nil, map[string]interface{}{"value": i}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics[i] = m
+	}
+	return metrics
+}
+
+func TestBatch_Size(t *testing.T) {
+	db := &testDatabase{}
+	b := New(db, Options{Size: 3, FlushInterval: time.Hour})
+
+	if err := b.Write(newMetrics(t, 1)); err != ClosedError {
+		t.Error("writing before connect should give an error")
+	}
+
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := b.Write(newMetrics(t, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := db.sizes()
+	total := 0
+	for _, size := range sizes {
+		if size > 3 {
+			t.Errorf("batch of %d metrics is larger than the size", size)
+		}
+		total += size
+	}
+
+	if total != 8 {
+		t.Errorf("all metrics should be written on close: %v", sizes)
+	}
+
+	if !db.closed {
+		t.Error("database should be closed")
+	}
+
+	stats := b.Stats()
+	if stats.Metrics != 8 || stats.MaxBatchSize != 3 || stats.Flushes != uint64(len(sizes)) {
+		t.Errorf("wrong stats: %+v", stats)
+	}
+
+	if err := b.Write(newMetrics(t, 1)); err != ClosedError {
+		t.Error("writing after close should give an error")
+	}
+}
+
+func TestBatch_FlushInterval(t *testing.T) {
+	db := &testDatabase{}
+	b := New(db, Options{Size: 100, FlushInterval: 10 * time.Millisecond, Jitter: 5 * time.Millisecond})
+
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := b.Write(newMetrics(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(db.sizes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("metrics should be flushed after the flush interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if sizes := db.sizes(); sizes[0] != 2 {
+		t.Errorf("wrong batch: %v", sizes)
+	}
+}
+
+func TestBatch_QueueFull(t *testing.T) {
+	db := &testDatabase{block: make(chan struct{})}
+	b := New(db, Options{Size: 1, FlushInterval: time.Hour, QueueSize: 1})
+
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	for i := 0; i < 5 && err == nil; i++ {
+		err = b.Write(newMetrics(t, 1))
+	}
+
+	if err != QueueFullError {
+		t.Errorf("writes to a full queue should fail: %v", err)
+	}
+
+	if b.Stats().Dropped != 1 {
+		t.Errorf("wrong number of dropped metrics: %d", b.Stats().Dropped)
+	}
+
+	close(db.block)
+	b.Close()
+}
+
+func TestBatch_Failure(t *testing.T) {
+	db := &testDatabase{err: errors.New("down")}
+
+	rejected := 0
+	b := New(db, Options{Size: 1, Rejected: func(metrics []model.Metric, err error) {
+		rejected += len(metrics)
+	}})
+
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Write(newMetrics(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	if stats := b.Stats(); stats.Failures != 2 || stats.Metrics != 0 {
+		t.Errorf("wrong stats: %+v", stats)
+	}
+
+	if rejected != 2 {
+		t.Errorf("the metrics of the failed batches should be rejected: %d", rejected)
+	}
+}
+
+type partialError struct {
+	failed []model.Metric
+}
+
+func (e *partialError) Error() string {
+	return "dropped invalid points"
+}
+
+func (e *partialError) Failed() []model.Metric {
+	return e.failed
+}
+
+func TestBatch_PartialFailure(t *testing.T) {
+	metrics := newMetrics(t, 3)
+	db := &testDatabase{err: &partialError{failed: metrics[1:2]}}
+
+	var rejected []model.Metric
+	b := New(db, Options{Size: 3, FlushInterval: time.Hour, Rejected: func(metrics []model.Metric, err error) {
+		rejected = append(rejected, metrics...)
+	}})
+
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Write(metrics); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	if len(rejected) != 1 || rejected[0] != metrics[1] {
+		t.Errorf("only the failed metrics of the batch should be rejected: %v", rejected)
+	}
+}
+
+func TestBatch_CloseTimeout(t *testing.T) {
+	db := retry.New(&testDatabase{err: errors.New("connection refused")}, retry.Options{MaxRetries: -1})
+	b := New(db, Options{Size: 10, FlushInterval: time.Hour, CloseTimeout: 50 * time.Millisecond})
+
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Write(newMetrics(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		b.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("close should stop the retries of the last flush")
+	}
+
+	if stats := b.Stats(); stats.Failures != 1 {
+		t.Errorf("wrong stats: %+v", stats)
+	}
+}
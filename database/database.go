@@ -24,6 +24,7 @@ package database
 
 import (
 	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
 )
 
 type Database interface {
@@ -31,3 +32,13 @@ type Database interface {
 	Write([]model.Metric) error
 	Close() error
 }
+
+// Failed returns the metrics that weren't written by a write of metrics that
+// returned err. That's all of them, unless the error has a Failed method
+// with the metrics that were dropped while the others were written.
+func Failed(metrics []model.Metric, err error) []model.Metric {
+	if e, ok := errors.Cause(err).(interface{ Failed() []model.Metric }); ok {
+		return e.Failed()
+	}
+	return metrics
+}
@@ -23,6 +23,12 @@
 package influxdb
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/bullettime/lora-mqtt/database"
@@ -93,9 +99,45 @@ func (i *influxdb) Write(metrics []model.Metric) error {
 		return errors.Wrap(err, "[Influxdb] error creating new point(s)")
 	}
 
-	if err := i.client.Write(batchPoints); err != nil {
+	if i.client == nil {
+		return errors.New("[Influxdb] trying to write while not connected")
+	}
+
+	// the points are posted here instead of with the client, its errors
+	// don't have the status of the response
+	var lines bytes.Buffer
+	for _, point := range batchPoints.Points() {
+		lines.WriteString(point.PrecisionString(i.options.Precision))
+		lines.WriteByte('\n')
+	}
+
+	query := url.Values{"db": {i.options.Database}}
+	if len(i.options.Precision) > 0 {
+		query.Set("precision", i.options.Precision)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(i.options.Server, "/")+"/write?"+query.Encode(), &lines)
+	if err != nil {
+		return errors.Wrap(err, "[Influxdb] error creating write request")
+	}
+	if len(i.options.Username) > 0 {
+		req.SetBasicAuth(i.options.Username, i.options.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
 		return errors.Wrap(err, "[Influxdb] error writing batch points")
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Wrap(&StatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Message:    strings.TrimSpace(string(body)),
+		}, "[Influxdb] error writing batch points")
+	}
 
 	return nil
 }
@@ -107,3 +149,24 @@ func (i *influxdb) Close() error {
 
 	return nil
 }
+
+// StatusError is the response of the server to a write that failed
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Message) == 0 {
+		return e.Status
+	}
+	return e.Status + ": " + e.Message
+}
+
+// Temporary reports whether the write can succeed when it's retried, the
+// other writes are rejected because of the points or the credentials.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusRequestTimeout
+}
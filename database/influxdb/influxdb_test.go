@@ -23,10 +23,13 @@
 package influxdb
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
 )
 
 var server = "http://localhost:8086"
@@ -130,6 +133,43 @@ func TestInfluxdb_Write2(t *testing.T) {
 	influxdb.Close()
 }
 
+func TestInfluxdb_WriteStatus(t *testing.T) {
+	status := http.StatusUnauthorized
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":"authorization failed"}`))
+	}))
+	defer server.Close()
+
+	options2 := options
+	options2.Server = server.URL
+	influxdb := New(options2)
+	if err := influxdb.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer influxdb.Close()
+
+	metric, err := model.NewMetric("mysensor3", nil, map[string]interface{}{"value": 0.95}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = influxdb.Write([]model.Metric{metric})
+	if e, ok := errors.Cause(err).(*StatusError); !ok || e.StatusCode != status || e.Temporary() {
+		t.Errorf("rejected write should give a permanent status error: %v", err)
+	}
+
+	status = http.StatusServiceUnavailable
+	err = influxdb.Write([]model.Metric{metric})
+	if e, ok := errors.Cause(err).(*StatusError); !ok || !e.Temporary() {
+		t.Errorf("unavailable server should give a temporary status error: %v", err)
+	}
+}
+
 func TestInfluxdb_Close(t *testing.T) {
 	influxdb := New(options)
 
@@ -0,0 +1,289 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package influxdb2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-mqtt/database"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
+)
+
+const defaultTimeout = 10 * time.Second
+
+type influxdb2 struct {
+	mu      sync.RWMutex
+	client  *http.Client
+	options Options
+}
+
+// Options of an InfluxDB 2.x or 3.x server, which is written to with the v2
+// write api.
+type Options struct {
+	Server    string
+	Token     string
+	Org       string
+	Bucket    string
+	Precision string
+	Gzip      bool
+	Timeout   time.Duration
+}
+
+func New(options Options) database.Database {
+	if options.Timeout == 0 {
+		options.Timeout = defaultTimeout
+	}
+
+	return &influxdb2{
+		options: options,
+	}
+}
+
+// precision returns the precision of the write api, which only supports
+// nanoseconds up to seconds.
+func precision(p string) (string, error) {
+	switch p {
+	case "", "n", "ns":
+		return "ns", nil
+	case "u", "us", "µs":
+		return "us", nil
+	case "ms", "s":
+		return p, nil
+	}
+
+	return "", errors.Errorf("[Influxdb2] unsupported precision: %s", p)
+}
+
+func (i *influxdb2) Connect() error {
+	if len(i.options.Bucket) == 0 {
+		return errors.New("[Influxdb2] bucket cannot be empty")
+	}
+
+	if _, err := precision(i.options.Precision); err != nil {
+		return err
+	}
+
+	c := &http.Client{Timeout: i.options.Timeout}
+
+	i.mu.Lock()
+	i.client = c
+	i.mu.Unlock()
+
+	req, err := i.newRequest(http.MethodGet, "/health", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "[Influxdb2] error establishing connection")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Wrap(responseError(resp), "[Influxdb2] server is not healthy")
+	}
+
+	return nil
+}
+
+// Write writes the metrics as points. The metrics that aren't valid points
+// are dropped and give a PointError after the other points are written.
+func (i *influxdb2) Write(metrics []model.Metric) error {
+	i.mu.RLock()
+	c := i.client
+	i.mu.RUnlock()
+
+	if c == nil {
+		return errors.New("[Influxdb2] trying to write while not connected")
+	}
+
+	p, err := precision(i.options.Precision)
+	if err != nil {
+		return err
+	}
+
+	var invalid *PointError
+	var lines bytes.Buffer
+	for _, metric := range metrics {
+		line, err := model.MarshalLine(metric, p)
+		if err != nil {
+			log.WithError(err).WithField("metric", metric.Name()).Warn("[Influxdb2] dropping invalid point")
+			if invalid == nil {
+				invalid = &PointError{Err: err}
+			}
+			invalid.Dropped++
+			invalid.Metrics = append(invalid.Metrics, metric)
+			continue
+		}
+
+		lines.Write(line)
+		lines.WriteByte('\n')
+	}
+
+	if lines.Len() == 0 {
+		if invalid != nil {
+			return errors.Wrap(invalid, "[Influxdb2] error writing points")
+		}
+		return nil
+	}
+
+	body := &lines
+	headers := map[string]string{
+		"Content-Type": "text/plain; charset=utf-8",
+	}
+
+	if i.options.Gzip {
+		body = new(bytes.Buffer)
+		w := gzip.NewWriter(body)
+		if _, err := w.Write(lines.Bytes()); err != nil {
+			return errors.Wrap(err, "[Influxdb2] error compressing points")
+		}
+		if err := w.Close(); err != nil {
+			return errors.Wrap(err, "[Influxdb2] error compressing points")
+		}
+		headers["Content-Encoding"] = "gzip"
+	}
+
+	query := url.Values{
+		"org":       {i.options.Org},
+		"bucket":    {i.options.Bucket},
+		"precision": {p},
+	}
+
+	req, err := i.newRequest(http.MethodPost, "/api/v2/write", query, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "[Influxdb2] error writing points")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Wrap(responseError(resp), "[Influxdb2] error writing points")
+	}
+
+	if invalid != nil {
+		return errors.Wrap(invalid, "[Influxdb2] error writing points")
+	}
+
+	return nil
+}
+
+func (i *influxdb2) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.client = nil
+	return nil
+}
+
+func (i *influxdb2) newRequest(method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(strings.TrimRight(i.options.Server, "/") + path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[Influxdb2] invalid server: %s", i.options.Server)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "[Influxdb2] error creating request")
+	}
+
+	if len(i.options.Token) > 0 {
+		req.Header.Set("Authorization", "Token "+i.options.Token)
+	}
+
+	return req, nil
+}
+
+// PointError is the error of the metrics that could not be written because
+// they aren't valid points, like a metric without fields. Err is the error of
+// the first of them.
+type PointError struct {
+	Dropped int
+	Metrics []model.Metric
+	Err     error
+}
+
+func (e *PointError) Error() string {
+	return fmt.Sprintf("%d invalid point(s): %s", e.Dropped, e.Err)
+}
+
+// Temporary is false, the points are invalid every time they're written.
+func (e *PointError) Temporary() bool {
+	return false
+}
+
+// Failed returns the dropped metrics, the other metrics were written.
+func (e *PointError) Failed() []model.Metric {
+	return e.Metrics
+}
+
+// StatusError is the response of the server to a request that failed
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Message) == 0 {
+		return e.Status
+	}
+	return e.Status + ": " + e.Message
+}
+
+// Temporary reports whether the request can succeed when it's retried, the
+// other requests are rejected because of the request itself.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusRequestTimeout
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Message:    strings.TrimSpace(string(body)),
+	}
+}
@@ -0,0 +1,212 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package influxdb2
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/database"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
+)
+
+type request struct {
+	path          string
+	query         string
+	authorization string
+	body          string
+}
+
+func newServer(t *testing.T, status int) (*httptest.Server, chan request) {
+	requests := make(chan request, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body = gz
+		}
+
+		buf, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		requests <- request{
+			path:          r.URL.Path,
+			query:         r.URL.RawQuery,
+			authorization: r.Header.Get("Authorization"),
+			body:          string(buf),
+		}
+
+		if r.URL.Path == "/health" {
+			w.Write([]byte(`{"status":"pass"}`))
+			return
+		}
+
+		w.WriteHeader(status)
+		if status != http.StatusNoContent {
+			w.Write([]byte(`{"code":"invalid","message":"partial write"}`))
+		}
+	}))
+
+	return server, requests
+}
+
+func TestInfluxdb2_Write(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		server, requests := newServer(t, http.StatusNoContent)
+
+		db := New(Options{
+			Server:    server.URL,
+			Token:     "secret",
+			Org:       "bullettime",
+			Bucket:    "lora",
+			Precision: "s",
+			Gzip:      compress,
+		})
+
+		if err := db.Connect(); err != nil {
+			t.Fatal(err)
+		}
+
+		health := <-requests
+		if health.path != "/health" || health.authorization != "Token secret" {
+			t.Errorf("wrong health check: %+v", health)
+		}
+
+		metric, err := model.NewMetric("coverage", map[string]string{"gateway_id": "gw-1"},
+			map[string]interface{}{"rssi": -84}, time.Unix(1520968882, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Write([]model.Metric{metric}); err != nil {
+			t.Fatal(err)
+		}
+
+		write := <-requests
+		if write.path != "/api/v2/write" || write.query != "bucket=lora&org=bullettime&precision=s" {
+			t.Errorf("wrong write request: %+v", write)
+		}
+		if write.body != "coverage,gateway_id=gw-1 rssi=-84i 1520968882\n" {
+			t.Errorf("wrong body (gzip %t): %q", compress, write.body)
+		}
+
+		db.Close()
+		server.Close()
+	}
+}
+
+func TestInfluxdb2_Write2(t *testing.T) {
+	server, requests := newServer(t, http.StatusBadRequest)
+	defer server.Close()
+
+	db := New(Options{Server: server.URL, Bucket: "lora"})
+	if err := db.Write(nil); err == nil {
+		t.Error("writing while not connected should give an error")
+	}
+
+	if err := db.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	<-requests
+
+	metric, err := model.NewMetric("coverage", nil, map[string]interface{}{"rssi": -84}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Write([]model.Metric{metric})
+	if err == nil {
+		t.Fatal("rejected write should give an error")
+	}
+
+	if e, ok := errors.Cause(err).(*StatusError); !ok || e.StatusCode != http.StatusBadRequest || e.Temporary() {
+		t.Errorf("rejected write should give a permanent status error: %v", err)
+	}
+}
+
+func TestInfluxdb2_WriteInvalid(t *testing.T) {
+	server, requests := newServer(t, http.StatusNoContent)
+	defer server.Close()
+
+	db := New(Options{Server: server.URL, Bucket: "lora"})
+	if err := db.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	<-requests
+
+	valid, err := model.NewMetric("coverage", nil, map[string]interface{}{"rssi": -84}, time.Unix(1520968882, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid, err := model.NewMetric("coverage", nil, map[string]interface{}{"snr": math.NaN()}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Write([]model.Metric{valid, invalid})
+	if e, ok := errors.Cause(err).(*PointError); !ok || e.Dropped != 1 || e.Temporary() {
+		t.Errorf("invalid points should give a permanent point error: %v", err)
+	}
+
+	if failed := database.Failed([]model.Metric{valid, invalid}, err); len(failed) != 1 || failed[0] != invalid {
+		t.Errorf("only the invalid points should have failed: %v", failed)
+	}
+
+	if write := <-requests; write.body != "coverage rssi=-84i 1520968882000000000\n" {
+		t.Errorf("the valid points should be written: %q", write.body)
+	}
+
+	if err := db.Write([]model.Metric{invalid}); err == nil {
+		t.Error("a write without valid points should give an error")
+	}
+}
+
+func TestInfluxdb2_Connect(t *testing.T) {
+	server, _ := newServer(t, http.StatusNoContent)
+	defer server.Close()
+
+	if err := New(Options{Server: server.URL}).Connect(); err == nil {
+		t.Error("missing bucket should give an error")
+	}
+
+	if err := New(Options{Server: server.URL, Bucket: "lora", Precision: "h"}).Connect(); err == nil {
+		t.Error("unsupported precision should give an error")
+	}
+
+	if err := New(Options{Server: "http://localhost:1", Bucket: "lora"}).Connect(); err == nil {
+		t.Error("wrong server should give an error")
+	}
+}
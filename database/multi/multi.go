@@ -0,0 +1,175 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package multi
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/database"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
+)
+
+// Multi writes the metrics to several outputs at the same time, an output
+// that fails doesn't stop the writes to the others.
+type Multi struct {
+	outputs []*output
+}
+
+type output struct {
+	name string
+	db   database.Database
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Status of the writes to an output
+type Status struct {
+	Name      string
+	Writes    uint64
+	Failures  uint64
+	LastWrite time.Time
+	LastError error
+}
+
+// Error has the errors of the outputs that failed by name
+type Error map[string]error
+
+func (e Error) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, len(names))
+	for i, name := range names {
+		messages[i] = name + ": " + e[name].Error()
+	}
+
+	return "[Multi] " + strings.Join(messages, "; ")
+}
+
+func New(outputs map[string]database.Database) (*Multi, error) {
+	if len(outputs) == 0 {
+		return nil, errors.New("[Multi] outputs cannot be empty")
+	}
+
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m := &Multi{}
+	for _, name := range names {
+		if outputs[name] == nil {
+			return nil, errors.Errorf("[Multi] output %s cannot be nil", name)
+		}
+
+		m.outputs = append(m.outputs, &output{
+			name:   name,
+			db:     outputs[name],
+			status: Status{Name: name},
+		})
+	}
+
+	return m, nil
+}
+
+func (m *Multi) Connect() error {
+	return m.each(func(o *output) error {
+		return o.db.Connect()
+	})
+}
+
+func (m *Multi) Write(metrics []model.Metric) error {
+	return m.each(func(o *output) error {
+		err := o.db.Write(metrics)
+
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		if err != nil {
+			o.status.Failures++
+			o.status.LastError = err
+		} else {
+			o.status.Writes++
+			o.status.LastWrite = time.Now()
+			o.status.LastError = nil
+		}
+
+		return err
+	})
+}
+
+func (m *Multi) Close() error {
+	return m.each(func(o *output) error {
+		return o.db.Close()
+	})
+}
+
+// Status returns the status of every output, sorted by name.
+func (m *Multi) Status() []Status {
+	status := make([]Status, len(m.outputs))
+	for i, o := range m.outputs {
+		o.mu.Lock()
+		status[i] = o.status
+		o.mu.Unlock()
+	}
+
+	return status
+}
+
+// each calls f for every output at the same time and returns an Error with
+// the outputs that failed.
+func (m *Multi) each(f func(o *output) error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(m.outputs))
+
+	for i, o := range m.outputs {
+		wg.Add(1)
+		go func(i int, o *output) {
+			defer wg.Done()
+			errs[i] = f(o)
+		}(i, o)
+	}
+	wg.Wait()
+
+	failed := make(Error)
+	for i, err := range errs {
+		if err != nil {
+			failed[m.outputs[i].name] = err
+		}
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+
+	return nil
+}
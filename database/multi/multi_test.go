@@ -0,0 +1,126 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package multi
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/database"
+	"github.com/bullettime/lora-mqtt/model"
+)
+
+type testDatabase struct {
+	mu      sync.Mutex
+	err     error
+	written []model.Metric
+	closed  bool
+}
+
+func (d *testDatabase) Connect() error {
+	return d.err
+}
+
+func (d *testDatabase) Write(metrics []model.Metric) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+	d.written = append(d.written, metrics...)
+	return nil
+}
+
+func (d *testDatabase) Close() error {
+	d.closed = true
+	return nil
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil); err == nil {
+		t.Error("no outputs should give an error")
+	}
+
+	if _, err := New(map[string]database.Database{"a": nil}); err == nil {
+		t.Error("nil output should give an error")
+	}
+}
+
+func TestMulti_Write(t *testing.T) {
+	a := &testDatabase{}
+	b := &testDatabase{err: errors.New("down")}
+	c := &testDatabase{}
+
+	m, err := New(map[string]database.Database{"a": a, "b": b, "c": c})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Connect()
+	if e, ok := err.(Error); !ok || len(e) != 1 || e["b"] == nil {
+		t.Errorf("only output b should fail to connect: %v", err)
+	}
+
+	metric, err := model.NewMetric("test", nil, map[string]interface{}{"value": 1}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Write([]model.Metric{metric})
+	if err == nil || err.Error() != "[Multi] b: down" {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	if len(a.written) != 1 || len(c.written) != 1 {
+		t.Error("a failing output should not stop the writes to the others")
+	}
+
+	status := m.Status()
+	if len(status) != 3 || status[0].Name != "a" || status[1].Name != "b" {
+		t.Fatalf("status should be sorted by name: %v", status)
+	}
+
+	if status[0].Writes != 1 || status[0].LastError != nil || status[0].LastWrite.IsZero() {
+		t.Errorf("wrong status of a: %+v", status[0])
+	}
+
+	if status[1].Failures != 1 || status[1].LastError == nil || !status[1].LastWrite.IsZero() {
+		t.Errorf("wrong status of b: %+v", status[1])
+	}
+
+	b.err = nil
+	if err := m.Write([]model.Metric{metric}); err != nil {
+		t.Error(err)
+	}
+
+	if status := m.Status(); status[1].Writes != 1 || status[1].LastError != nil {
+		t.Errorf("b should have recovered: %+v", status[1])
+	}
+
+	if err := m.Close(); err != nil || !a.closed || !b.closed || !c.closed {
+		t.Error("every output should be closed")
+	}
+}
@@ -0,0 +1,184 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package retry
+
+import (
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-mqtt/database"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultMaxRetries     = 5
+	DefaultInitialBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff     = 30 * time.Second
+)
+
+// Retry retries the writes to the database that fail because of the network
+// or the server, and reconnects before every retry.
+type Retry struct {
+	db      database.Database
+	options Options
+
+	mu      sync.Mutex
+	closing chan struct{}
+}
+
+type Options struct {
+	// MaxRetries is the number of retries after the first write, negative
+	// retries forever and 0 is DefaultMaxRetries
+	MaxRetries int
+	// InitialBackoff is the time before the first retry, it's doubled for
+	// every next retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxElapsed is the maximum time a write is retried, also when it's
+	// retried forever. Writes that aren't batched block the messages that
+	// are received while they're retried. Zero doesn't limit the time.
+	MaxElapsed time.Duration
+}
+
+func New(db database.Database, options Options) *Retry {
+	if options.MaxRetries == 0 {
+		options.MaxRetries = DefaultMaxRetries
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = DefaultInitialBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultMaxBackoff
+	}
+	if options.MaxBackoff < options.InitialBackoff {
+		options.MaxBackoff = options.InitialBackoff
+	}
+
+	return &Retry{
+		db:      db,
+		options: options,
+		closing: make(chan struct{}),
+	}
+}
+
+// Retryable reports whether a write that failed with err can succeed when
+// it's retried. Errors with the status of the server, like StatusError, are
+// permanent for 4xx other than 408 and 429, just like invalid points. Every
+// other error (network, timeouts, a lost connection) is retryable.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// network errors have a Temporary method too, but a refused connection
+	// isn't temporary while the server restarts
+	cause := errors.Cause(err)
+	if e, ok := cause.(interface{ Temporary() bool }); ok {
+		if _, network := cause.(interface{ Timeout() bool }); !network {
+			return e.Temporary()
+		}
+	}
+
+	return true
+}
+
+// Connect connects to the database, also after Close, which only stopped
+// the writes that were retried then.
+func (r *Retry) Connect() error {
+	r.mu.Lock()
+	select {
+	case <-r.closing:
+		r.closing = make(chan struct{})
+	default:
+	}
+	r.mu.Unlock()
+
+	return r.db.Connect()
+}
+
+func (r *Retry) Write(metrics []model.Metric) error {
+	r.mu.Lock()
+	closing := r.closing
+	r.mu.Unlock()
+
+	backoff := r.options.InitialBackoff
+	start := time.Now()
+
+	for retry := 0; ; retry++ {
+		err := r.db.Write(metrics)
+		if err == nil {
+			return nil
+		}
+
+		if !Retryable(err) {
+			return errors.Wrap(err, "[Retry] permanent error")
+		}
+
+		if r.options.MaxRetries >= 0 && retry >= r.options.MaxRetries {
+			return errors.Wrapf(err, "[Retry] giving up after %d retries", retry)
+		}
+
+		if r.options.MaxElapsed > 0 && time.Since(start)+backoff > r.options.MaxElapsed {
+			return errors.Wrapf(err, "[Retry] giving up after %d retries in %s", retry, time.Since(start))
+		}
+
+		log.WithError(err).WithFields(log.Fields{
+			"retry":   retry + 1,
+			"backoff": backoff,
+		}).Warn("[Retry] write failed, retrying")
+
+		select {
+		case <-time.After(backoff):
+		case <-closing:
+			return errors.Wrap(err, "[Retry] closed while retrying")
+		}
+
+		backoff *= 2
+		if backoff > r.options.MaxBackoff {
+			backoff = r.options.MaxBackoff
+		}
+
+		// the connection to the server could be lost, the next write is
+		// retried when connecting fails too
+		r.db.Close()
+		if err := r.db.Connect(); err != nil {
+			log.WithError(err).Warn("[Retry] error reconnecting")
+		}
+	}
+}
+
+// Close stops the writes that are waiting to be retried and closes the
+// database.
+func (r *Retry) Close() error {
+	r.mu.Lock()
+	select {
+	case <-r.closing:
+	default:
+		close(r.closing)
+	}
+	r.mu.Unlock()
+
+	return r.db.Close()
+}
@@ -0,0 +1,193 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package retry
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/database/influxdb"
+	"github.com/bullettime/lora-mqtt/database/influxdb2"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
+)
+
+type testDatabase struct {
+	mu       sync.Mutex
+	errs     []error
+	writes   int
+	connects int
+}
+
+func (d *testDatabase) Connect() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.connects++
+	return nil
+}
+
+func (d *testDatabase) Write(metrics []model.Metric) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.writes++
+	if len(d.errs) == 0 {
+		return nil
+	}
+
+	err := d.errs[0]
+	d.errs = d.errs[1:]
+	return err
+}
+
+func (d *testDatabase) Close() error {
+	return nil
+}
+
+var (
+	refused = errors.Wrap(&url.Error{
+		Op:  "Post",
+		URL: "http://localhost:8086/write",
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+	}, "[Influxdb] error writing batch points")
+	unavailable  = errors.Wrap(&influxdb2.StatusError{StatusCode: 503, Status: "503 Service Unavailable"}, "[Influxdb2] error writing points")
+	badRequest   = errors.Wrap(&influxdb2.StatusError{StatusCode: 400, Status: "400 Bad Request"}, "[Influxdb2] error writing points")
+	conflict     = errors.Wrap(&influxdb.StatusError{StatusCode: 400, Status: "400 Bad Request", Message: `{"error":"field type conflict"}`}, "[Influxdb] error writing batch points")
+	unauthorized = errors.Wrap(&influxdb.StatusError{StatusCode: 401, Status: "401 Unauthorized", Message: `{"error":"authorization failed"}`}, "[Influxdb] error writing batch points")
+	notFound     = errors.Wrap(&influxdb2.StatusError{StatusCode: 404, Status: "404 Not Found", Message: `{"code":"not found","message":"bucket \"lora\" not found"}`}, "[Influxdb2] error writing points")
+	tooMany      = errors.Wrap(&influxdb2.StatusError{StatusCode: 429, Status: "429 Too Many Requests"}, "[Influxdb2] error writing points")
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{refused, true},
+		{unavailable, true},
+		{tooMany, true},
+		{errors.New("[Influxdb2] trying to write while not connected"), true},
+		{badRequest, false},
+		{conflict, false},
+		{unauthorized, false},
+		{notFound, false},
+		{&influxdb2.PointError{Dropped: 1}, false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if Retryable(test.err) != test.retryable {
+			t.Errorf("%v should be retryable: %t", test.err, test.retryable)
+		}
+	}
+}
+
+func TestRetry_Write(t *testing.T) {
+	db := &testDatabase{errs: []error{refused, unavailable}}
+	r := New(db, Options{InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond})
+
+	if err := r.Write(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.writes != 3 || db.connects != 2 {
+		t.Errorf("should write 3 times and reconnect twice: %d writes, %d connects", db.writes, db.connects)
+	}
+
+	db = &testDatabase{errs: []error{unavailable, conflict}}
+	r = New(db, Options{InitialBackoff: time.Millisecond})
+
+	if err := r.Write(nil); err == nil || errors.Cause(err) != errors.Cause(conflict) {
+		t.Errorf("permanent error should not be retried: %v", err)
+	}
+
+	if db.writes != 2 {
+		t.Errorf("wrong number of writes: %d", db.writes)
+	}
+
+	db = &testDatabase{errs: []error{refused, refused, refused, refused}}
+	r = New(db, Options{MaxRetries: 2, InitialBackoff: time.Millisecond})
+
+	if err := r.Write(nil); err == nil {
+		t.Error("write should fail after the last retry")
+	}
+
+	if db.writes != 3 {
+		t.Errorf("wrong number of writes: %d", db.writes)
+	}
+}
+
+func TestRetry_MaxElapsed(t *testing.T) {
+	db := &testDatabase{errs: make([]error, 1000)}
+	for i := range db.errs {
+		db.errs[i] = refused
+	}
+	r := New(db, Options{MaxRetries: -1, InitialBackoff: 10 * time.Millisecond, MaxElapsed: 50 * time.Millisecond})
+
+	start := time.Now()
+	if err := r.Write(nil); err == nil {
+		t.Error("write should fail after the maximum time")
+	}
+
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("write should not be retried longer than the maximum time: %s", elapsed)
+	}
+}
+
+func TestRetry_Close(t *testing.T) {
+	db := &testDatabase{errs: []error{refused, refused}}
+	r := New(db, Options{InitialBackoff: time.Hour})
+
+	done := make(chan error)
+	go func() {
+		done <- r.Write(nil)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	r.Close()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("closing while retrying should give an error")
+		}
+	case <-time.After(time.Second):
+		t.Error("closing should stop the retries")
+	}
+
+	r.Close()
+
+	// connecting again retries the next writes
+	db.errs = []error{refused}
+	r.options.InitialBackoff = time.Millisecond
+	if err := r.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Write(nil); err != nil {
+		t.Errorf("write after connecting again should be retried: %v", err)
+	}
+}
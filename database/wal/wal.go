@@ -0,0 +1,633 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-mqtt/database"
+	"github.com/bullettime/lora-mqtt/database/retry"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultMaxSize       = 1 << 30
+	DefaultSegmentSize   = 16 << 20
+	DefaultRetryInterval = 5 * time.Second
+
+	segmentExt   = ".seg"
+	positionFile = "position"
+	headerSize   = 8
+)
+
+// WAL writes the metrics to the database, or appends them to segment files
+// on disk while the database is unavailable. The metrics on disk are written
+// to the database in order when it's available again, also after a restart.
+//
+// A segment is a file with records of a length, a crc32 checksum and the
+// metrics of a write in the line protocol. The oldest segments are deleted
+// when the segments are larger than MaxSize.
+type WAL struct {
+	db      database.Database
+	options Options
+
+	// appendMu keeps the records in order while they're written to disk, mu
+	// only guards the segments
+	appendMu sync.Mutex
+	mu       sync.Mutex
+	segments []segment
+	file     *os.File
+	offset   int64
+	dropped  uint64
+
+	wake    chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+type Options struct {
+	// Dir is the directory of the segment files
+	Dir string
+	// MaxSize is the maximum size of the segments in bytes
+	MaxSize int64
+	// SegmentSize is the size in bytes after which a new segment is started
+	SegmentSize int64
+	// RetryInterval is the time between the writes of the buffered metrics
+	// while the database is unavailable
+	RetryInterval time.Duration
+	// Rejected is called with the buffered metrics that the database
+	// rejected, they're dropped from the segments
+	Rejected func(metrics []model.Metric, err error)
+	// Invalid is called with the lines of a record that can't be read back
+	// as metrics, they're dropped from the segments
+	Invalid func(lines []byte, err error)
+}
+
+type segment struct {
+	seq  uint64
+	size int64
+}
+
+func New(db database.Database, options Options) (*WAL, error) {
+	if len(options.Dir) == 0 {
+		return nil, errors.New("[WAL] directory cannot be empty")
+	}
+	if options.MaxSize <= 0 {
+		options.MaxSize = DefaultMaxSize
+	}
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSegmentSize
+	}
+	if options.SegmentSize > options.MaxSize/2 {
+		options.SegmentSize = options.MaxSize / 2
+	}
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = DefaultRetryInterval
+	}
+
+	w := &WAL{
+		db:      db,
+		options: options,
+	}
+
+	return w, nil
+}
+
+// Connect opens the segments on disk and starts writing the buffered
+// metrics. A database that is unavailable isn't an error, the metrics are
+// buffered until it's available.
+func (w *WAL) Connect() error {
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	if err := w.db.Connect(); err != nil {
+		if !retry.Retryable(err) {
+			return err
+		}
+		log.WithError(err).Warn("[WAL] database unavailable, buffering metrics on disk")
+	}
+
+	w.wake = make(chan struct{}, 1)
+	w.closing = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.replay()
+
+	w.notify()
+
+	return nil
+}
+
+// Write writes the metrics to the database when nothing is buffered, and
+// buffers them on disk when the database is unavailable or when there are
+// older metrics that have to be written first.
+func (w *WAL) Write(metrics []model.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	connected, pending := w.file != nil, w.pending()
+	w.mu.Unlock()
+
+	if !connected {
+		return errors.New("[WAL] trying to write while not connected")
+	}
+
+	if !pending {
+		err := w.db.Write(metrics)
+		if err == nil || !retry.Retryable(err) {
+			return err
+		}
+		log.WithError(err).Warn("[WAL] database unavailable, buffering metrics on disk")
+	}
+
+	data := marshal(metrics)
+	if len(data) == 0 {
+		return errors.New("[WAL] no valid metrics to buffer")
+	}
+
+	if err := w.append(data); err != nil {
+		return err
+	}
+
+	w.notify()
+	return nil
+}
+
+// Close stops writing the buffered metrics, they're written after the next
+// Connect.
+func (w *WAL) Close() error {
+	if w.closing != nil {
+		close(w.closing)
+		<-w.done
+		w.closing = nil
+	}
+
+	w.appendMu.Lock()
+	w.mu.Lock()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+	w.appendMu.Unlock()
+
+	return w.db.Close()
+}
+
+// Buffered returns the size in bytes of the metrics on disk that still have
+// to be written.
+func (w *WAL) Buffered() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var size int64
+	for _, s := range w.segments {
+		size += s.size
+	}
+	if len(w.segments) > 0 {
+		size -= w.offset
+	}
+
+	return size
+}
+
+// Dropped returns the number of bytes of metrics that were deleted because
+// the segments were too large.
+func (w *WAL) Dropped() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.dropped
+}
+
+func (w *WAL) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *WAL) replay() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.options.RetryInterval)
+	defer ticker.Stop()
+
+	reconnect := false
+	for {
+		select {
+		case <-w.closing:
+			return
+		case <-w.wake:
+		case <-ticker.C:
+		}
+
+		for {
+			select {
+			case <-w.closing:
+				return
+			default:
+			}
+
+			w.mu.Lock()
+			seq, offset, ok := w.head()
+			w.mu.Unlock()
+
+			if !ok {
+				break
+			}
+
+			// the segment is read without holding mu, so writes can append
+			// to the segments in the meantime
+			data, err := w.read(seq, offset)
+			if err != nil {
+				w.mu.Lock()
+				w.skip(seq, offset, err)
+				w.mu.Unlock()
+				continue
+			}
+
+			metrics, invalid, err := unmarshal(data)
+			if len(metrics) > 0 {
+				if reconnect {
+					w.db.Close()
+					if err := w.db.Connect(); err != nil {
+						log.WithError(err).Debug("[WAL] error reconnecting")
+						break
+					}
+					reconnect = false
+				}
+
+				// the records are written in order, so the replay waits
+				// until the database is available again
+				err := w.db.Write(metrics)
+				if err != nil && retry.Retryable(err) {
+					log.WithError(err).Debug("[WAL] database still unavailable")
+					reconnect = true
+					break
+				}
+				if err != nil {
+					log.WithError(err).Error("[WAL] dropping rejected metrics")
+					if w.options.Rejected != nil {
+						w.options.Rejected(database.Failed(metrics, err), err)
+					}
+				}
+			}
+
+			if len(invalid) > 0 {
+				log.WithError(err).Error("[WAL] dropping invalid lines of a record")
+				if w.options.Invalid != nil {
+					w.options.Invalid(invalid, err)
+				}
+			}
+
+			w.mu.Lock()
+			w.ack(seq, offset+headerSize+int64(len(data)))
+			w.mu.Unlock()
+		}
+	}
+}
+
+// unmarshal reads the metrics of a record. The lines that can't be read are
+// returned with the first error, so they aren't lost with the record.
+func unmarshal(data []byte) ([]model.Metric, []byte, error) {
+	var metrics []model.Metric
+	var invalid bytes.Buffer
+	var first error
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		m, err := model.UnmarshalLine(line, "ns")
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			invalid.Write(line)
+			invalid.WriteByte('\n')
+			continue
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return metrics, invalid.Bytes(), first
+}
+
+// marshal returns the metrics in the line protocol, metrics without a time
+// get the current time so they aren't written with the time of the replay.
+// Metrics that can't be marshalled are dropped.
+func marshal(metrics []model.Metric) []byte {
+	var buf bytes.Buffer
+	now := time.Now()
+
+	for _, m := range metrics {
+		if m.Time().IsZero() {
+			stamped, err := model.NewMetric(m.Name(), m.Tags(), m.Fields(), now)
+			if err == nil {
+				m = stamped
+			}
+		}
+
+		line, err := model.MarshalLine(m, "ns")
+		if err != nil {
+			log.WithError(err).WithField("metric", m.Name()).Warn("[WAL] dropping invalid metric")
+			continue
+		}
+
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+func (w *WAL) pending() bool {
+	return len(w.segments) > 1 || (len(w.segments) == 1 && w.offset < w.segments[0].size)
+}
+
+func (w *WAL) path(seq uint64) string {
+	return filepath.Join(w.options.Dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// open reads the segments in the directory and starts a new segment to
+// append to.
+func (w *WAL) open() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(w.options.Dir, 0755); err != nil {
+		return errors.Wrap(err, "[WAL] error creating directory")
+	}
+
+	files, err := ioutil.ReadDir(w.options.Dir)
+	if err != nil {
+		return errors.Wrap(err, "[WAL] error reading directory")
+	}
+
+	w.segments = w.segments[:0]
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != segmentExt {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		w.segments = append(w.segments, segment{seq: seq, size: f.Size()})
+	}
+	sort.Slice(w.segments, func(i, j int) bool {
+		return w.segments[i].seq < w.segments[j].seq
+	})
+
+	w.offset = 0
+	if seq, offset, ok := w.readPosition(); ok && len(w.segments) > 0 && w.segments[0].seq == seq {
+		w.offset = offset
+	}
+
+	var seq uint64 = 1
+	if len(w.segments) > 0 {
+		seq = w.segments[len(w.segments)-1].seq + 1
+	}
+
+	return w.create(seq)
+}
+
+func (w *WAL) create(seq uint64) error {
+	f, err := os.OpenFile(w.path(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "[WAL] error creating segment")
+	}
+
+	if w.file != nil {
+		w.file.Close()
+	}
+
+	w.file = f
+	w.segments = append(w.segments, segment{seq: seq})
+
+	return nil
+}
+
+// append writes a record to the last segment, and deletes the oldest
+// segments when the segments are too large. The record is written to disk
+// without holding mu, the replay only waits for the segment bookkeeping.
+func (w *WAL) append(data []byte) error {
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[headerSize:], data)
+
+	w.appendMu.Lock()
+	defer w.appendMu.Unlock()
+
+	w.mu.Lock()
+	if w.file == nil {
+		w.mu.Unlock()
+		return errors.New("[WAL] trying to write while not connected")
+	}
+	if last := w.segments[len(w.segments)-1]; last.size >= w.options.SegmentSize {
+		if err := w.create(last.seq + 1); err != nil {
+			w.mu.Unlock()
+			return err
+		}
+	}
+	f := w.file
+	w.mu.Unlock()
+
+	n, err := f.Write(record)
+	if err == nil {
+		err = errors.Wrap(f.Sync(), "[WAL] error syncing segment")
+	} else {
+		err = errors.Wrap(err, "[WAL] error appending record")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// only appends add or drop segments other than the oldest one, so the
+	// last segment is still the one that was written to
+	w.segments[len(w.segments)-1].size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, s := range w.segments {
+		size += s.size
+	}
+
+	for size > w.options.MaxSize && len(w.segments) > 1 {
+		oldest := w.segments[0]
+		log.WithFields(log.Fields{
+			"segment": oldest.seq,
+			"bytes":   oldest.size - w.offset,
+		}).Warn("[WAL] buffer is full, dropping oldest metrics")
+
+		w.dropped += uint64(oldest.size - w.offset)
+		size -= oldest.size
+		w.remove()
+	}
+
+	return nil
+}
+
+// head returns the segment and the offset of the next record that has to be
+// written. Segments that are written are deleted.
+func (w *WAL) head() (uint64, int64, bool) {
+	for len(w.segments) > 0 {
+		s := w.segments[0]
+
+		if w.offset < s.size {
+			return s.seq, w.offset, true
+		}
+
+		if len(w.segments) == 1 {
+			break
+		}
+		w.remove()
+	}
+
+	return 0, 0, false
+}
+
+// skip drops the rest of a segment with a record at offset that can't be
+// read, unless the segment was dropped while it was read.
+func (w *WAL) skip(seq uint64, offset int64, err error) {
+	if len(w.segments) == 0 || w.segments[0].seq != seq || w.offset != offset {
+		return
+	}
+
+	s := w.segments[0]
+	log.WithError(err).WithField("segment", s.seq).Error("[WAL] skipping the rest of a corrupt segment")
+	w.dropped += uint64(s.size - w.offset)
+	w.offset = s.size
+}
+
+func (w *WAL) read(seq uint64, offset int64) ([]byte, error) {
+	f, err := os.Open(w.path(seq))
+	if err != nil {
+		return nil, errors.Wrap(err, "[WAL] error opening segment")
+	}
+	defer f.Close()
+
+	header := make([]byte, headerSize)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return nil, errors.Wrap(err, "[WAL] error reading record header")
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if int64(length) > w.options.MaxSize {
+		return nil, errors.Errorf("[WAL] invalid record length %d", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, offset+headerSize); err != nil {
+		return nil, errors.Wrap(err, "[WAL] error reading record")
+	}
+
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errors.New("[WAL] invalid record checksum")
+	}
+
+	return data, nil
+}
+
+// ack marks the records before offset in the segment as written.
+func (w *WAL) ack(seq uint64, offset int64) {
+	if len(w.segments) == 0 || w.segments[0].seq != seq {
+		// the segment was dropped while its record was written
+		return
+	}
+
+	w.offset = offset
+	w.writePosition()
+}
+
+// remove deletes the oldest segment.
+func (w *WAL) remove() {
+	if err := os.Remove(w.path(w.segments[0].seq)); err != nil {
+		log.WithError(err).Warn("[WAL] error deleting segment")
+	}
+
+	w.segments = w.segments[1:]
+	w.offset = 0
+	w.writePosition()
+}
+
+func (w *WAL) readPosition() (uint64, int64, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(w.options.Dir, positionFile))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	var seq uint64
+	var offset int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seq, &offset); err != nil {
+		return 0, 0, false
+	}
+
+	return seq, offset, true
+}
+
+// writePosition saves the position of the next record, so the records that
+// are written aren't written again after a restart.
+func (w *WAL) writePosition() {
+	if len(w.segments) == 0 {
+		return
+	}
+
+	path := filepath.Join(w.options.Dir, positionFile)
+	data := fmt.Sprintf("%d %d\n", w.segments[0].seq, w.offset)
+
+	if err := ioutil.WriteFile(path+".tmp", []byte(data), 0644); err != nil {
+		log.WithError(err).Warn("[WAL] error saving position")
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.WithError(err).Warn("[WAL] error saving position")
+	}
+}
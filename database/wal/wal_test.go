@@ -0,0 +1,438 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package wal
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/database/influxdb2"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
+)
+
+var unavailable = errors.New("dial tcp 127.0.0.1:8086: connect: connection refused")
+
+type testDatabase struct {
+	mu      sync.Mutex
+	err     error
+	written []int64
+}
+
+func (d *testDatabase) Connect() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.err
+}
+
+func (d *testDatabase) Write(metrics []model.Metric) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+	for _, m := range metrics {
+		d.written = append(d.written, m.Fields()["value"].(int64))
+	}
+	return nil
+}
+
+func (d *testDatabase) Close() error {
+	return nil
+}
+
+func (d *testDatabase) setError(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.err = err
+}
+
+func (d *testDatabase) values() []int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]int64(nil), d.written...)
+}
+
+func newDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newMetrics(t *testing.T, values ...int64) []model.Metric {
+	metrics := make([]model.Metric, len(values))
+	for i, v := range values {
+		m, err := model.NewMetric("test", map[string]string{"device_id": "a"},
+			map[string]interface{}{"value": v}, time.Unix(0, v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics[i] = m
+	}
+	return metrics
+}
+
+func waitFor(t *testing.T, w *WAL, db *testDatabase, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for len(db.values()) < n || w.Buffered() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics were not replayed: %v", db.values())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func checkValues(t *testing.T, values []int64, expected ...int64) {
+	if len(values) != len(expected) {
+		t.Fatalf("wrong values: %v != %v", values, expected)
+	}
+	for i := range values {
+		if values[i] != expected[i] {
+			t.Fatalf("wrong values: %v != %v", values, expected)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(&testDatabase{}, Options{}); err == nil {
+		t.Error("empty directory should give an error")
+	}
+}
+
+func TestWAL_Outage(t *testing.T) {
+	dir := newDir(t)
+	defer os.RemoveAll(dir)
+
+	db := &testDatabase{}
+	w, err := New(db, Options{Dir: dir, RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.Write(newMetrics(t, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Buffered() != 0 {
+		t.Error("metrics should be written directly when the database is available")
+	}
+
+	db.setError(unavailable)
+	for i := int64(3); i <= 6; i++ {
+		if err := w.Write(newMetrics(t, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if w.Buffered() == 0 {
+		t.Error("metrics should be buffered when the database is unavailable")
+	}
+
+	db.setError(nil)
+	if err := w.Write(newMetrics(t, 7)); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, w, db, 7)
+	checkValues(t, db.values(), 1, 2, 3, 4, 5, 6, 7)
+}
+
+func TestWAL_Rejected(t *testing.T) {
+	dir := newDir(t)
+	defer os.RemoveAll(dir)
+
+	db := &testDatabase{err: unavailable}
+
+	var mu sync.Mutex
+	var rejected []model.Metric
+	w, err := New(db, Options{Dir: dir, RetryInterval: 10 * time.Millisecond, Rejected: func(metrics []model.Metric, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		rejected = append(rejected, metrics...)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.Write(newMetrics(t, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	db.setError(&influxdb2.StatusError{StatusCode: 400, Status: "400 Bad Request", Message: "partial write: field type conflict"})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for w.Buffered() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("rejected metrics were not dropped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(rejected) != 2 {
+		t.Errorf("the rejected metrics should be passed to Rejected: %v", rejected)
+	}
+}
+
+func TestWAL_Invalid(t *testing.T) {
+	dir := newDir(t)
+	defer os.RemoveAll(dir)
+
+	db := &testDatabase{err: unavailable}
+
+	var mu sync.Mutex
+	var invalid []byte
+	w, err := New(db, Options{Dir: dir, RetryInterval: 10 * time.Millisecond, Invalid: func(lines []byte, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		invalid = append(invalid, lines...)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// a record with a valid checksum, but a line that isn't a metric
+	if err := w.append([]byte("test,device_id=a value=1i 1\ntest value=\"open\ntest,device_id=a value=2i 2\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	db.setError(nil)
+	w.notify()
+	waitFor(t, w, db, 2)
+
+	checkValues(t, db.values(), 1, 2)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if string(invalid) != "test value=\"open\n" {
+		t.Errorf("the invalid line should be passed to Invalid: %q", invalid)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	metrics := newMetrics(t, 1)
+
+	invalid, err := model.NewMetric("test", nil, map[string]interface{}{"value": math.NaN()}, time.Unix(0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	untimed, err := model.NewMetric("test", nil, map[string]interface{}{"value": int64(3)}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	unmarshalled, err := model.Unmarshal(marshal(append(metrics, invalid, untimed)), "ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unmarshalled) != 2 {
+		t.Fatalf("invalid metrics should be dropped: %v", unmarshalled)
+	}
+
+	if unmarshalled[1].Time().Before(before) {
+		t.Errorf("metrics without a time should get the time they're buffered: %s", unmarshalled[1].Time())
+	}
+}
+
+func TestWAL_Restart(t *testing.T) {
+	dir := newDir(t)
+	defer os.RemoveAll(dir)
+
+	db := &testDatabase{err: unavailable}
+	w, err := New(db, Options{Dir: dir, RetryInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		if err := w.Write(newMetrics(t, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	db = &testDatabase{}
+	w, err = New(db, Options{Dir: dir, RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, w, db, 3)
+	w.Close()
+	checkValues(t, db.values(), 1, 2, 3)
+
+	// the written metrics are not written again after the next restart
+	db = &testDatabase{}
+	w, err = New(db, Options{Dir: dir, RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	time.Sleep(30 * time.Millisecond)
+	checkValues(t, db.values())
+}
+
+func TestWAL_MaxSize(t *testing.T) {
+	dir := newDir(t)
+	defer os.RemoveAll(dir)
+
+	db := &testDatabase{err: unavailable}
+	w, err := New(db, Options{Dir: dir, MaxSize: 400, SegmentSize: 100, RetryInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for i := int64(1); i <= 20; i++ {
+		if err := w.Write(newMetrics(t, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if w.Dropped() == 0 || w.Buffered() > 400 {
+		t.Errorf("oldest metrics should be dropped: %d buffered, %d dropped", w.Buffered(), w.Dropped())
+	}
+
+	db.setError(nil)
+	w.notify()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for w.Buffered() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	values := db.values()
+	if len(values) == 0 || len(values) >= 20 || values[len(values)-1] != 20 {
+		t.Fatalf("only the newest metrics should be written: %v", values)
+	}
+	for i := 1; i < len(values); i++ {
+		if values[i] != values[i-1]+1 {
+			t.Fatalf("metrics should be written in order: %v", values)
+		}
+	}
+}
+
+func TestWAL_Checksum(t *testing.T) {
+	dir := newDir(t)
+	defer os.RemoveAll(dir)
+
+	db := &testDatabase{err: unavailable}
+	w, err := New(db, Options{Dir: dir, RetryInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 2; i++ {
+		if err := w.Write(newMetrics(t, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	// corrupt the last byte of the segment, the second record
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("should have 1 segment: %v", segments)
+	}
+
+	data, err := ioutil.ReadFile(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-2] ^= 0xff
+	if err := ioutil.WriteFile(segments[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db = &testDatabase{}
+	w, err = New(db, Options{Dir: dir, RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	waitFor(t, w, db, 1)
+	checkValues(t, db.values(), 1)
+
+	if w.Dropped() == 0 {
+		t.Error("corrupt record should be dropped")
+	}
+}
@@ -0,0 +1,156 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package deadletter
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
+)
+
+// Reasons of dead letters
+const (
+	ReasonParse        = "parse"
+	ReasonUnclassified = "unclassified"
+	ReasonWrite        = "write"
+	ReasonSchema       = "schema"
+)
+
+// Letter is a message that could not be parsed or classified, had values that don't fit
+// the schemas or whose metrics were rejected by the output, with everything
+// needed to ingest it again. The
+// topic of a semtech packet is the gateway that forwarded it.
+//
+// The metrics an output rejects after they were batched or buffered on disk
+// are stored without a message, the payload is then the metrics in the line
+// protocol (ns) and Output is the output that rejected them.
+type Letter struct {
+	Time    time.Time `json:"time"`
+	Route   string    `json:"route,omitempty"`
+	Output  string    `json:"output,omitempty"`
+	Topic   string    `json:"topic"`
+	Payload []byte    `json:"payload"`
+	Reason  string    `json:"reason"`
+	Error   string    `json:"error"`
+}
+
+func New(route, topic string, payload []byte, reason string, err error) Letter {
+	letter := Letter{
+		Time:    time.Now().UTC(),
+		Route:   route,
+		Topic:   topic,
+		Payload: payload,
+		Reason:  reason,
+	}
+
+	if err != nil {
+		letter.Error = err.Error()
+	}
+
+	return letter
+}
+
+// NewRejected returns the letter of metrics that were rejected by output.
+// Metrics without a time get the time of the letter, metrics that can't be
+// marshalled are left out.
+func NewRejected(output string, metrics []model.Metric, err error) Letter {
+	now := time.Now()
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		if m.Time().IsZero() {
+			stamped, err := model.NewMetric(m.Name(), m.Tags(), m.Fields(), now)
+			if err == nil {
+				m = stamped
+			}
+		}
+
+		line, err := model.MarshalLine(m, "ns")
+		if err != nil {
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	letter := New("", "", buf.Bytes(), ReasonWrite, err)
+	letter.Time = now.UTC()
+	letter.Output = output
+
+	return letter
+}
+
+// NewInvalid returns the letter of lines in the line protocol (ns) that output
+// buffered, but couldn't read back as metrics.
+func NewInvalid(output string, lines []byte, err error) Letter {
+	letter := New("", "", lines, ReasonWrite, err)
+	letter.Output = output
+
+	return letter
+}
+
+// Metrics returns the metrics of a letter of rejected metrics.
+func (l Letter) Metrics() ([]model.Metric, error) {
+	metrics, err := model.Unmarshal(l.Payload, "ns")
+	if err != nil {
+		return nil, errors.Wrap(err, "[DeadLetter] error reading rejected metrics")
+	}
+
+	return metrics, nil
+}
+
+type Store interface {
+	Store(letter Letter) error
+	Close() error
+}
+
+// Stores stores the letters in every store.
+type Stores []Store
+
+func (s Stores) Store(letter Letter) error {
+	var failed error
+	for _, store := range s {
+		if err := store.Store(letter); err != nil {
+			failed = err
+		}
+	}
+
+	if failed != nil {
+		return errors.Wrap(failed, "[DeadLetter] error storing letter")
+	}
+
+	return nil
+}
+
+func (s Stores) Close() error {
+	var failed error
+	for _, store := range s {
+		if err := store.Close(); err != nil {
+			failed = err
+		}
+	}
+
+	return failed
+}
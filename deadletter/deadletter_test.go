@@ -0,0 +1,55 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package deadletter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/pkg/errors"
+)
+
+func TestNewRejected(t *testing.T) {
+	now := time.Unix(0, 1514764800000000000).UTC()
+	metric, err := model.NewMetric("coverage", map[string]string{"gateway_id": "gw-1"},
+		map[string]interface{}{"rssi": -97.0}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	letter := NewRejected("influxdb", []model.Metric{metric}, errors.New("partial write"))
+	if letter.Output != "influxdb" || letter.Reason != ReasonWrite || letter.Error != "partial write" {
+		t.Errorf("wrong letter: %+v", letter)
+	}
+
+	metrics, err := letter.Metrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 || metrics[0].Name() != "coverage" || metrics[0].Tags()["gateway_id"] != "gw-1" ||
+		metrics[0].Fields()["rssi"] != -97.0 || !metrics[0].Time().Equal(now) {
+		t.Errorf("wrong metrics: %v", metrics)
+	}
+}
@@ -0,0 +1,246 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultMaxSize  = 10 << 20
+	DefaultMaxFiles = 5
+)
+
+// FileStore appends the letters as json lines to a file. When the file is
+// larger than MaxSize it's rotated to path.1, path.1 to path.2 and so on,
+// and the files after MaxFiles are deleted.
+type FileStore struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewFileStore(path string, maxSize int64, maxFiles int) (*FileStore, error) {
+	if len(path) == 0 {
+		return nil, errors.New("[DeadLetter] path cannot be empty")
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxFiles
+	}
+
+	s := &FileStore{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) Store(letter Letter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return errors.Wrap(err, "[DeadLetter] error marshalling letter")
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return errors.New("[DeadLetter] trying to store after close")
+	}
+
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "[DeadLetter] error writing letter")
+	}
+
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileStore) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "[DeadLetter] error opening file")
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "[DeadLetter] error opening file")
+	}
+
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileStore) rotate() error {
+	s.file.Close()
+	s.file = nil
+
+	os.Remove(rotated(s.path, s.maxFiles))
+	for i := s.maxFiles - 1; i > 0; i-- {
+		os.Rename(rotated(s.path, i), rotated(s.path, i+1))
+	}
+
+	if err := os.Rename(s.path, rotated(s.path, 1)); err != nil {
+		return errors.Wrap(err, "[DeadLetter] error rotating file")
+	}
+
+	return s.open()
+}
+
+func rotated(path string, i int) string {
+	return path + "." + strconv.Itoa(i)
+}
+
+// Files returns the files of the store at path that exist, oldest first.
+func Files(path string) []string {
+	var files []string
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated(path, i)); err != nil {
+			break
+		}
+		files = append([]string{rotated(path, i)}, files...)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+
+	return files
+}
+
+// Read returns the letters of the store at path, oldest first.
+func Read(path string) ([]Letter, error) {
+	var letters []Letter
+
+	for _, file := range Files(path) {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "[DeadLetter] error opening file")
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16<<20)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+
+			var letter Letter
+			if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+				f.Close()
+				return nil, errors.Wrapf(err, "[DeadLetter] invalid letter at %s:%d", file, line)
+			}
+			letters = append(letters, letter)
+		}
+
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "[DeadLetter] error reading %s", file)
+		}
+	}
+
+	return letters, nil
+}
+
+// Replace replaces the letters of the store at path, e.g. with the ones that
+// still fail after they were ingested again.
+func Replace(path string, letters []Letter) error {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "[DeadLetter] error creating file")
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, letter := range letters {
+		if err := enc.Encode(letter); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return errors.Wrap(err, "[DeadLetter] error writing letter")
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.Wrap(err, "[DeadLetter] error writing file")
+	}
+	f.Close()
+
+	for _, file := range Files(path) {
+		if file != path {
+			os.Remove(file)
+		}
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrap(err, "[DeadLetter] error replacing file")
+	}
+
+	return nil
+}
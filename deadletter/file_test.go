@@ -0,0 +1,131 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package deadletter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func newPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "deadletters.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestFileStore(t *testing.T) {
+	path, cleanup := newPath(t)
+	defer cleanup()
+
+	s, err := NewFileStore(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	letter := New("ttn", "app/devices/dev/up", []byte(`{"invalid`), ReasonParse, errors.New("unexpected end of JSON input"))
+	if err := s.Store(letter); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if err := s.Store(letter); err == nil {
+		t.Error("storing after close should give an error")
+	}
+
+	letters, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(letters) != 1 {
+		t.Fatal("should have 1 letter")
+	}
+
+	l := letters[0]
+	if l.Topic != letter.Topic || string(l.Payload) != string(letter.Payload) || l.Reason != ReasonParse ||
+		l.Error != letter.Error || l.Route != "ttn" || !l.Time.Equal(letter.Time) {
+		t.Errorf("wrong letter: %+v", l)
+	}
+
+	if _, err := NewFileStore("", 0, 0); err == nil {
+		t.Error("empty path should give an error")
+	}
+}
+
+func TestFileStore_Rotate(t *testing.T) {
+	path, cleanup := newPath(t)
+	defer cleanup()
+
+	s, err := NewFileStore(path, 150, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := s.Store(New("", strconv.Itoa(i), []byte("payload"), ReasonWrite, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	if files := Files(path); len(files) != 3 {
+		t.Errorf("should have the file and 2 rotated files: %v", files)
+	}
+
+	letters, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(letters) == 0 || len(letters) == 10 || letters[len(letters)-1].Topic != "9" {
+		t.Fatalf("only the newest letters should be kept: %d", len(letters))
+	}
+
+	for i := 1; i < len(letters); i++ {
+		previous, _ := strconv.Atoi(letters[i-1].Topic)
+		if letters[i].Topic != strconv.Itoa(previous+1) {
+			t.Fatal("letters should be read oldest first")
+		}
+	}
+
+	if err := Replace(path, letters[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if files := Files(path); len(files) != 1 {
+		t.Errorf("rotated files should be deleted: %v", files)
+	}
+
+	letters, err = Read(path)
+	if err != nil || len(letters) != 1 {
+		t.Errorf("should have 1 letter after replacing: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package deadletter
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Publisher publishes a message on an MQTT topic, like input.MQTT
+type Publisher interface {
+	Publish(topic string, payload []byte) error
+}
+
+// MQTTStore publishes the letters as json to a topic.
+type MQTTStore struct {
+	publisher Publisher
+	topic     string
+}
+
+func NewMQTTStore(publisher Publisher, topic string) (*MQTTStore, error) {
+	if len(topic) == 0 {
+		return nil, errors.New("[DeadLetter] topic cannot be empty")
+	}
+
+	return &MQTTStore{
+		publisher: publisher,
+		topic:     topic,
+	}, nil
+}
+
+func (s *MQTTStore) Store(letter Letter) error {
+	payload, err := json.Marshal(letter)
+	if err != nil {
+		return errors.Wrap(err, "[DeadLetter] error marshalling letter")
+	}
+
+	if err := s.publisher.Publish(s.topic, payload); err != nil {
+		return errors.Wrap(err, "[DeadLetter] error publishing letter")
+	}
+
+	return nil
+}
+
+// Close doesn't close the publisher, it's closed by its owner.
+func (s *MQTTStore) Close() error {
+	return nil
+}
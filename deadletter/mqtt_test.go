@@ -0,0 +1,73 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package deadletter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type testPublisher struct {
+	topic   string
+	payload []byte
+	err     error
+}
+
+func (p *testPublisher) Publish(topic string, payload []byte) error {
+	p.topic = topic
+	p.payload = payload
+	return p.err
+}
+
+func TestMQTTStore(t *testing.T) {
+	p := &testPublisher{}
+
+	if _, err := NewMQTTStore(p, ""); err == nil {
+		t.Error("empty topic should give an error")
+	}
+
+	s, err := NewMQTTStore(p, "lora-mqtt/deadletters")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Store(New("", "app/devices/dev/up", []byte{0x01, 0xff}, ReasonParse, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	var letter Letter
+	if err := json.Unmarshal(p.payload, &letter); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.topic != "lora-mqtt/deadletters" || letter.Topic != "app/devices/dev/up" || string(letter.Payload) != "\x01\xff" {
+		t.Errorf("wrong letter published: %s %s", p.topic, p.payload)
+	}
+
+	p.err = errors.New("not connected")
+	if err := Stores([]Store{s}).Store(letter); err == nil {
+		t.Error("failed publish should give an error")
+	}
+}
@@ -143,6 +143,18 @@ func (m *MQTT) Subscribe(topic string) error {
 	}
 }
 
+func (m *MQTT) Publish(topic string, payload []byte) error {
+	if m.client != nil && m.client.IsConnected() {
+		if token := m.client.Publish(topic, byte(m.options.QoS), false, payload); token.Wait() && token.Error() != nil {
+			return errors.Wrapf(token.Error(), "[MQTT] error publishing to %s", topic)
+		}
+
+		return nil
+	} else {
+		return errors.New("[MQTT] trying to publish while not connected")
+	}
+}
+
 func (m *MQTT) onReceive(_ paho.Client, message paho.Message) {
 	m.Incoming <- message
 }
@@ -0,0 +1,186 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"encoding/hex"
+	"net"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+)
+
+// Semtech packet forwarder (GWMP) protocol, see
+// https://github.com/Lora-net/packet_forwarder/blob/master/PROTOCOL.TXT
+const (
+	semtechVersion1 = 1
+	semtechVersion2 = 2
+
+	semtechPushData = 0x00
+	semtechPushAck  = 0x01
+	semtechPullData = 0x02
+	semtechPullAck  = 0x04
+	semtechTxAck    = 0x05
+
+	semtechHeaderSize = 12
+	semtechMaxPacket  = 65507
+)
+
+type Semtech struct {
+	options SemtechOptions
+	conn    *net.UDPConn
+
+	Incoming chan SemtechPacket
+	Done     chan struct{}
+
+	sync.Mutex
+}
+
+type SemtechOptions struct {
+	Bind string
+}
+
+type SemtechPacket struct {
+	GatewayEUI string
+	Payload    []byte
+}
+
+func NewSemtech(options SemtechOptions) *Semtech {
+	return &Semtech{
+		options: options,
+	}
+}
+
+func (s *Semtech) Connect() error {
+	s.Lock()
+	defer s.Unlock()
+
+	addr, err := net.ResolveUDPAddr("udp", s.options.Bind)
+	if err != nil {
+		return errors.Wrapf(err, "[Semtech] invalid bind address: %s", s.options.Bind)
+	}
+
+	s.conn, err = net.ListenUDP("udp", addr)
+	if err != nil {
+		return errors.Wrapf(err, "[Semtech] error listening on %s", s.options.Bind)
+	}
+
+	s.Incoming = make(chan SemtechPacket)
+	s.Done = make(chan struct{})
+
+	log.Infof("[Semtech] listening on udp %s", s.conn.LocalAddr())
+
+	go s.listen(s.conn)
+
+	return nil
+}
+
+func (s *Semtech) listen(conn *net.UDPConn) {
+	buf := make([]byte, semtechMaxPacket)
+
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.Done:
+				return
+			default:
+			}
+			log.WithError(err).Warn("[Semtech] error reading udp packet")
+			continue
+		}
+
+		if err := s.handle(conn, buf[:n], addr); err != nil {
+			log.WithError(err).WithField("addr", addr).Warn("[Semtech] dropping packet")
+		}
+	}
+}
+
+func (s *Semtech) handle(conn *net.UDPConn, packet []byte, addr *net.UDPAddr) error {
+	if len(packet) < 4 {
+		return errors.Errorf("[Semtech] packet too short (%d bytes)", len(packet))
+	}
+
+	if packet[0] != semtechVersion1 && packet[0] != semtechVersion2 {
+		return errors.Errorf("[Semtech] unsupported protocol version: %d", packet[0])
+	}
+
+	switch packet[3] {
+	case semtechPushData:
+		if len(packet) < semtechHeaderSize {
+			return errors.New("[Semtech] PUSH_DATA without gateway EUI")
+		}
+
+		if err := s.ack(conn, packet, semtechPushAck, addr); err != nil {
+			return err
+		}
+
+		payload := make([]byte, len(packet)-semtechHeaderSize)
+		copy(payload, packet[semtechHeaderSize:])
+
+		select {
+		case s.Incoming <- SemtechPacket{
+			GatewayEUI: hex.EncodeToString(packet[4:semtechHeaderSize]),
+			Payload:    payload,
+		}:
+		case <-s.Done:
+		}
+	case semtechPullData:
+		if len(packet) < semtechHeaderSize {
+			return errors.New("[Semtech] PULL_DATA without gateway EUI")
+		}
+
+		log.Debugf("[Semtech] keepalive from gateway %s", hex.EncodeToString(packet[4:semtechHeaderSize]))
+
+		return s.ack(conn, packet, semtechPullAck, addr)
+	case semtechTxAck:
+		// downlinks are never scheduled, so there is nothing to acknowledge
+	default:
+		return errors.Errorf("[Semtech] unknown packet type: 0x%02x", packet[3])
+	}
+
+	return nil
+}
+
+func (s *Semtech) ack(conn *net.UDPConn, packet []byte, identifier byte, addr *net.UDPAddr) error {
+	ack := []byte{packet[0], packet[1], packet[2], identifier}
+
+	if _, err := conn.WriteToUDP(ack, addr); err != nil {
+		return errors.Wrap(err, "[Semtech] error sending ack")
+	}
+
+	return nil
+}
+
+func (s *Semtech) Close() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.conn != nil {
+		close(s.Done)
+		s.conn.Close()
+		s.conn = nil
+		log.Info("[Semtech] stopped listening")
+	}
+}
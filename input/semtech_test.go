@@ -0,0 +1,131 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package input
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+var semtechOptions = SemtechOptions{
+	Bind: "127.0.0.1:0",
+}
+
+func dialSemtech(t *testing.T, s *Semtech) *net.UDPConn {
+	conn, err := net.DialUDP("udp", nil, s.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	return conn
+}
+
+func TestSemtech_Connect(t *testing.T) {
+	s := NewSemtech(semtechOptions)
+
+	if err := s.Connect(); err != nil {
+		t.Error(err)
+	}
+
+	s.Close()
+}
+
+func TestSemtech_Connect2(t *testing.T) {
+	s := NewSemtech(SemtechOptions{Bind: "invalid"})
+
+	if err := s.Connect(); err == nil {
+		t.Error("invalid bind address should give an error")
+	}
+
+	s.Close()
+}
+
+func TestSemtech_PushData(t *testing.T) {
+	s := NewSemtech(semtechOptions)
+
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn := dialSemtech(t, s)
+	defer conn.Close()
+
+	payload := []byte(`{"stat":{"rxnb":1}}`)
+	packet := append([]byte{2, 0xab, 0xcd, semtechPushData, 0xb8, 0x27, 0xeb, 0xff, 0xfe, 0x12, 0x34, 0x56}, payload...)
+
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+
+	ack := make([]byte, 4)
+	if _, err := conn.Read(ack); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(ack, []byte{2, 0xab, 0xcd, semtechPushAck}) {
+		t.Errorf("invalid PUSH_ACK: %v", ack)
+	}
+
+	select {
+	case p := <-s.Incoming:
+		if p.GatewayEUI != "b827ebfffe123456" {
+			t.Errorf("wrong gateway EUI: %s", p.GatewayEUI)
+		}
+		if !bytes.Equal(p.Payload, payload) {
+			t.Errorf("wrong payload: %s", p.Payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("no packet received")
+	}
+}
+
+func TestSemtech_PullData(t *testing.T) {
+	s := NewSemtech(semtechOptions)
+
+	if err := s.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn := dialSemtech(t, s)
+	defer conn.Close()
+
+	packet := []byte{2, 0x12, 0x34, semtechPullData, 0xb8, 0x27, 0xeb, 0xff, 0xfe, 0x12, 0x34, 0x56}
+
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+
+	ack := make([]byte, 4)
+	if _, err := conn.Read(ack); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(ack, []byte{2, 0x12, 0x34, semtechPullAck}) {
+		t.Errorf("invalid PULL_ACK: %v", ack)
+	}
+}
@@ -0,0 +1,386 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FieldType is one of the types InfluxDB accepts as field value. A field
+// value of every type is stored as int64, uint64, float64, bool or string.
+type FieldType int
+
+const (
+	Int FieldType = iota
+	Uint
+	Float
+	Bool
+	String
+)
+
+var fieldTypeNames = []string{"int", "uint", "float", "bool", "string"}
+
+func (t FieldType) String() string {
+	if t < 0 || int(t) >= len(fieldTypeNames) {
+		return "FieldType(" + strconv.Itoa(int(t)) + ")"
+	}
+	return fieldTypeNames[t]
+}
+
+func ParseFieldType(name string) (FieldType, error) {
+	for i, n := range fieldTypeNames {
+		if n == name {
+			return FieldType(i), nil
+		}
+	}
+
+	return 0, errors.Errorf("[Field] unknown field type: %s", name)
+}
+
+// FieldValue returns the typed value of a field and its type, Go integers and
+// floats of every size are widened and json.Number is parsed.
+func FieldValue(v interface{}) (interface{}, FieldType, error) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), Int, nil
+	case int8:
+		return int64(v), Int, nil
+	case int16:
+		return int64(v), Int, nil
+	case int32:
+		return int64(v), Int, nil
+	case int64:
+		return v, Int, nil
+	case uint:
+		return uint64(v), Uint, nil
+	case uint8:
+		return uint64(v), Uint, nil
+	case uint16:
+		return uint64(v), Uint, nil
+	case uint32:
+		return uint64(v), Uint, nil
+	case uint64:
+		return v, Uint, nil
+	case float32:
+		return float64(v), Float, nil
+	case float64:
+		return v, Float, nil
+	case bool:
+		return v, Bool, nil
+	case string:
+		return v, String, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, Int, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "[Field] invalid number: %s", v)
+		}
+		return f, Float, nil
+	}
+
+	return nil, 0, errors.Errorf("[Field] unsupported field value %v (%T)", v, v)
+}
+
+// ConvertField converts a field value to the given type. Conversions that
+// would lose information, like 1.5 to an integer, return an error.
+func ConvertField(v interface{}, t FieldType) (interface{}, error) {
+	value, from, err := FieldValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if from == t {
+		return value, nil
+	}
+
+	var converted interface{}
+	switch t {
+	case Int:
+		converted, err = toInt(value)
+	case Uint:
+		converted, err = toUint(value)
+	case Float:
+		converted, err = toFloat(value)
+	case Bool:
+		converted, err = toBool(value)
+	case String:
+		converted = toString(value)
+	default:
+		err = errors.Errorf("[Field] unknown field type: %s", t)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "[Field] can't convert %v (%s) to %s", value, from, t)
+	}
+
+	return converted, nil
+}
+
+func toInt(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case uint64:
+		if v > math.MaxInt64 {
+			return nil, errors.New("out of range")
+		}
+		return int64(v), nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return nil, errors.New("not an integer")
+		}
+		return int64(v), nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+
+	return nil, errors.New("unsupported value")
+}
+
+func toUint(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return nil, errors.New("out of range")
+		}
+		return uint64(v), nil
+	case float64:
+		if v != math.Trunc(v) || v < 0 || v >= math.MaxUint64 {
+			return nil, errors.New("not an unsigned integer")
+		}
+		return uint64(v), nil
+	case bool:
+		if v {
+			return uint64(1), nil
+		}
+		return uint64(0), nil
+	case string:
+		return strconv.ParseUint(v, 10, 64)
+	}
+
+	return nil, errors.New("unsupported value")
+}
+
+func toFloat(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return float64(1), nil
+		}
+		return float64(0), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+
+	return nil, errors.New("unsupported value")
+}
+
+func toBool(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int64:
+		return v != 0, nil
+	case uint64:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	case string:
+		return strconv.ParseBool(v)
+	}
+
+	return nil, errors.New("unsupported value")
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+
+	return ""
+}
+
+// Flatten replaces nested objects and arrays by their values with dotted
+// keys, e.g. {"gps": {"lat": 51.0}} becomes {"gps.lat": 51.0}. Values that
+// are nil (JSON null) are left out.
+func Flatten(fields map[string]interface{}) map[string]interface{} {
+	if fields == nil {
+		return nil
+	}
+
+	flat := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		flatten(flat, k, v)
+	}
+
+	return flat
+}
+
+func flatten(flat map[string]interface{}, key string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, nested := range v {
+			flatten(flat, key+"."+k, nested)
+		}
+	case []interface{}:
+		for i, nested := range v {
+			flatten(flat, key+"."+strconv.Itoa(i), nested)
+		}
+	case nil:
+	default:
+		flat[key] = v
+	}
+}
+
+// Schema has the types of the fields of a measurement. Fields without a type
+// keep the type of their value.
+type Schema map[string]FieldType
+
+func NewSchema(types map[string]string) (Schema, error) {
+	schema := make(Schema, len(types))
+	for field, name := range types {
+		t, err := ParseFieldType(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[Schema] field %s", field)
+		}
+		schema[field] = t
+	}
+
+	return schema, nil
+}
+
+// Apply converts the fields of the metric to their type in the schema. The
+// fields without a value (JSON null) are removed, and so are the fields with
+// a value that can't be converted, which give a FieldsError.
+func (s Schema) Apply(m Metric) error {
+	keys := make([]string, 0, len(m.Fields()))
+	for k := range m.Fields() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var rejected *FieldsError
+	typed := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		v := m.Fields()[k]
+		if v == nil {
+			continue
+		}
+
+		var value interface{}
+		var err error
+
+		if t, ok := s[k]; ok {
+			value, err = ConvertField(v, t)
+		} else {
+			value, _, err = FieldValue(v)
+		}
+		if err != nil {
+			if rejected == nil {
+				rejected = &FieldsError{
+					Measurement: m.Name(),
+					Values:      make(map[string]interface{}),
+					Err:         errors.Wrapf(err, "field %s", k),
+				}
+			}
+			rejected.Values[k] = v
+			continue
+		}
+
+		typed[k] = value
+	}
+
+	if len(typed) == 0 {
+		if rejected == nil {
+			rejected = &FieldsError{Measurement: m.Name(), Err: errors.New("no field values")}
+		}
+		rejected.Empty = true
+		return rejected
+	}
+
+	for _, k := range keys {
+		if value, ok := typed[k]; ok {
+			m.AddField(k, value)
+		} else {
+			m.RemoveField(k)
+		}
+	}
+
+	if rejected != nil {
+		return rejected
+	}
+
+	return nil
+}
+
+// FieldsError has the values of the fields that were removed because they
+// don't fit the schema. Err is the error of the first of them. When Empty is
+// true no field is left and the metric can't be written.
+type FieldsError struct {
+	Measurement string
+	Values      map[string]interface{}
+	Err         error
+	Empty       bool
+}
+
+func (e *FieldsError) Error() string {
+	keys := make([]string, 0, len(e.Values))
+	for k, v := range e.Values {
+		keys = append(keys, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(keys)
+
+	return fmt.Sprintf("[Schema] %s: rejected fields %s: %s", e.Measurement, strings.Join(keys, ", "), e.Err)
+}
+
+// Schemas has the schema of every measurement by name.
+type Schemas map[string]Schema
+
+// Apply applies the schema of the measurement of the metric, metrics without
+// a schema only get typed values.
+func (s Schemas) Apply(m Metric) error {
+	return s[m.Name()].Apply(m)
+}
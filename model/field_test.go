@@ -0,0 +1,208 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestConvertField(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		t        FieldType
+		expected interface{}
+	}{
+		{5, Int, int64(5)},
+		{uint8(5), Int, int64(5)},
+		{5.0, Int, int64(5)},
+		{"-12", Int, int64(-12)},
+		{true, Int, int64(1)},
+		{int32(7), Uint, uint64(7)},
+		{7, Float, float64(7)},
+		{float32(1.5), Float, float64(1.5)},
+		{json.Number("12"), Float, float64(12)},
+		{"2.25", Float, 2.25},
+		{0, Bool, false},
+		{"true", Bool, true},
+		{2.5, String, "2.5"},
+		{int64(-3), String, "-3"},
+	}
+
+	for _, test := range tests {
+		value, err := ConvertField(test.value, test.t)
+		if err != nil {
+			t.Errorf("%v to %s: %s", test.value, test.t, err)
+			continue
+		}
+		if value != test.expected {
+			t.Errorf("%v to %s: %v (%T) != %v (%T)", test.value, test.t, value, value, test.expected, test.expected)
+		}
+	}
+
+	invalid := []struct {
+		value interface{}
+		t     FieldType
+	}{
+		{1.5, Int},
+		{-1, Uint},
+		{"abc", Float},
+		{"maybe", Bool},
+		{map[string]interface{}{"a": 1}, String},
+		{nil, Int},
+	}
+
+	for _, test := range invalid {
+		if _, err := ConvertField(test.value, test.t); err == nil {
+			t.Errorf("%v to %s should give an error", test.value, test.t)
+		}
+	}
+}
+
+func TestParseFieldType(t *testing.T) {
+	for _, ft := range []FieldType{Int, Uint, Float, Bool, String} {
+		parsed, err := ParseFieldType(ft.String())
+		if err != nil || parsed != ft {
+			t.Errorf("%s should parse", ft)
+		}
+	}
+
+	if _, err := ParseFieldType("double"); err == nil {
+		t.Error("unknown type should give an error")
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	fields := map[string]interface{}{
+		"size": 5,
+		"gps": map[string]interface{}{
+			"lat": 51.0,
+			"fix": map[string]interface{}{"sats": 7},
+		},
+		"temperatures": []interface{}{20.5, 21},
+		"battery":      nil,
+	}
+
+	flat := Flatten(fields)
+
+	expected := map[string]interface{}{
+		"size":           5,
+		"gps.lat":        51.0,
+		"gps.fix.sats":   7,
+		"temperatures.0": 20.5,
+		"temperatures.1": 21,
+	}
+
+	if len(flat) != len(expected) {
+		t.Errorf("wrong number of fields: %v", flat)
+	}
+
+	for k, v := range expected {
+		if flat[k] != v {
+			t.Errorf("wrong field %s: %v != %v", k, flat[k], v)
+		}
+	}
+
+	if Flatten(nil) != nil {
+		t.Error("flattening nil should give nil")
+	}
+}
+
+func TestSchema_Apply(t *testing.T) {
+	schema, err := NewSchema(map[string]string{
+		"rssi":  "float",
+		"count": "uint",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schemas := Schemas{"adr": schema}
+
+	metric, err := NewMetric("adr", nil, map[string]interface{}{
+		"rssi":  -84,
+		"count": 3.0,
+		"size":  int32(5),
+	}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := schemas.Apply(metric); err != nil {
+		t.Fatal(err)
+	}
+
+	if metric.Fields()["rssi"] != float64(-84) || metric.Fields()["count"] != uint64(3) || metric.Fields()["size"] != int64(5) {
+		t.Errorf("wrong field types: %#v", metric.Fields())
+	}
+
+	metric, err = NewMetric("adr", nil, map[string]interface{}{"count": -1}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := schemas.Apply(metric); err == nil {
+		t.Error("value that doesn't fit the schema should give an error")
+	}
+
+	metric, err = NewMetric("other", nil, map[string]interface{}{"gps": map[string]interface{}{}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := schemas.Apply(metric); err == nil {
+		t.Error("unsupported value should give an error without a schema")
+	}
+
+	if _, err := NewSchema(map[string]string{"rssi": "double"}); err == nil {
+		t.Error("unknown type should give an error")
+	}
+
+	metric, err = NewMetric("adr", nil, map[string]interface{}{
+		"rssi":    "strong",
+		"count":   3,
+		"battery": nil,
+	}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = schemas.Apply(metric)
+	if e, ok := err.(*FieldsError); !ok || e.Empty || e.Values["rssi"] != "strong" || len(e.Values) != 1 {
+		t.Errorf("only the field that doesn't fit the schema should be rejected: %v", err)
+	}
+
+	if metric.HasField("rssi") || metric.HasField("battery") || metric.Fields()["count"] != uint64(3) {
+		t.Errorf("rejected and null fields should be removed: %#v", metric.Fields())
+	}
+
+	metric, err = NewMetric("other", nil, map[string]interface{}{"battery": nil}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e, ok := schemas.Apply(metric).(*FieldsError); !ok || !e.Empty {
+		t.Error("metric without field values should give an empty fields error")
+	}
+}
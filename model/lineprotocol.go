@@ -0,0 +1,382 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package model
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// backslashes are escaped too, so a name or key that ends in one doesn't
+	// escape the separator after it, and newlines in strings are escaped
+	// because every line is a metric
+	measurementEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// Precision returns the duration of a timestamp unit in the line protocol,
+// the precisions are the same as the ones of influxdb.precision.
+func Precision(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µs":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+
+	return 0, errors.Errorf("[LineProtocol] unknown precision: %s", precision)
+}
+
+// MarshalLine returns the metric in the InfluxDB line protocol, without a
+// newline. Tags with an empty value are left out and a zero time has no
+// timestamp, so the database uses the time of the write.
+func MarshalLine(m Metric, precision string) ([]byte, error) {
+	unit, err := Precision(precision)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	if err := checkKey(m.Name()); err != nil {
+		return nil, err
+	}
+	buf.WriteString(measurementEscaper.Replace(m.Name()))
+
+	tags := m.Tags()
+	for _, k := range sortedKeys(tags) {
+		if len(tags[k]) == 0 {
+			continue
+		}
+		if err := checkKey(k); err != nil {
+			return nil, err
+		}
+		if err := checkKey(tags[k]); err != nil {
+			return nil, err
+		}
+
+		buf.WriteByte(',')
+		buf.WriteString(keyEscaper.Replace(k))
+		buf.WriteByte('=')
+		buf.WriteString(keyEscaper.Replace(tags[k]))
+	}
+
+	fields := m.Fields()
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if len(keys) == 0 {
+		return nil, errors.Errorf("[LineProtocol] %s: missing field(s) (at least one required)", m.Name())
+	}
+
+	for i, k := range keys {
+		if err := checkKey(k); err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			buf.WriteByte(' ')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(keyEscaper.Replace(k))
+		buf.WriteByte('=')
+
+		if err := appendFieldValue(&buf, fields[k]); err != nil {
+			return nil, errors.Wrapf(err, "[LineProtocol] %s: field %s", m.Name(), k)
+		}
+	}
+
+	if !m.Time().IsZero() {
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(m.Time().UnixNano()/int64(unit), 10))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Marshal returns the metrics in the InfluxDB line protocol, one per line.
+func Marshal(metrics []Metric, precision string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	for _, m := range metrics {
+		line, err := MarshalLine(m, precision)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+func appendFieldValue(buf *bytes.Buffer, v interface{}) error {
+	value, t, err := FieldValue(v)
+	if err != nil {
+		return err
+	}
+
+	switch t {
+	case Int:
+		buf.WriteString(strconv.FormatInt(value.(int64), 10))
+		buf.WriteByte('i')
+	case Uint:
+		buf.WriteString(strconv.FormatUint(value.(uint64), 10))
+		buf.WriteByte('u')
+	case Float:
+		f := value.(float64)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return errors.Errorf("unsupported float %v", f)
+		}
+		buf.WriteString(strconv.FormatFloat(f, 'f', -1, 64))
+	case Bool:
+		buf.WriteString(strconv.FormatBool(value.(bool)))
+	case String:
+		buf.WriteByte('"')
+		buf.WriteString(stringEscaper.Replace(value.(string)))
+		buf.WriteByte('"')
+	}
+
+	return nil
+}
+
+func checkKey(key string) error {
+	if len(key) == 0 {
+		return errors.New("[LineProtocol] empty name or key")
+	}
+	if strings.ContainsAny(key, "\n\r") {
+		return errors.Errorf("[LineProtocol] newline in %q", key)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UnmarshalLine reads a metric from a line in the InfluxDB line protocol. A
+// line without a timestamp gives a metric with a zero time.
+func UnmarshalLine(line []byte, precision string) (Metric, error) {
+	unit, err := Precision(precision)
+	if err != nil {
+		return nil, err
+	}
+
+	s := strings.TrimSpace(string(line))
+
+	i := indexUnescaped(s, ' ', false)
+	if i < 0 {
+		return nil, errors.Errorf("[LineProtocol] missing fields: %s", s)
+	}
+	series, rest := s[:i], strings.TrimLeft(s[i+1:], " ")
+
+	i = indexUnescaped(rest, ' ', true)
+	fieldSet, timestamp := rest, ""
+	if i >= 0 {
+		fieldSet, timestamp = rest[:i], strings.TrimSpace(rest[i+1:])
+	}
+
+	keys := splitUnescaped(series, ',', false)
+	name := unescape(keys[0])
+	if len(name) == 0 {
+		return nil, errors.Errorf("[LineProtocol] missing measurement: %s", s)
+	}
+
+	tags := make(map[string]string, len(keys)-1)
+	for _, tag := range keys[1:] {
+		j := indexUnescaped(tag, '=', false)
+		if j <= 0 || j == len(tag)-1 {
+			return nil, errors.Errorf("[LineProtocol] invalid tag %q: %s", tag, s)
+		}
+		tags[unescape(tag[:j])] = unescape(tag[j+1:])
+	}
+
+	fields := make(map[string]interface{})
+	for _, field := range splitUnescaped(fieldSet, ',', true) {
+		j := indexUnescaped(field, '=', false)
+		if j <= 0 {
+			return nil, errors.Errorf("[LineProtocol] invalid field %q: %s", field, s)
+		}
+
+		value, err := parseFieldValue(field[j+1:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "[LineProtocol] invalid field %q", field)
+		}
+		fields[unescape(field[:j])] = value
+	}
+
+	var t time.Time
+	if len(timestamp) > 0 {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[LineProtocol] invalid timestamp: %s", timestamp)
+		}
+		t = time.Unix(0, ts*int64(unit)).UTC()
+	}
+
+	return NewMetric(name, tags, fields, t)
+}
+
+// Unmarshal reads the metrics of every line in the InfluxDB line protocol,
+// empty lines and comments are skipped.
+func Unmarshal(data []byte, precision string) ([]Metric, error) {
+	var metrics []Metric
+
+	for n, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		m, err := UnmarshalLine(line, precision)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[LineProtocol] line %d", n+1)
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics, nil
+}
+
+func parseFieldValue(s string) (interface{}, error) {
+	if len(s) == 0 {
+		return nil, errors.New("empty value")
+	}
+
+	if s[0] == '"' {
+		if len(s) < 2 || s[len(s)-1] != '"' {
+			return nil, errors.New("unterminated string")
+		}
+		return unescapeString(s[1 : len(s)-1]), nil
+	}
+
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+
+	switch s[len(s)-1] {
+	case 'i':
+		return strconv.ParseInt(s[:len(s)-1], 10, 64)
+	case 'u':
+		return strconv.ParseUint(s[:len(s)-1], 10, 64)
+	}
+
+	return strconv.ParseFloat(s, 64)
+}
+
+// indexUnescaped returns the index of the first sep that isn't escaped with a
+// backslash, or inside a string when quotes is set.
+func indexUnescaped(s string, sep byte, quotes bool) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			return i
+		}
+	}
+
+	return -1
+}
+
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	for {
+		i := indexUnescaped(s, sep, quotes)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, =\`, s[i+1]) >= 0 {
+			i++
+		}
+		buf.WriteByte(s[i])
+	}
+
+	return buf.String()
+}
+
+func unescapeString(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case '"', '\\':
+				i++
+			case 'n':
+				i++
+				buf.WriteByte('\n')
+				continue
+			}
+		}
+		buf.WriteByte(s[i])
+	}
+
+	return buf.String()
+}
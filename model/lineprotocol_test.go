@@ -0,0 +1,183 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMarshalLine(t *testing.T) {
+	m, err := NewMetric("lora coverage,test", map[string]string{
+		"device_id":  "sodaq one",
+		"gateway=id": "eui,1",
+		"empty":      "",
+	}, map[string]interface{}{
+		"rssi":    -84,
+		"snr":     7.5,
+		"count":   uint8(3),
+		"valid":   true,
+		"comment": `say "hi" \o/`,
+	}, time.Unix(1520968882, 827000000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := MarshalLine(m, "ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `lora\ coverage\,test,device_id=sodaq\ one,gateway\=id=eui\,1 ` +
+		`comment="say \"hi\" \\o/",count=3u,rssi=-84i,snr=7.5,valid=true 1520968882827`
+	if string(line) != expected {
+		t.Errorf("wrong line:\n%s\n%s", line, expected)
+	}
+
+	m, err = NewMetric("test", nil, map[string]interface{}{"value": 0.65}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line, err = MarshalLine(m, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(line) != "test value=0.65" {
+		t.Errorf("zero time should not have a timestamp: %s", line)
+	}
+
+	if _, err := MarshalLine(m, "d"); err == nil {
+		t.Error("unknown precision should give an error")
+	}
+
+	m, err = NewMetric("test", nil, map[string]interface{}{"gps": map[string]interface{}{}}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MarshalLine(m, ""); err == nil {
+		t.Error("unsupported field value should give an error")
+	}
+}
+
+func TestUnmarshal_RoundTrip(t *testing.T) {
+	timestamp := time.Date(2018, 3, 13, 19, 21, 22, 827671626, time.UTC)
+
+	for _, precision := range []string{"ns", "u", "ms", "s", "m", "h"} {
+		unit, err := Precision(precision)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := NewMetric("lora,coverage", map[string]string{
+			"device_id": "sodaq one",
+			"k=v":       `a\b`,
+			`a\`:        `path\`,
+			"b":         `\,`,
+		}, map[string]interface{}{
+			"rssi":      int64(-84),
+			"snr":       -2.25,
+			"count":     uint64(18446744073709551615),
+			"valid":     false,
+			"comment":   `quote " and, space=`,
+			"multiline": "line 1\nline 2\\n",
+		}, timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := Marshal([]Metric{m, m}, precision)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		metrics, err := Unmarshal(data, precision)
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", precision, err, data)
+		}
+
+		if len(metrics) != 2 {
+			t.Fatalf("%s: should have 2 metrics", precision)
+		}
+
+		r := metrics[1]
+		if r.Name() != m.Name() {
+			t.Errorf("%s: wrong name %s", precision, r.Name())
+		}
+		for k, v := range m.Tags() {
+			if r.Tags()[k] != v {
+				t.Errorf("%s: wrong tag %s: %s != %s", precision, k, r.Tags()[k], v)
+			}
+		}
+		for k, v := range m.Fields() {
+			if r.Fields()[k] != v {
+				t.Errorf("%s: wrong field %s: %v != %v", precision, k, r.Fields()[k], v)
+			}
+		}
+		if !r.Time().Equal(timestamp.Truncate(unit)) {
+			t.Errorf("%s: wrong time %s", precision, r.Time())
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	data := []byte(`# comment
+
+cpu,host=a usage=0.5,idle=t
+cpu value=1i 1520968882
+`)
+
+	metrics, err := Unmarshal(data, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 2 {
+		t.Fatal("should have 2 metrics")
+	}
+
+	if !metrics[0].Time().IsZero() || metrics[0].Fields()["idle"] != true || metrics[0].Tags()["host"] != "a" {
+		t.Errorf("wrong first metric: %v %v", metrics[0].Tags(), metrics[0].Fields())
+	}
+
+	if metrics[1].Time().Unix() != 1520968882 || metrics[1].Fields()["value"] != int64(1) {
+		t.Error("wrong second metric")
+	}
+
+	invalid := []string{
+		"cpu",
+		"cpu value",
+		"cpu,host value=1",
+		`cpu value="open`,
+		"cpu value=1 now",
+		",host=a value=1",
+	}
+
+	for _, line := range invalid {
+		if _, err := UnmarshalLine([]byte(line), ""); err == nil {
+			t.Errorf("%q should give an error", line)
+		}
+	}
+}
@@ -33,10 +33,14 @@ type metric struct {
 	tags   map[string]string
 	fields map[string]interface{}
 	time   time.Time
+
+	// shared is set when the maps are shared with a copy of the metric, they
+	// are cloned before the next change
+	shared bool
 }
 
 type Metric interface {
-	// Getting data structure functions
+	// Getting data structure functions, the returned maps shouldn't be changed
 	Name() string
 	Tags() map[string]string
 	Fields() map[string]interface{}
@@ -51,21 +55,26 @@ type Metric interface {
 	HasField(key string) bool
 	AddField(key string, value interface{})
 	RemoveField(key string) error
+
+	// Copy returns a metric that can be changed without changing this one
+	Copy() Metric
 }
 
+// NewMetric creates a metric with its own copy of the tags and fields, so the
+// maps can be reused for other metrics.
 func NewMetric(name string, tags map[string]string, fields map[string]interface{}, time time.Time) (Metric, error) {
 	if len(name) == 0 {
 		return nil, errors.New("[Metric] missing measurement name")
 	}
 
 	if len(fields) == 0 {
-		return nil, errors.Errorf("[Metric] %s: missing field(s) (at least one required)")
+		return nil, errors.Errorf("[Metric] %s: missing field(s) (at least one required)", name)
 	}
 
 	m := &metric{
 		name:   name,
-		tags:   tags,
-		fields: fields,
+		tags:   copyTags(tags),
+		fields: copyFields(fields),
 		time:   time,
 	}
 
@@ -94,10 +103,12 @@ func (m *metric) HasTag(key string) bool {
 }
 
 func (m *metric) AddTag(key, value string) {
+	m.own()
 	m.tags[key] = value
 }
 
 func (m *metric) RemoveTag(key string) {
+	m.own()
 	delete(m.tags, key)
 }
 
@@ -107,6 +118,7 @@ func (m *metric) HasField(key string) bool {
 }
 
 func (m *metric) AddField(key string, value interface{}) {
+	m.own()
 	m.fields[key] = value
 }
 
@@ -114,6 +126,41 @@ func (m *metric) RemoveField(key string) error {
 	if len(m.fields) == 1 {
 		return errors.New("[Metric] can't delete last field (at least one required)")
 	}
+	m.own()
 	delete(m.fields, key)
 	return nil
 }
+
+func (m *metric) Copy() Metric {
+	m.shared = true
+
+	c := *m
+	return &c
+}
+
+// own clones the maps when they are shared with a copy
+func (m *metric) own() {
+	if !m.shared {
+		return
+	}
+
+	m.tags = copyTags(m.tags)
+	m.fields = copyFields(m.fields)
+	m.shared = false
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	c := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
+
+func copyFields(fields map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(fields)+1)
+	for k, v := range fields {
+		c[k] = v
+	}
+	return c
+}
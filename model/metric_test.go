@@ -0,0 +1,95 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewMetric(t *testing.T) {
+	tags := map[string]string{"device_id": "a"}
+	fields := map[string]interface{}{"size": 5}
+
+	m1, err := NewMetric("test", tags, fields, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m2, err := NewMetric("test", tags, fields, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m1.AddTag("gateway_id", "gw1")
+	m1.AddField("rssi", -80)
+	m2.AddTag("gateway_id", "gw2")
+	m2.AddField("rssi", -100)
+
+	if m1.Tags()["gateway_id"] != "gw1" || m1.Fields()["rssi"] != -80 {
+		t.Error("metrics created from the same maps should not share changes")
+	}
+
+	if len(tags) != 1 || len(fields) != 1 {
+		t.Error("the maps given to the constructor should not change")
+	}
+
+	_, err = NewMetric("", tags, fields, time.Now())
+	if err == nil {
+		t.Error("empty name should give an error")
+	}
+
+	_, err = NewMetric("test", tags, nil, time.Now())
+	if err == nil {
+		t.Error("metric without fields should give an error")
+	}
+}
+
+func TestMetric_Copy(t *testing.T) {
+	m, err := NewMetric("test", map[string]string{"device_id": "a"}, map[string]interface{}{"size": 5}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := m.Copy()
+	c.AddTag("gateway_id", "gw1")
+	c.AddField("rssi", -80)
+
+	if m.HasTag("gateway_id") || m.HasField("rssi") {
+		t.Error("changing the copy should not change the original")
+	}
+
+	m.RemoveTag("device_id")
+	if !c.HasTag("device_id") {
+		t.Error("changing the original should not change the copy")
+	}
+
+	c2 := c.Copy()
+	if err := c2.RemoveField("size"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.HasField("size") || c2.HasField("size") {
+		t.Error("removing a field from a copy should not change the original")
+	}
+}
@@ -0,0 +1,232 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package autodetect dispatches every message to the registered parser of
+// the network server that sent it, so one broker can carry messages of
+// different network servers.
+package autodetect
+
+import (
+	"encoding/json"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/pkg/errors"
+)
+
+const Name = "auto"
+
+var UnclassifiedError = errors.New("[AutoParser] unclassified message")
+
+// DefaultParsers are the parsers messages are detected for, unless the
+// config lists others.
+var DefaultParsers = []string{
+	"ttn",
+	"dingnet",
+	"tts",
+	"chirpstack",
+	"chirpstackprotobuf",
+	"helium",
+	"loriot",
+	"thingpark",
+}
+
+var (
+	chirpstackTopic = regexp.MustCompile(`^application/[^/]+/device/[^/]+/event/up$`)
+	ttsTopic        = regexp.MustCompile(`^v3/[^/]+/devices/[^/]+/up$`)
+	ttnTopic        = regexp.MustCompile(`^[^/]+/devices/[^/]+/up$`)
+)
+
+// Config lists the parsers messages are detected for. The parsers are
+// created with the same config block, so the options of a parser like
+// jsonmap go next to parsers.
+type Config struct {
+	Parsers []string `yaml:"parsers,omitempty" mapstructure:"parsers"`
+}
+
+type autoParser struct {
+	MetricName string
+	Parsers    map[string]parser.Parser
+
+	unclassified uint64
+}
+
+func init() {
+	parser.Register(Name, func(metricName string, config parser.Config) (parser.Parser, error) {
+		var c Config
+		if err := config.Decode(&c); err != nil {
+			return nil, errors.Wrap(err, "[AutoParser] invalid config")
+		}
+
+		return NewWithConfig(metricName, c.Parsers, config)
+	})
+}
+
+// New creates the parsers of names, or of DefaultParsers when names is empty.
+func New(name string, names []string) (parser.Parser, error) {
+	return NewWithConfig(name, names, nil)
+}
+
+// NewWithConfig creates the parsers like New, with config as their
+// configuration.
+func NewWithConfig(name string, names []string, config parser.Config) (parser.Parser, error) {
+	if len(name) == 0 {
+		return nil, errors.New("[AutoParser] name cannot be empty")
+	}
+
+	if len(names) == 0 {
+		names = DefaultParsers
+	}
+
+	p := autoParser{
+		MetricName: name,
+		Parsers:    make(map[string]parser.Parser, len(names)),
+	}
+
+	for _, n := range names {
+		if n == Name {
+			return nil, errors.New("[AutoParser] can't detect messages for itself")
+		}
+
+		sub, err := parser.Create(n, name, config)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[AutoParser] error creating parser %s", n)
+		}
+		p.Parsers[n] = sub
+	}
+
+	return &p, nil
+}
+
+func (p *autoParser) Parse(buf []byte) ([]model.Metric, error) {
+	return p.ParseTopic("", buf)
+}
+
+func (p *autoParser) ParseTopic(topic string, buf []byte) ([]model.Metric, error) {
+	name, reason := Detect(topic, buf)
+
+	sub, ok := p.Parsers[name]
+	if !ok {
+		if len(name) > 0 {
+			reason = "detected " + name + ", which is not enabled"
+		}
+		atomic.AddUint64(&p.unclassified, 1)
+		log.WithFields(log.Fields{
+			"topic":  topic,
+			"reason": reason,
+		}).Debug("[AutoParser] could not classify message")
+		return nil, errors.Wrap(UnclassifiedError, reason)
+	}
+
+	log.WithFields(log.Fields{
+		"topic":  topic,
+		"parser": name,
+		"reason": reason,
+	}).Debug("[AutoParser] detected message format")
+
+	return parser.ParseTopic(sub, topic, buf)
+}
+
+// Unclassified returns the number of messages that matched no parser.
+func (p *autoParser) Unclassified() uint64 {
+	return atomic.LoadUint64(&p.unclassified)
+}
+
+func (p *autoParser) SetDefaultTags(tags map[string]string) {
+	for _, sub := range p.Parsers {
+		sub.SetDefaultTags(tags)
+	}
+}
+
+func (p *autoParser) SetFieldDecoder(decoder parser.FieldDecoder) {
+	for _, sub := range p.Parsers {
+		if setter, ok := sub.(parser.FieldDecoderSetter); ok {
+			setter.SetFieldDecoder(decoder)
+		}
+	}
+}
+
+func (p *autoParser) SetLocationCodec(codec parser.LocationCodec) {
+	for _, sub := range p.Parsers {
+		if setter, ok := sub.(parser.LocationCodecSetter); ok {
+			setter.SetLocationCodec(codec)
+		}
+	}
+}
+
+// Detect returns the name of the parser for a message and why it was
+// chosen, or an empty name and why none matched. JSON messages are detected
+// by their keys, TTN and DingNet use the same format but DingNet messages
+// have no app_id. The topic is only used for binary messages and to explain
+// why a message on a known topic matched no parser.
+func Detect(topic string, buf []byte) (string, string) {
+	var keys map[string]json.RawMessage
+
+	if err := json.Unmarshal(buf, &keys); err != nil {
+		if chirpstackTopic.MatchString(topic) {
+			return "chirpstackprotobuf", "binary message on a chirpstack event topic"
+		}
+		return "", "not a json object and not on a chirpstack event topic"
+	}
+
+	has := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := keys[name]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case has("DevEUI_uplink"):
+		return "thingpark", "DevEUI_uplink key"
+	case has("end_device_ids", "uplink_message"):
+		return "tts", "end_device_ids and uplink_message keys"
+	case has("deviceInfo", "rxInfo"):
+		return "chirpstack", "deviceInfo and rxInfo keys (v4)"
+	case has("devEUI", "rxInfo"):
+		return "chirpstack", "devEUI and rxInfo keys (v3)"
+	case has("hotspots", "payload"):
+		return "helium", "hotspots and payload keys"
+	case has("cmd", "EUI"):
+		return "loriot", "cmd and EUI keys"
+	case has("app_id", "dev_id", "metadata"):
+		return "ttn", "app_id, dev_id and metadata keys"
+	case has("payload_raw", "metadata"):
+		return "dingnet", "payload_raw and metadata keys without app_id"
+	}
+
+	switch {
+	case ttsTopic.MatchString(topic):
+		return "", "tts topic, but no end_device_ids and uplink_message keys"
+	case chirpstackTopic.MatchString(topic):
+		return "", "chirpstack topic, but no rxInfo key"
+	case ttnTopic.MatchString(topic):
+		return "", "ttn topic, but no metadata key"
+	}
+
+	return "", "no known keys or topic"
+}
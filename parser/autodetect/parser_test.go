@@ -0,0 +1,183 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package autodetect
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bullettime/lora-mqtt/parser"
+	_ "github.com/bullettime/lora-mqtt/parser/chirpstackjson"
+	_ "github.com/bullettime/lora-mqtt/parser/chirpstackprotobuf"
+	_ "github.com/bullettime/lora-mqtt/parser/dingnetjson"
+	_ "github.com/bullettime/lora-mqtt/parser/heliumjson"
+	_ "github.com/bullettime/lora-mqtt/parser/jsonmap"
+	_ "github.com/bullettime/lora-mqtt/parser/loriotjson"
+	_ "github.com/bullettime/lora-mqtt/parser/thingparkjson"
+	_ "github.com/bullettime/lora-mqtt/parser/ttnjson"
+	_ "github.com/bullettime/lora-mqtt/parser/ttsjson"
+	"github.com/pkg/errors"
+)
+
+const (
+	name           = "test"
+	ttnMessage     = `{"app_id": "app", "dev_id": "node_1", "port": 1, "payload_raw": "B8hBALggAQ==", "metadata": {"time": "2018-03-13T19:21:22.827Z", "frequency": 868.3, "data_rate": "SF12BW125", "gateways": [{"gtw_id": "gw_1", "rssi": -84, "snr": 8}]}}`
+	dingnetMessage = `{"port": 1, "payload_raw": "B8hBALggAQ==", "metadata": {"time": "2018-03-13T19:21:22.827Z", "frequency": 868.3, "data_rate": "SF12BW125", "gateways": [{"gtw_id": "gw_1", "rssi": -84, "snr": 8}]}}`
+	ttsMessage     = `{"end_device_ids": {"device_id": "node_1"}, "uplink_message": {"f_port": 1, "frm_payload": "B8hBALggAQ==", "rx_metadata": [{"gateway_ids": {"gateway_id": "gw_1"}, "rssi": -84, "snr": 8}], "settings": {"data_rate": {"lora": {"bandwidth": 125000, "spreading_factor": 12}}, "frequency": "868300000"}}}`
+	chirpstackV3   = `{"applicationName": "app", "deviceName": "node_1", "devEUI": "AAAAAAAAAAE=", "rxInfo": [], "fPort": 1, "data": "B8hBALggAQ=="}`
+	chirpstackV4   = `{"deviceInfo": {"deviceName": "node_1"}, "rxInfo": [], "fPort": 1, "data": "B8hBALggAQ=="}`
+	heliumMessage  = `{"dev_eui": "0004A30B001C0530", "name": "node_1", "payload": "B8hBALggAQ==", "port": 1, "reported_at": 1615663282827, "hotspots": []}`
+	loriotMessage  = `{"cmd": "rx", "EUI": "0004A30B001C0530", "ts": 1615663282827, "port": 1, "data": "07c84100b82001"}`
+	thingpark      = `{"DevEUI_uplink": {"DevEUI": "0004A30B001C0530"}}`
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		topic    string
+		message  string
+		expected string
+	}{
+		{"app/devices/node_1/up", ttnMessage, "ttn"},
+		{"dingnet/devices/node_1/up", dingnetMessage, "dingnet"},
+		{"v3/app@ttn/devices/node_1/up", ttsMessage, "tts"},
+		{"application/1/device/0000000000000001/rx", chirpstackV3, "chirpstack"},
+		{"application/1/device/0000000000000001/event/up", chirpstackV4, "chirpstack"},
+		{"application/1/device/0000000000000001/event/up", "\x0a\x24", "chirpstackprotobuf"},
+		{"helium/node_1/rx", heliumMessage, "helium"},
+		{"loriot", loriotMessage, "loriot"},
+		{"thingpark/uplink", thingpark, "thingpark"},
+		{"v3/app@ttn/devices/node_1/up", `{"end_device_ids": {}}`, ""},
+		{"app/devices/node_1/up", "not json", ""},
+		{"sensors/temperature", `{"temperature": 21.5}`, ""},
+	}
+
+	for _, test := range tests {
+		detected, reason := Detect(test.topic, []byte(test.message))
+		if detected != test.expected {
+			t.Errorf("%s: detected %q instead of %q (%s)", test.topic, detected, test.expected, reason)
+		}
+		if len(reason) == 0 {
+			t.Errorf("%s: detection should have a reason", test.topic)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.(*autoParser).Parsers) != len(DefaultParsers) {
+		t.Error("all default parsers should be created")
+	}
+
+	if _, err := New("", nil); err == nil {
+		t.Error("empty metric name should give an error")
+	}
+
+	if _, err := New(name, []string{"unknown"}); err == nil {
+		t.Error("unknown parser should give an error")
+	}
+
+	if _, err := New(name, []string{Name}); err == nil {
+		t.Error("auto parser should not detect for itself")
+	}
+}
+
+// jsonConfig is a parser config in JSON, the keys match the field names of
+// the configs.
+type jsonConfig string
+
+func (c jsonConfig) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(c), v)
+}
+
+type decoderFunc func(uplink parser.Uplink) (parser.Decoded, error)
+
+func (f decoderFunc) Decode(uplink parser.Uplink) (parser.Decoded, error) {
+	return f(uplink)
+}
+
+func TestNewWithConfig(t *testing.T) {
+	if _, err := New(name, []string{"ttn", "jsonmap"}); err == nil {
+		t.Error("jsonmap without its config should give an error")
+	}
+
+	if _, err := parser.Create(Name, name, jsonConfig(`{"parsers": ["ttn", "jsonmap"], "payload": "$.data"}`)); err != nil {
+		t.Errorf("the config should be passed to the parsers: %v", err)
+	}
+}
+
+func TestAutoParser_SetFieldDecoder(t *testing.T) {
+	p, err := New(name, []string{"ttn", "tts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.(parser.FieldDecoderSetter).SetFieldDecoder(decoderFunc(func(uplink parser.Uplink) (parser.Decoded, error) {
+		return parser.Decoded{Fields: map[string]interface{}{"decoded": uplink.Device}}, nil
+	}))
+
+	metrics, err := p.Parse([]byte(ttnMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Fields()["decoded"] != "node_1" {
+		t.Error("the field decoder should be used by the detected parser")
+	}
+}
+
+func TestAutoParser_ParseTopic(t *testing.T) {
+	p, err := New(name, []string{"ttn", "tts", "dingnet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, err := p.(parser.TopicParser).ParseTopic("v3/app@ttn/devices/node_1/up", []byte(ttsMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Tags()["device_id"] != "node_1" {
+		t.Error("tts message should be parsed by the tts parser")
+	}
+
+	metrics, err = p.(parser.TopicParser).ParseTopic("dingnet/devices/node_2/up", []byte(dingnetMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Tags()["device_id"] != "node_2" {
+		t.Error("dingnet message should get the device id from the topic")
+	}
+
+	if _, err := p.(parser.TopicParser).ParseTopic("helium/node_1/rx", []byte(heliumMessage)); errors.Cause(err) != UnclassifiedError {
+		t.Errorf("message of a disabled parser should be unclassified: %v", err)
+	}
+
+	if _, err := p.Parse([]byte(`{"temperature": 21.5}`)); errors.Cause(err) != UnclassifiedError {
+		t.Errorf("unknown message should be unclassified: %v", err)
+	}
+
+	if p.(*autoParser).Unclassified() != 2 {
+		t.Errorf("wrong number of unclassified messages: %d", p.(*autoParser).Unclassified())
+	}
+}
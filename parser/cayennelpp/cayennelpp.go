@@ -0,0 +1,138 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package cayennelpp decodes Cayenne Low Power Payload (LPP) encoded
+// payloads, see https://developers.mydevices.com/cayenne/docs/lora/
+package cayennelpp
+
+import (
+	"fmt"
+
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/pkg/errors"
+)
+
+const (
+	DigitalInput  = 0
+	DigitalOutput = 1
+	AnalogInput   = 2
+	AnalogOutput  = 3
+	Illuminance   = 101
+	Presence      = 102
+	Temperature   = 103
+	Humidity      = 104
+	Accelerometer = 113
+	Barometer     = 115
+	Gyrometer     = 134
+	GPS           = 136
+)
+
+type value struct {
+	size    int
+	signed  bool
+	divisor float64
+}
+
+type dataType struct {
+	name   string
+	values []value
+	axes   []string
+}
+
+var dataTypes = map[byte]dataType{
+	DigitalInput:  {name: "digital_in", values: []value{{1, false, 1}}},
+	DigitalOutput: {name: "digital_out", values: []value{{1, false, 1}}},
+	AnalogInput:   {name: "analog_in", values: []value{{2, true, 100}}},
+	AnalogOutput:  {name: "analog_out", values: []value{{2, true, 100}}},
+	Illuminance:   {name: "luminosity", values: []value{{2, false, 1}}},
+	Presence:      {name: "presence", values: []value{{1, false, 1}}},
+	Temperature:   {name: "temperature", values: []value{{2, true, 10}}},
+	Humidity:      {name: "relative_humidity", values: []value{{1, false, 2}}},
+	Accelerometer: {name: "accelerometer", values: []value{{2, true, 1000}, {2, true, 1000}, {2, true, 1000}}, axes: []string{"x", "y", "z"}},
+	Barometer:     {name: "barometric_pressure", values: []value{{2, false, 10}}},
+	Gyrometer:     {name: "gyrometer", values: []value{{2, true, 100}, {2, true, 100}, {2, true, 100}}, axes: []string{"x", "y", "z"}},
+	GPS:           {name: "gps", values: []value{{3, true, 10000}, {3, true, 10000}, {3, true, 100}}, axes: []string{"latitude", "longitude", "altitude"}},
+}
+
+type decoder struct{}
+
+func New() parser.FieldDecoder {
+	return decoder{}
+}
+
+func (d decoder) Decode(uplink parser.Uplink) (parser.Decoded, error) {
+	fields, err := Decode(uplink.Payload.Bytes)
+	if err != nil {
+		return parser.Decoded{}, err
+	}
+
+	return parser.Decoded{Fields: fields}, nil
+}
+
+// Decode decodes a Cayenne LPP payload into fields named after the data type
+// and channel, e.g. temperature_3. Data types with multiple values get a
+// field per value, e.g. gps_1_latitude.
+func Decode(buf []byte) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+
+	for i := 0; i < len(buf); {
+		if len(buf)-i < 2 {
+			return nil, errors.Errorf("[CayenneLPP] incomplete header at byte %d", i)
+		}
+
+		channel := buf[i]
+		t, ok := dataTypes[buf[i+1]]
+		if !ok {
+			return nil, errors.Errorf("[CayenneLPP] unknown data type %d on channel %d", buf[i+1], channel)
+		}
+		i += 2
+
+		for j, v := range t.values {
+			if len(buf)-i < v.size {
+				return nil, errors.Errorf("[CayenneLPP] incomplete %s value on channel %d", t.name, channel)
+			}
+
+			key := fmt.Sprintf("%s_%d", t.name, channel)
+			if len(t.axes) > 0 {
+				key = fmt.Sprintf("%s_%s", key, t.axes[j])
+			}
+
+			fields[key] = v.decode(buf[i : i+v.size])
+			i += v.size
+		}
+	}
+
+	return fields, nil
+}
+
+func (v value) decode(b []byte) float64 {
+	var raw int64
+	for _, x := range b {
+		raw = raw<<8 | int64(x)
+	}
+
+	if v.signed && b[0]&0x80 != 0 {
+		raw -= 1 << uint(8*len(b))
+	}
+
+	return float64(raw) / v.divisor
+}
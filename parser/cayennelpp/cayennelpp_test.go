@@ -0,0 +1,117 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cayennelpp
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/bullettime/lora-mqtt/parser"
+)
+
+func decodeHex(t *testing.T, s string) map[string]interface{} {
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fields
+}
+
+func TestDecode(t *testing.T) {
+	fields := decodeHex(t, "03670110056700ff")
+
+	if fields["temperature_3"] != 27.2 || fields["temperature_5"] != 25.5 {
+		t.Errorf("wrong temperatures: %v", fields)
+	}
+}
+
+func TestDecode2(t *testing.T) {
+	fields := decodeHex(t, "067104d2fb2e0000")
+
+	if fields["accelerometer_6_x"] != 1.234 || fields["accelerometer_6_y"] != -1.234 || fields["accelerometer_6_z"] != 0.0 {
+		t.Errorf("wrong accelerometer values: %v", fields)
+	}
+}
+
+func TestDecode3(t *testing.T) {
+	fields := decodeHex(t, "018806765ff2960a0003e8")
+
+	if fields["gps_1_latitude"] != 42.3519 || fields["gps_1_longitude"] != -87.9094 || fields["gps_1_altitude"] != 10.0 {
+		t.Errorf("wrong gps values: %v", fields)
+	}
+}
+
+func TestDecode4(t *testing.T) {
+	fields := decodeHex(t, "0000010101ff0202fe0c03650190046601056872067327f0078600640000ff38")
+
+	expected := map[string]interface{}{
+		"digital_in_0":          1.0,
+		"digital_out_1":         255.0,
+		"analog_in_2":           -5.0,
+		"luminosity_3":          400.0,
+		"presence_4":            1.0,
+		"relative_humidity_5":   57.0,
+		"barometric_pressure_6": 1022.4,
+		"gyrometer_7_x":         1.0,
+		"gyrometer_7_y":         0.0,
+		"gyrometer_7_z":         -2.0,
+	}
+
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("wrong value for %s: %v != %v", k, fields[k], v)
+		}
+	}
+}
+
+func TestDecode5(t *testing.T) {
+	for _, s := range []string{"03", "0367", "036701", "03ff0110"} {
+		buf, _ := hex.DecodeString(s)
+		if _, err := Decode(buf); err == nil {
+			t.Errorf("invalid payload %s should give an error", s)
+		}
+	}
+}
+
+func TestDecoder_Decode(t *testing.T) {
+	buf, _ := hex.DecodeString("03670110")
+	uplink := parser.Uplink{
+		Port:    1,
+		Payload: parser.Payload{Size: len(buf), Bytes: buf},
+	}
+
+	decoded, err := New().Decode(uplink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Fields["temperature_3"] != 27.2 {
+		t.Errorf("wrong temperature: %v", decoded.Fields)
+	}
+}
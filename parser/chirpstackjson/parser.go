@@ -0,0 +1,375 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package chirpstackjson
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/pkg/errors"
+)
+
+const Name = "chirpstack"
+
+type chirpstackParser struct {
+	MetricName    string
+	DefaultTags   map[string]string
+	FieldDecoder  parser.FieldDecoder
+	LocationCodec parser.LocationCodec
+}
+
+// Uplink is the version independent representation of a ChirpStack uplink
+// event that the metrics are created from.
+type Uplink struct {
+	ApplicationName string
+	DeviceName      string
+	FPort           int
+	Data            parser.Payload
+	Object          map[string]interface{}
+	Time            time.Time
+	Frequency       int
+	SpreadingFactor int
+	Bandwidth       int
+	RxInfo          []RxInfo
+}
+
+type RxInfo struct {
+	GatewayID string
+	Time      time.Time
+	RSSI      int
+	SNR       float64
+	Location  *Location
+}
+
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+}
+
+type versionJson struct {
+	DeviceInfo json.RawMessage `json:"deviceInfo"`
+}
+
+type v3Json struct {
+	ApplicationID   string                 `json:"applicationID"`
+	ApplicationName string                 `json:"applicationName"`
+	DeviceName      string                 `json:"deviceName"`
+	DevEUI          string                 `json:"devEUI"`
+	RxInfo          []v3RxInfo             `json:"rxInfo"`
+	TxInfo          v3TxInfo               `json:"txInfo"`
+	FCnt            int                    `json:"fCnt"`
+	FPort           int                    `json:"fPort"`
+	Data            parser.Payload         `json:"data"`
+	Object          map[string]interface{} `json:"object,omitempty"`
+}
+
+type v3RxInfo struct {
+	GatewayID string    `json:"gatewayID"`
+	Time      time.Time `json:"time"`
+	RSSI      int       `json:"rssi"`
+	LoRaSNR   float64   `json:"loRaSNR"`
+	Location  *Location `json:"location,omitempty"`
+}
+
+type v3TxInfo struct {
+	Frequency          int                  `json:"frequency"`
+	LoRaModulationInfo v3LoRaModulationInfo `json:"loRaModulationInfo"`
+}
+
+type v3LoRaModulationInfo struct {
+	Bandwidth       int    `json:"bandwidth"`
+	SpreadingFactor int    `json:"spreadingFactor"`
+	CodeRate        string `json:"codeRate"`
+}
+
+type v4Json struct {
+	Time       time.Time              `json:"time"`
+	DeviceInfo v4DeviceInfo           `json:"deviceInfo"`
+	FCnt       int                    `json:"fCnt"`
+	FPort      int                    `json:"fPort"`
+	Data       parser.Payload         `json:"data"`
+	Object     map[string]interface{} `json:"object,omitempty"`
+	RxInfo     []v4RxInfo             `json:"rxInfo"`
+	TxInfo     v4TxInfo               `json:"txInfo"`
+}
+
+type v4DeviceInfo struct {
+	ApplicationID   string `json:"applicationId"`
+	ApplicationName string `json:"applicationName"`
+	DeviceName      string `json:"deviceName"`
+	DevEUI          string `json:"devEui"`
+}
+
+type v4RxInfo struct {
+	GatewayID string    `json:"gatewayId"`
+	GwTime    time.Time `json:"gwTime"`
+	RSSI      int       `json:"rssi"`
+	SNR       float64   `json:"snr"`
+	Location  *Location `json:"location,omitempty"`
+}
+
+type v4TxInfo struct {
+	Frequency  int          `json:"frequency"`
+	Modulation v4Modulation `json:"modulation"`
+}
+
+type v4Modulation struct {
+	LoRa v4LoRaModulationInfo `json:"lora"`
+}
+
+type v4LoRaModulationInfo struct {
+	Bandwidth       int    `json:"bandwidth"`
+	SpreadingFactor int    `json:"spreadingFactor"`
+	CodeRate        string `json:"codeRate"`
+}
+
+func init() {
+	parser.Register(Name, func(metricName string, _ parser.Config) (parser.Parser, error) {
+		return New(metricName)
+	})
+}
+
+func New(name string) (parser.Parser, error) {
+	if len(name) == 0 {
+		return nil, errors.New("[ChirpStackParser] name cannot be empty")
+	}
+
+	p := chirpstackParser{
+		MetricName: name,
+	}
+
+	return &p, nil
+}
+
+func (p *chirpstackParser) Parse(buf []byte) ([]model.Metric, error) {
+	var version versionJson
+
+	err := json.Unmarshal(buf, &version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[ChirpStackParser] error unmarshalling byte buffer: %s", string(buf))
+	}
+
+	var u Uplink
+
+	if len(version.DeviceInfo) > 0 {
+		u, err = parseV4(buf)
+	} else {
+		u, err = parseV3(buf)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "[ChirpStackParser] error unmarshalling byte buffer: %s", string(buf))
+	}
+
+	return Metrics(p.MetricName, p.DefaultTags, p.LocationCodec, p.FieldDecoder, u)
+}
+
+func parseV3(buf []byte) (Uplink, error) {
+	var message v3Json
+
+	if err := json.Unmarshal(buf, &message); err != nil {
+		return Uplink{}, err
+	}
+
+	u := Uplink{
+		ApplicationName: message.ApplicationName,
+		DeviceName:      message.DeviceName,
+		FPort:           message.FPort,
+		Data:            message.Data,
+		Object:          message.Object,
+		Frequency:       message.TxInfo.Frequency,
+		SpreadingFactor: message.TxInfo.LoRaModulationInfo.SpreadingFactor,
+		Bandwidth:       message.TxInfo.LoRaModulationInfo.Bandwidth,
+	}
+
+	for _, rx := range message.RxInfo {
+		u.RxInfo = append(u.RxInfo, RxInfo{
+			GatewayID: NormalizeEUI(rx.GatewayID),
+			Time:      rx.Time,
+			RSSI:      rx.RSSI,
+			SNR:       rx.LoRaSNR,
+			Location:  rx.Location,
+		})
+	}
+
+	if len(u.RxInfo) > 0 {
+		u.Time = u.RxInfo[0].Time
+	}
+
+	return u, nil
+}
+
+func parseV4(buf []byte) (Uplink, error) {
+	var message v4Json
+
+	if err := json.Unmarshal(buf, &message); err != nil {
+		return Uplink{}, err
+	}
+
+	u := Uplink{
+		ApplicationName: message.DeviceInfo.ApplicationName,
+		DeviceName:      message.DeviceInfo.DeviceName,
+		FPort:           message.FPort,
+		Data:            message.Data,
+		Object:          message.Object,
+		Time:            message.Time,
+		Frequency:       message.TxInfo.Frequency,
+		SpreadingFactor: message.TxInfo.Modulation.LoRa.SpreadingFactor,
+		Bandwidth:       message.TxInfo.Modulation.LoRa.Bandwidth,
+	}
+
+	for _, rx := range message.RxInfo {
+		u.RxInfo = append(u.RxInfo, RxInfo{
+			GatewayID: NormalizeEUI(rx.GatewayID),
+			Time:      rx.GwTime,
+			RSSI:      rx.RSSI,
+			SNR:       rx.SNR,
+			Location:  rx.Location,
+		})
+	}
+
+	return u, nil
+}
+
+// Metrics creates a metric per gateway that received the uplink. The
+// chirpstackprotobuf parser uses it too, so both produce the same output.
+func Metrics(metricName string, defaultTags map[string]string, codec parser.LocationCodec, decoder parser.FieldDecoder, u Uplink) ([]model.Metric, error) {
+	var metrics []model.Metric
+
+	if len(u.RxInfo) == 0 {
+		return nil, errors.New("[ChirpStackParser] wrong number of gateways (0)")
+	}
+
+	// ChirpStack v3 reports the bandwidth in kHz, v4 in Hz
+	bandwidth := u.Bandwidth
+	if bandwidth >= 1000 {
+		bandwidth /= 1000
+	}
+	dataRate := parser.DataRate(u.SpreadingFactor, bandwidth)
+
+	raw := parser.Uplink{
+		Application: u.ApplicationName,
+		Device:      u.DeviceName,
+		Port:        u.FPort,
+		Payload:     u.Data,
+	}
+
+	var decoded parser.Decoded
+	if metricName != parser.LocationData {
+		decoded = parser.DecodeFields(decoder, u.Object, raw)
+	}
+
+	tags := make(map[string]string, len(defaultTags))
+	for k, v := range defaultTags {
+		tags[k] = v
+	}
+
+	tags["device_id"] = u.DeviceName
+	tags["frequency"] = strconv.FormatFloat(float64(u.Frequency)/1000000, 'f', -1, 64)
+	tags["data_rate"] = dataRate
+	if metricName == parser.LocationData {
+		location, err := parser.CoverageLocation(codec, decoder, u.Object, raw)
+		if err == nil {
+			for k, v := range location.Tags() {
+				tags[k] = v
+			}
+		}
+	}
+
+	for _, g := range u.RxInfo {
+		gatewayTags := make(map[string]string, len(tags)+1)
+		for k, v := range tags {
+			gatewayTags[k] = v
+		}
+
+		fields := map[string]interface{}{
+			"size": u.Data.Size,
+		}
+
+		metric, err := model.NewMetric(metricName, gatewayTags, fields, u.Time)
+		if err != nil {
+			return nil, errors.Wrap(err, "[ChirpStackParser] error creating metric")
+		}
+
+		if metricName == parser.LocationData {
+			metric.AddField("rssi", g.RSSI)
+			metric.AddField("snr", g.SNR)
+
+			if g.Location != nil {
+				metric.AddTag("gateway_latitude", strconv.FormatFloat(g.Location.Latitude, 'f', 4, 64))
+				metric.AddTag("gateway_longitude", strconv.FormatFloat(g.Location.Longitude, 'f', 4, 64))
+			}
+		} else {
+			metric.AddTag("rssi", strconv.Itoa(g.RSSI))
+			metric.AddTag("snr", strconv.FormatFloat(g.SNR, 'f', -1, 64))
+			for k, v := range decoded.Fields {
+				metric.AddField(k, v)
+			}
+			for k, v := range decoded.Tags {
+				metric.AddTag(k, v)
+			}
+
+			if metricName == "adr" || metricName == "ddr" {
+				metric.AddField("dr", parser.DataRateIndex(dataRate))
+			}
+		}
+
+		metric.AddTag("gateway_id", g.GatewayID)
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+func (p *chirpstackParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+// NormalizeEUI returns an EUI as lowercase hex. The ChirpStack v3 protobuf
+// JSON marshaler encodes EUIs as base64 instead of hex.
+func NormalizeEUI(eui string) string {
+	if _, err := hex.DecodeString(eui); err == nil {
+		return strings.ToLower(eui)
+	}
+
+	if b, err := base64.StdEncoding.DecodeString(eui); err == nil {
+		return hex.EncodeToString(b)
+	}
+
+	return eui
+}
+
+func (p *chirpstackParser) SetFieldDecoder(decoder parser.FieldDecoder) {
+	p.FieldDecoder = decoder
+}
+
+func (p *chirpstackParser) SetLocationCodec(codec parser.LocationCodec) {
+	p.LocationCodec = codec
+}
@@ -0,0 +1,259 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package chirpstackjson
+
+import (
+	"testing"
+
+	"github.com/bullettime/lora-mqtt/parser"
+)
+
+const (
+	name          = "test"
+	jsonMessageV3 = `{
+  "applicationID": "1",
+  "applicationName": "lora_coverage_mapping",
+  "deviceName": "sodaq_one_gps_1",
+  "devEUI": "ADAXc3JTwdc=",
+  "rxInfo": [
+    {
+      "gatewayID": "AIAAAAAAuI0=",
+      "time": "2019-11-08T13:59:25.048445Z",
+      "rssi": -84,
+      "loRaSNR": 8,
+      "location": {
+        "latitude": 51.0,
+        "longitude": 4.7,
+        "altitude": 10
+      }
+    },
+    {
+      "gatewayID": "AIAAAAAAuI4=",
+      "rssi": -101,
+      "loRaSNR": -2.5
+    }
+  ],
+  "txInfo": {
+    "frequency": 868300000,
+    "modulation": "LORA",
+    "loRaModulationInfo": {
+      "bandwidth": 125,
+      "spreadingFactor": 12,
+      "codeRate": "4/5"
+    }
+  },
+  "adr": true,
+  "fCnt": 7,
+  "fPort": 1,
+  "data": "B8hBALggAQ==",
+  "object": {
+    "lat": 51.0017,
+    "lon": 4.7136,
+    "pwr": 1
+  }
+}`
+	jsonMessageV4 = `{
+  "deduplicationId": "3ac7e3c4-4401-4b8d-9386-a5c902f9202d",
+  "time": "2022-07-18T09:34:15.775023242+00:00",
+  "deviceInfo": {
+    "tenantId": "52f14cd4-c6f1-4fbd-8f87-4025e1d49242",
+    "tenantName": "ChirpStack",
+    "applicationId": "17c82e96-be03-4f38-aef3-f83d48582d97",
+    "applicationName": "lora_coverage_mapping",
+    "deviceName": "sodaq_one_gps_1",
+    "devEui": "003017737253c1d7"
+  },
+  "devAddr": "00189440",
+  "adr": true,
+  "dr": 0,
+  "fCnt": 7,
+  "fPort": 1,
+  "confirmed": false,
+  "data": "B8hBALggAQ==",
+  "object": {
+    "lat": 51.0017,
+    "lon": 4.7136,
+    "pwr": 1
+  },
+  "rxInfo": [
+    {
+      "gatewayId": "008000000000b88d",
+      "uplinkId": 4217106255,
+      "rssi": -84,
+      "snr": 8,
+      "channel": 1,
+      "location": {
+        "latitude": 51.0,
+        "longitude": 4.7
+      }
+    },
+    {
+      "gatewayId": "008000000000b88e",
+      "uplinkId": 4217106256,
+      "rssi": -101,
+      "snr": -2.5
+    }
+  ],
+  "txInfo": {
+    "frequency": 868300000,
+    "modulation": {
+      "lora": {
+        "bandwidth": 125000,
+        "spreadingFactor": 12,
+        "codeRate": "CR_4_5"
+      }
+    }
+  }
+}`
+	jsonMessageNoGateways = `{
+  "deviceInfo": {
+    "deviceName": "sodaq_one_gps_1",
+    "devEui": "003017737253c1d7"
+  },
+  "fPort": 1,
+  "data": "B8hBALggAQ==",
+  "txInfo": {
+    "frequency": 868300000
+  }
+}`
+)
+
+func TestNew(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+	if p.(*chirpstackParser).MetricName != name {
+		t.Error("metric name should be initialized")
+	}
+
+	p, err = New("")
+	if err == nil {
+		t.Error("empty metric name should give an error")
+	}
+}
+
+func TestChirpStackParser_Parse(t *testing.T) {
+	p, err := New(parser.LocationData)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, message := range []string{jsonMessageV3, jsonMessageV4} {
+		metrics, err := p.Parse([]byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(metrics) != 2 {
+			t.Fatal("should have 2 metrics")
+		}
+
+		metric := metrics[0]
+
+		if !(metric.HasTag("device_id") && metric.HasTag("frequency") && metric.HasTag("data_rate") &&
+			metric.HasTag("power") && metric.HasTag("latitude") && metric.HasTag("longitude") &&
+			metric.HasTag("gateway_id") && metric.HasTag("gateway_latitude") && metric.HasTag("gateway_longitude")) {
+			t.Error("missing one or more tags")
+		}
+
+		if !(metric.HasField("size") && metric.HasField("rssi") && metric.HasField("snr")) {
+			t.Error("missing one or more fields")
+		}
+
+		if metric.Tags()["frequency"] != "868.3" {
+			t.Errorf("wrong frequency: %s", metric.Tags()["frequency"])
+		}
+
+		if metric.Tags()["data_rate"] != "SF12BW125" {
+			t.Errorf("wrong data rate: %s", metric.Tags()["data_rate"])
+		}
+
+		if metric.Tags()["gateway_id"] != "008000000000b88d" {
+			t.Errorf("wrong gateway id: %s", metric.Tags()["gateway_id"])
+		}
+
+		if metrics[1].Tags()["gateway_id"] != "008000000000b88e" {
+			t.Error("second metric should belong to the second gateway")
+		}
+	}
+}
+
+func TestChirpStackParser_Parse2(t *testing.T) {
+	p, err := New("adr")
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, message := range []string{jsonMessageV3, jsonMessageV4} {
+		metrics, err := p.Parse([]byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		metric := metrics[0]
+
+		if !(metric.HasTag("device_id") && metric.HasTag("frequency") && metric.HasTag("data_rate") &&
+			metric.HasTag("rssi") && metric.HasTag("snr") && metric.HasTag("gateway_id")) {
+			t.Error("missing one or more tags")
+		}
+
+		if !(metric.HasField("size") && metric.HasField("lat") && metric.HasField("lon") &&
+			metric.HasField("pwr") && metric.HasField("dr")) {
+			t.Error("missing one or more fields")
+		}
+	}
+}
+
+func TestChirpStackParser_Parse3(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = p.Parse([]byte(jsonMessageNoGateways))
+	if err == nil {
+		t.Error("should not be able to parse a json message without rx info")
+	}
+}
+
+func TestChirpStackParser_SetDefaultTags(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	tags := map[string]string{
+		"test": "a",
+	}
+
+	p.SetDefaultTags(tags)
+
+	if v, ok := p.(*chirpstackParser).DefaultTags["test"]; !ok {
+		t.Error("default tags is missing key 'test'")
+	} else {
+		if v != "a" {
+			t.Error("default tags has wrong value for key 'test'")
+		}
+	}
+}
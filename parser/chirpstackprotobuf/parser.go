@@ -0,0 +1,148 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package chirpstackprotobuf parses ChirpStack v4 integration events that are
+// published with the protobuf marshaler instead of JSON. The metrics are
+// created by the chirpstack parser, so both produce the same output.
+//
+// The messages are decoded with the types generated from ChirpStack's
+// api/proto/integration.
+package chirpstackprotobuf
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/bullettime/lora-mqtt/parser/chirpstackjson"
+	"github.com/chirpstack/chirpstack/api/go/v4/integration"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const Name = "chirpstackprotobuf"
+
+type protobufParser struct {
+	MetricName    string
+	DefaultTags   map[string]string
+	FieldDecoder  parser.FieldDecoder
+	LocationCodec parser.LocationCodec
+}
+
+func init() {
+	parser.Register(Name, func(metricName string, _ parser.Config) (parser.Parser, error) {
+		return New(metricName)
+	})
+}
+
+func New(name string) (parser.Parser, error) {
+	if len(name) == 0 {
+		return nil, errors.New("[ChirpStackProtobufParser] name cannot be empty")
+	}
+
+	p := protobufParser{
+		MetricName: name,
+	}
+
+	return &p, nil
+}
+
+func (p *protobufParser) Parse(buf []byte) ([]model.Metric, error) {
+	var message integration.UplinkEvent
+
+	if err := proto.Unmarshal(buf, &message); err != nil {
+		return nil, errors.Wrap(err, "[ChirpStackProtobufParser] error unmarshalling byte buffer")
+	}
+
+	u := chirpstackjson.Uplink{
+		ApplicationName: message.GetDeviceInfo().GetApplicationName(),
+		DeviceName:      message.GetDeviceInfo().GetDeviceName(),
+		FPort:           int(message.GetFPort()),
+		Data: parser.Payload{
+			Size:  len(message.GetData()),
+			Bytes: message.GetData(),
+		},
+		Time:      toTime(message.GetTime()),
+		Frequency: int(message.GetTxInfo().GetFrequency()),
+	}
+
+	if object := message.GetObject(); object != nil {
+		u.Object = object.AsMap()
+	}
+
+	if lora := message.GetTxInfo().GetModulation().GetLora(); lora != nil {
+		u.SpreadingFactor = int(lora.GetSpreadingFactor())
+		u.Bandwidth = int(lora.GetBandwidth())
+	}
+
+	for _, rx := range message.GetRxInfo() {
+		g := chirpstackjson.RxInfo{
+			GatewayID: chirpstackjson.NormalizeEUI(rx.GetGatewayId()),
+			Time:      toTime(rx.GetGwTime()),
+			RSSI:      int(rx.GetRssi()),
+			SNR:       float32ToFloat64(rx.GetSnr()),
+		}
+
+		if location := rx.GetLocation(); location != nil {
+			g.Location = &chirpstackjson.Location{
+				Latitude:  location.GetLatitude(),
+				Longitude: location.GetLongitude(),
+				Altitude:  location.GetAltitude(),
+			}
+		}
+
+		u.RxInfo = append(u.RxInfo, g)
+	}
+
+	return chirpstackjson.Metrics(p.MetricName, p.DefaultTags, p.LocationCodec, p.FieldDecoder, u)
+}
+
+// toTime returns the zero time for a timestamp that isn't set, like the JSON
+// events without a time.
+func toTime(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return t.AsTime()
+}
+
+// float32ToFloat64 converts the protobuf float SNR without introducing the
+// float32 rounding noise, so 9.8 stays 9.8 like in the JSON events.
+func float32ToFloat64(v float32) float64 {
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'f', -1, 32), 64)
+	return f
+}
+
+func (p *protobufParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+func (p *protobufParser) SetFieldDecoder(decoder parser.FieldDecoder) {
+	p.FieldDecoder = decoder
+}
+
+func (p *protobufParser) SetLocationCodec(codec parser.LocationCodec) {
+	p.LocationCodec = codec
+}
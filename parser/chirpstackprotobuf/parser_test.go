@@ -0,0 +1,258 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package chirpstackprotobuf
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/bullettime/lora-mqtt/parser/chirpstackjson"
+	"github.com/chirpstack/chirpstack/api/go/v4/common"
+	"github.com/chirpstack/chirpstack/api/go/v4/gw"
+	"github.com/chirpstack/chirpstack/api/go/v4/integration"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	name          = "test"
+	jsonMessageV4 = `{
+  "deduplicationId": "3ac7e3c4-4401-4b8d-9386-a5c902f9202d",
+  "time": "2022-07-18T09:34:15.775023242+00:00",
+  "deviceInfo": {
+    "tenantId": "52f14cd4-c6f1-4fbd-8f87-4025e1d49242",
+    "tenantName": "ChirpStack",
+    "applicationId": "17c82e96-be03-4f38-aef3-f83d48582d97",
+    "applicationName": "lora_coverage_mapping",
+    "deviceName": "sodaq_one_gps_1",
+    "devEui": "003017737253c1d7"
+  },
+  "devAddr": "00189440",
+  "adr": true,
+  "dr": 0,
+  "fCnt": 7,
+  "fPort": 1,
+  "confirmed": false,
+  "data": "B8hBALggAQ==",
+  "object": {
+    "lat": 51.0017,
+    "lon": 4.7136,
+    "pwr": 1
+  },
+  "rxInfo": [
+    {
+      "gatewayId": "008000000000b88d",
+      "uplinkId": 4217106255,
+      "rssi": -84,
+      "snr": 8,
+      "channel": 1,
+      "location": {
+        "latitude": 51.0,
+        "longitude": 4.7
+      }
+    },
+    {
+      "gatewayId": "008000000000b88e",
+      "uplinkId": 4217106256,
+      "rssi": -101,
+      "snr": -2.5
+    }
+  ],
+  "txInfo": {
+    "frequency": 868300000,
+    "modulation": {
+      "lora": {
+        "bandwidth": 125000,
+        "spreadingFactor": 12,
+        "codeRate": "CR_4_5"
+      }
+    }
+  }
+}`
+)
+
+// protobufMessageV4 encodes the same uplink as jsonMessageV4, by reading the
+// JSON event with the ChirpStack types like the JSON marshaler would write it.
+func protobufMessageV4(t *testing.T) []byte {
+	var event integration.UplinkEvent
+
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal([]byte(jsonMessageV4), &event); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := proto.Marshal(&event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+	if p.(*protobufParser).MetricName != name {
+		t.Error("metric name should be set")
+	}
+
+	p, err = New("")
+	if err == nil {
+		t.Error("empty metric name should give an error")
+	}
+}
+
+func TestProtobufParser_Parse(t *testing.T) {
+	for _, metricName := range []string{parser.LocationData, "adr"} {
+		jsonParser, err := chirpstackjson.New(metricName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		protobufParser, err := New(metricName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := jsonParser.Parse([]byte(jsonMessageV4))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		metrics, err := protobufParser.Parse(protobufMessageV4(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(metrics) != len(expected) {
+			t.Fatalf("expected %d metrics, got %d", len(expected), len(metrics))
+		}
+
+		for i := range metrics {
+			if !reflect.DeepEqual(metrics[i].Tags(), expected[i].Tags()) {
+				t.Errorf("tags not matching json output: %v != %v", metrics[i].Tags(), expected[i].Tags())
+			}
+
+			if !reflect.DeepEqual(metrics[i].Fields(), expected[i].Fields()) {
+				t.Errorf("fields not matching json output: %v != %v", metrics[i].Fields(), expected[i].Fields())
+			}
+
+			if !metrics[i].Time().Equal(expected[i].Time()) {
+				t.Errorf("time not matching json output: %s != %s", metrics[i].Time(), expected[i].Time())
+			}
+		}
+	}
+}
+
+func TestProtobufParser_Parse2(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	message := protobufMessageV4(t)
+
+	_, err = p.Parse(message[:len(message)-3])
+	if err == nil {
+		t.Error("should not be able to parse a truncated protobuf message")
+	}
+}
+
+func TestProtobufParser_ParseValues(t *testing.T) {
+	object, err := structpb.NewStruct(map[string]interface{}{"temperature": 21.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := &integration.UplinkEvent{
+		Time: timestamppb.New(time.Date(2022, 7, 18, 9, 34, 15, 0, time.UTC)),
+		DeviceInfo: &integration.DeviceInfo{
+			ApplicationName: "sensors",
+			DeviceName:      "node_1",
+		},
+		FPort:  2,
+		Data:   []byte{0x01, 0x02},
+		Object: object,
+		RxInfo: []*gw.UplinkRxInfo{{
+			GatewayId: "008000000000B88D",
+			Rssi:      -112,
+			Snr:       -9.8,
+			Location:  &common.Location{Latitude: 51.02, Longitude: 4.47},
+		}},
+		TxInfo: &gw.UplinkTxInfo{
+			Frequency: 867100000,
+			Modulation: &gw.Modulation{Parameters: &gw.Modulation_Lora{Lora: &gw.LoraModulationInfo{
+				Bandwidth:       125000,
+				SpreadingFactor: 9,
+				CodeRate:        gw.CodeRate_CR_4_5,
+			}}},
+		},
+	}
+
+	buf, err := proto.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, err := p.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 {
+		t.Fatalf("should have 1 metric, not %d", len(metrics))
+	}
+
+	m := metrics[0]
+	expectedTags := map[string]string{
+		"device_id":  "node_1",
+		"gateway_id": "008000000000b88d",
+		"frequency":  "867.1",
+		"data_rate":  "SF9BW125",
+		"rssi":       "-112",
+		"snr":        "-9.8",
+	}
+	for k, v := range expectedTags {
+		if m.Tags()[k] != v {
+			t.Errorf("wrong tag %s: %s != %s", k, m.Tags()[k], v)
+		}
+	}
+
+	if m.Fields()["size"] != 2 || m.Fields()["temperature"] != 21.5 {
+		t.Errorf("wrong fields: %v", m.Fields())
+	}
+
+	if !m.Time().Equal(event.Time.AsTime()) {
+		t.Errorf("wrong time: %s", m.Time())
+	}
+}
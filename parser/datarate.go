@@ -0,0 +1,54 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parser
+
+import (
+	"fmt"
+)
+
+// DataRate formats a LoRa spreading factor and bandwidth (in kHz) the way
+// TTN reports data rates, e.g. "SF7BW125".
+func DataRate(spreadingFactor, bandwidth int) string {
+	return fmt.Sprintf("SF%dBW%d", spreadingFactor, bandwidth)
+}
+
+// DataRateIndex returns the EU868 data rate index for a data rate string.
+// Unknown data rates map to 0.
+func DataRateIndex(dataRate string) int {
+	switch dataRate {
+	case "SF7BW125":
+		return 5
+	case "SF8BW125":
+		return 4
+	case "SF9BW125":
+		return 3
+	case "SF10BW125":
+		return 2
+	case "SF11BW125":
+		return 1
+	case "SF12BW125":
+		return 0
+	}
+
+	return 0
+}
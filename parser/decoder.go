@@ -0,0 +1,189 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parser
+
+import (
+	"strconv"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-mqtt/model"
+)
+
+// Uplink is a raw application payload together with the device it was
+// received from, as passed to a FieldDecoder.
+type Uplink struct {
+	Application string
+	Device      string
+	Port        int
+	Payload     Payload
+}
+
+// Decoded holds the fields and tags decoded from a payload.
+type Decoded struct {
+	Fields map[string]interface{}
+	Tags   map[string]string
+}
+
+// FieldDecoder decodes raw application payloads into metric fields.
+type FieldDecoder interface {
+	Decode(uplink Uplink) (Decoded, error)
+}
+
+// FieldDecoderSetter is implemented by the parsers that fall back on a
+// FieldDecoder when the network server did not decode the payload.
+type FieldDecoderSetter interface {
+	SetFieldDecoder(decoder FieldDecoder)
+}
+
+// FieldDecoders tries its decoders in order and returns the result of the
+// first one that decodes any fields or tags. A decoder that fails doesn't
+// stop the next ones, the first error is only returned when none of them
+// decodes the payload.
+type FieldDecoders []FieldDecoder
+
+func (d FieldDecoders) Decode(uplink Uplink) (Decoded, error) {
+	var failed error
+
+	for _, decoder := range d {
+		decoded, err := decoder.Decode(uplink)
+		if err != nil {
+			if failed == nil {
+				failed = err
+			}
+			continue
+		}
+
+		if !decoded.Empty() {
+			return decoded, nil
+		}
+	}
+
+	return Decoded{}, failed
+}
+
+func (d Decoded) Empty() bool {
+	return len(d.Fields) == 0 && len(d.Tags) == 0
+}
+
+// DecodeFields returns the fields decoded by the network server, or decodes
+// the uplink with decoder when there are none. Nested objects are flattened
+// into dotted keys. A payload that can't be decoded is logged and gives no
+// fields, so the metrics of the gateways are still written.
+//
+// The parsers don't decode coverage uplinks, their payloads are read by the
+// location codec.
+func DecodeFields(decoder FieldDecoder, fields map[string]interface{}, uplink Uplink) Decoded {
+	if len(fields) > 0 || decoder == nil || uplink.Payload.Size == 0 {
+		return Decoded{Fields: model.Flatten(fields)}
+	}
+
+	decoded, err := decoder.Decode(uplink)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"application": uplink.Application,
+			"device":      uplink.Device,
+			"port":        uplink.Port,
+		}).Warn("[Parser] could not decode payload")
+		return Decoded{}
+	}
+
+	decoded.Fields = model.Flatten(decoded.Fields)
+	return decoded
+}
+
+// locationKeys are the names of the decoded fields (or tags) that are used
+// as the location of a coverage uplink.
+var locationKeys = map[string][]string{
+	"latitude":  {"latitude", "lat"},
+	"longitude": {"longitude", "lon", "lng"},
+	"altitude":  {"altitude", "alt"},
+	"hdop":      {"hdop"},
+	"power":     {"power", "pwr"},
+}
+
+// CoverageLocation returns the location of a coverage uplink, decoded by the
+// location codec (the default one when nil). Payloads the codec can't decode are decoded like
+// DecodeFields, and the latitude, longitude, altitude, hdop and power of the
+// decoded fields or tags are used.
+func CoverageLocation(codec LocationCodec, decoder FieldDecoder, fields map[string]interface{}, uplink Uplink) (Location, error) {
+	location, err := uplink.Payload.Location(codec)
+	if err == nil {
+		return location, nil
+	}
+
+	decoded := DecodeFields(decoder, fields, uplink)
+
+	lat, hasLat := decoded.float(locationKeys["latitude"])
+	lon, hasLon := decoded.float(locationKeys["longitude"])
+	if !hasLat || !hasLon {
+		return Location{}, err
+	}
+
+	location = Location{
+		Latitude:  lat,
+		Longitude: lon,
+		Precision: -1,
+	}
+	location.Altitude, location.HasAltitude = decoded.float(locationKeys["altitude"])
+	location.HDOP, location.HasHDOP = decoded.float(locationKeys["hdop"])
+
+	if power, ok := decoded.float(locationKeys["power"]); ok {
+		location.Power = int8(power)
+		location.HasPower = true
+	}
+
+	if err := location.Validate(); err != nil {
+		return Location{}, err
+	}
+
+	return location, nil
+}
+
+// float returns the first of the keys that is a decoded number.
+func (d Decoded) float(keys []string) (float64, bool) {
+	for _, k := range keys {
+		if v, ok := d.Fields[k]; ok {
+			value, t, err := model.FieldValue(v)
+			if err != nil {
+				continue
+			}
+
+			switch t {
+			case model.Int:
+				return float64(value.(int64)), true
+			case model.Uint:
+				return float64(value.(uint64)), true
+			case model.Float:
+				return value.(float64), true
+			}
+		}
+
+		if v, ok := d.Tags[k]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, true
+			}
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,68 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parser
+
+import (
+	"errors"
+	"testing"
+)
+
+type decoderFunc func(Uplink) (Decoded, error)
+
+func (f decoderFunc) Decode(uplink Uplink) (Decoded, error) {
+	return f(uplink)
+}
+
+func TestFieldDecoders_Decode(t *testing.T) {
+	failing := decoderFunc(func(Uplink) (Decoded, error) {
+		return Decoded{}, errors.New("invalid payload")
+	})
+	decoding := decoderFunc(func(Uplink) (Decoded, error) {
+		return Decoded{Fields: map[string]interface{}{"a": 1.0}}, nil
+	})
+
+	decoded, err := FieldDecoders{failing, decoding}.Decode(Uplink{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Fields["a"] != 1.0 {
+		t.Error("the next decoder should be tried after an error")
+	}
+
+	_, err = FieldDecoders{failing, failing}.Decode(Uplink{})
+	if err == nil {
+		t.Error("should give an error when no decoder can decode the payload")
+	}
+}
+
+func TestDecodeFields(t *testing.T) {
+	failing := decoderFunc(func(Uplink) (Decoded, error) {
+		return Decoded{}, errors.New("invalid payload")
+	})
+
+	decoded := DecodeFields(failing, nil, Uplink{Payload: Payload{Size: 1, Bytes: []byte{0x1}}})
+	if !decoded.Empty() {
+		t.Error("a payload that can't be decoded should give no fields")
+	}
+}
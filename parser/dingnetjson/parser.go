@@ -24,6 +24,7 @@ package dingnetjson
 
 import (
 	"encoding/json"
+	"regexp"
 	"strconv"
 	"time"
 
@@ -32,9 +33,13 @@ import (
 	"github.com/pkg/errors"
 )
 
+const Name = "dingnet"
+
 type dingnetParser struct {
-	MetricName  string
-	DefaultTags map[string]string
+	MetricName    string
+	DefaultTags   map[string]string
+	FieldDecoder  parser.FieldDecoder
+	LocationCodec parser.LocationCodec
 }
 
 type dingnetJson struct {
@@ -69,6 +74,12 @@ type gateway struct {
 	Altitude  float64   `json:"altitude,omitempty"`
 }
 
+func init() {
+	parser.Register(Name, func(metricName string, _ parser.Config) (parser.Parser, error) {
+		return New(metricName)
+	})
+}
+
 func New(name string) (parser.Parser, error) {
 	if len(name) == 0 {
 		return nil, errors.New("[DingNetParser] name cannot be empty")
@@ -81,7 +92,24 @@ func New(name string) (parser.Parser, error) {
 	return &p, nil
 }
 
+// DingNet only has the device id in the topic of the message, which can have
+// any number of levels before /devices/{device_id}/up
+var deviceTopic = regexp.MustCompile(`/devices/([^/]+)/up$`)
+
 func (p *dingnetParser) Parse(buf []byte) ([]model.Metric, error) {
+	return p.parse(buf, p.DefaultTags["device_id"])
+}
+
+func (p *dingnetParser) ParseTopic(t string, buf []byte) ([]model.Metric, error) {
+	submatch := deviceTopic.FindStringSubmatch(t)
+	if submatch == nil {
+		return p.Parse(buf)
+	}
+
+	return p.parse(buf, submatch[1])
+}
+
+func (p *dingnetParser) parse(buf []byte, deviceID string) ([]model.Metric, error) {
 	var metrics []model.Metric
 	var message dingnetJson
 
@@ -94,23 +122,34 @@ func (p *dingnetParser) Parse(buf []byte) ([]model.Metric, error) {
 		return nil, errors.New("[DingNetParser] wrong number of gateways (0)")
 	}
 
+	// DingNet doesn't decode payloads, so the fields can only come from a decoder
+	raw := parser.Uplink{
+		Device:  deviceID,
+		Port:    message.Port,
+		Payload: message.PayloadRaw,
+	}
+
+	var decoded parser.Decoded
+	if p.MetricName != parser.LocationData {
+		decoded = parser.DecodeFields(p.FieldDecoder, nil, raw)
+	}
+
 	tags := make(map[string]string, len(p.DefaultTags))
 	for k, v := range p.DefaultTags {
 		tags[k] = v
 	}
 
-	//tags["device_id"] = message.DevID
+	if len(deviceID) > 0 {
+		tags["device_id"] = deviceID
+	}
 	tags["frequency"] = strconv.FormatFloat(message.Metadata.Frequency, 'f', -1, 64)
 	tags["data_rate"] = message.Metadata.DataRate
 	if p.MetricName == parser.LocationData {
-		power, err := message.PayloadRaw.GetPower()
-		if err == nil {
-			tags["power"] = strconv.Itoa(int(power))
-		}
-		lat, lon, err := message.PayloadRaw.GetLocation()
+		location, err := parser.CoverageLocation(p.LocationCodec, p.FieldDecoder, nil, raw)
 		if err == nil {
-			tags["latitude"] = strconv.FormatFloat(lat, 'f', 4, 64)
-			tags["longitude"] = strconv.FormatFloat(lon, 'f', 4, 64)
+			for k, v := range location.Tags() {
+				tags[k] = v
+			}
 		}
 	}
 
@@ -118,11 +157,14 @@ func (p *dingnetParser) Parse(buf []byte) ([]model.Metric, error) {
 		"size": message.PayloadRaw.Size,
 	}
 
+	// every gateway changes its own copy of the metric of the uplink
+	uplink, err := model.NewMetric(p.MetricName, tags, fields, message.Metadata.Time)
+	if err != nil {
+		return nil, errors.Wrap(err, "[DingNetParser] error creating metric")
+	}
+
 	for _, g := range message.Metadata.Gateways {
-		metric, err := model.NewMetric(p.MetricName, tags, fields, message.Metadata.Time)
-		if err != nil {
-			return nil, errors.Wrap(err, "[DingNetParser] error creating metric")
-		}
+		metric := uplink.Copy()
 
 		if p.MetricName == parser.LocationData {
 			metric.AddField("rssi", g.RSSI)
@@ -130,29 +172,15 @@ func (p *dingnetParser) Parse(buf []byte) ([]model.Metric, error) {
 		} else {
 			metric.AddTag("rssi", strconv.Itoa(g.RSSI))
 			metric.AddTag("snr", strconv.FormatFloat(g.SNR, 'f', -1, 64))
-			//for k, v := range message.PayloadFields {
-			//	metric.AddField(k, v)
-			//}
+			for k, v := range decoded.Fields {
+				metric.AddField(k, v)
+			}
+			for k, v := range decoded.Tags {
+				metric.AddTag(k, v)
+			}
 
 			if p.MetricName == "adr" || p.MetricName == "ddr" {
-				dr := 0
-
-				switch message.Metadata.DataRate {
-				case "SF7BW125":
-					dr = 5
-				case "SF8BW125":
-					dr = 4
-				case "SF9BW125":
-					dr = 3
-				case "SF10BW125":
-					dr = 2
-				case "SF11BW125":
-					dr = 1
-				case "SF12BW125":
-					dr = 0
-				}
-
-				metric.AddField("dr", dr)
+				metric.AddField("dr", parser.DataRateIndex(message.Metadata.DataRate))
 			}
 		}
 
@@ -167,3 +195,11 @@ func (p *dingnetParser) Parse(buf []byte) ([]model.Metric, error) {
 func (p *dingnetParser) SetDefaultTags(tags map[string]string) {
 	p.DefaultTags = tags
 }
+
+func (p *dingnetParser) SetFieldDecoder(decoder parser.FieldDecoder) {
+	p.FieldDecoder = decoder
+}
+
+func (p *dingnetParser) SetLocationCodec(codec parser.LocationCodec) {
+	p.LocationCodec = codec
+}
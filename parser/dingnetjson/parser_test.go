@@ -23,11 +23,50 @@
 package dingnetjson
 
 import (
+	"strconv"
 	"testing"
+
+	"github.com/bullettime/lora-mqtt/parser"
 )
 
 const (
-	name = "test"
+	name        = "test"
+	jsonMessage = `{
+  "port": 1,
+  "counter": 7,
+  "payload_raw": "B8hBALggAQ==",
+  "metadata": {
+    "time": "2018-03-13T19:21:22.827Z",
+    "frequency": 868.3,
+    "modulation": "LORA",
+    "data_rate": "SF12BW125",
+    "coding_rate": "4/5",
+    "gateways": [
+      {
+        "gtw_id": "dingnet-gw-1",
+        "timestamp": 3239248428,
+        "channel": 1,
+        "rssi": -84,
+        "snr": 8,
+        "rf_chain": 0
+      }
+    ]
+  }
+}`
+	jsonMessageGateways = `{
+  "port": 1,
+  "payload_raw": "B8hBALggAQ==",
+  "metadata": {
+    "time": "2018-03-13T19:21:22.827Z",
+    "frequency": 868.3,
+    "data_rate": "SF12BW125",
+    "gateways": [
+      {"gtw_id": "gw-1", "rssi": -84, "snr": 8},
+      {"gtw_id": "gw-2", "rssi": -97, "snr": 1.5},
+      {"gtw_id": "gw-3", "rssi": -112, "snr": -7.25}
+    ]
+  }
+}`
 )
 
 func TestNew(t *testing.T) {
@@ -133,3 +172,102 @@ func TestTtnParser_SetDefaultTags(t *testing.T) {
 		}
 	}
 }
+
+func TestDingnetParser_ParseTopic(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	metrics, err := p.(parser.TopicParser).ParseTopic("dingnet/devices/sodaq_1/up", []byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 {
+		t.Fatal("should only have 1 metric")
+	}
+
+	if metrics[0].Tags()["device_id"] != "sodaq_1" {
+		t.Errorf("device id should come from the topic: %s", metrics[0].Tags()["device_id"])
+	}
+
+	metrics, err = p.(parser.TopicParser).ParseTopic("dingnet/devices/sodaq-one-2/up", []byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics[0].Tags()["device_id"] != "sodaq-one-2" {
+		t.Errorf("device ids with dashes should be supported: %s", metrics[0].Tags()["device_id"])
+	}
+
+	metrics, err = p.(parser.TopicParser).ParseTopic("customer/dingnet/devices/sodaq_4/up", []byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics[0].Tags()["device_id"] != "sodaq_4" {
+		t.Errorf("device id should be matched at the end of the topic: %s", metrics[0].Tags()["device_id"])
+	}
+
+	p.SetDefaultTags(map[string]string{"device_id": "sodaq_3"})
+	metrics, err = p.(parser.TopicParser).ParseTopic("dingnet/uplinks", []byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics[0].Tags()["device_id"] != "sodaq_3" {
+		t.Errorf("device id should fall back on the default tags: %s", metrics[0].Tags()["device_id"])
+	}
+}
+
+func TestDingnetParser_ParseGateways(t *testing.T) {
+	expected := []struct {
+		gateway string
+		rssi    int
+		snr     float64
+	}{
+		{"gw-1", -84, 8},
+		{"gw-2", -97, 1.5},
+		{"gw-3", -112, -7.25},
+	}
+
+	p, err := New(parser.LocationData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, err := p.Parse([]byte(jsonMessageGateways))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != len(expected) {
+		t.Fatalf("should have %d metrics", len(expected))
+	}
+
+	for i, e := range expected {
+		if metrics[i].Tags()["gateway_id"] != e.gateway {
+			t.Errorf("metric %d has gateway %s instead of %s", i, metrics[i].Tags()["gateway_id"], e.gateway)
+		}
+		if metrics[i].Fields()["rssi"] != e.rssi || metrics[i].Fields()["snr"] != e.snr {
+			t.Errorf("metric %d has the signal of another gateway: %v", i, metrics[i].Fields())
+		}
+	}
+
+	p, err = New("adr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, err = p.Parse([]byte(jsonMessageGateways))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, e := range expected {
+		if metrics[i].Tags()["gateway_id"] != e.gateway || metrics[i].Tags()["rssi"] != strconv.Itoa(e.rssi) {
+			t.Errorf("metric %d has the tags of another gateway: %v", i, metrics[i].Tags())
+		}
+	}
+}
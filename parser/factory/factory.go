@@ -1,4 +1,3 @@
-//go:generate stringer -type=TypeParser
 // The MIT License (MIT)
 //
 // Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
@@ -21,37 +20,50 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
+// Package factory links all the parsers into the binary, so they register
+// themselves, and creates them by name.
 package factory
 
 import (
+	"strconv"
+
 	"github.com/bullettime/lora-mqtt/parser"
-	"github.com/bullettime/lora-mqtt/parser/dingnetjson"
-	"github.com/bullettime/lora-mqtt/parser/ttnjson"
-	"github.com/pkg/errors"
+	_ "github.com/bullettime/lora-mqtt/parser/autodetect"
+	_ "github.com/bullettime/lora-mqtt/parser/chirpstackjson"
+	_ "github.com/bullettime/lora-mqtt/parser/chirpstackprotobuf"
+	_ "github.com/bullettime/lora-mqtt/parser/dingnetjson"
+	_ "github.com/bullettime/lora-mqtt/parser/heliumjson"
+	_ "github.com/bullettime/lora-mqtt/parser/jsonmap"
+	_ "github.com/bullettime/lora-mqtt/parser/loriotjson"
+	_ "github.com/bullettime/lora-mqtt/parser/thingparkjson"
+	_ "github.com/bullettime/lora-mqtt/parser/ttnjson"
+	_ "github.com/bullettime/lora-mqtt/parser/ttsjson"
 )
 
-type TypeParser int
+const DefaultType = "ttn"
 
-const (
-	TTN TypeParser = iota
-	DingNet
-)
+// legacyTypes are the names of the parsers in the order of the integer types
+// that were stored as parser.type in older configs.
+var legacyTypes = []string{
+	"ttn",
+	"dingnet",
+}
 
 func GetTypesList() []string {
-	var typesList []string
-	for i := 0; i < len(_TypeParser_index)-1; i++ {
-		typesList = append(typesList, TypeParser(i).String())
-	}
-	return typesList
+	return parser.Names()
 }
 
-func CreateParser(typeParser TypeParser, metricName string) (parser.Parser, error) {
-	switch typeParser {
-	case TTN:
-		return ttnjson.New(metricName)
-	case DingNet:
-		return dingnetjson.New(metricName)
-	default:
-		return nil, errors.New("[Parser Factory] incorrect parser type")
+func CreateParser(typeParser, metricName string, config parser.Config) (parser.Parser, error) {
+	return parser.Create(typeParser, metricName, config)
+}
+
+// MigrateType returns the name of the parser for an integer type of an older
+// config and reports whether typeParser was such a type.
+func MigrateType(typeParser string) (string, bool) {
+	i, err := strconv.Atoi(typeParser)
+	if err != nil || i < 0 || i >= len(legacyTypes) {
+		return typeParser, false
 	}
+
+	return legacyTypes[i], true
 }
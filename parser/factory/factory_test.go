@@ -0,0 +1,75 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package factory
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCreateParser(t *testing.T) {
+	for _, name := range GetTypesList() {
+		if name == "jsonmap" {
+			continue
+		}
+
+		p, err := CreateParser(name, "test", nil)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if p == nil {
+			t.Errorf("%s: parser should not be nil", name)
+		}
+	}
+
+	if _, err := CreateParser("unknown", "test", nil); err == nil {
+		t.Error("unknown parser type should give an error")
+	}
+}
+
+func TestMigrateType(t *testing.T) {
+	types := make(map[string]bool)
+	for _, name := range GetTypesList() {
+		types[name] = true
+	}
+
+	for i := range legacyTypes {
+		name, ok := MigrateType(strconv.Itoa(i))
+		if !ok {
+			t.Errorf("type %d should be migrated", i)
+		}
+		if !types[name] {
+			t.Errorf("type %d migrates to an unregistered parser: %s", i, name)
+		}
+	}
+
+	if name, ok := MigrateType("1"); !ok || name != "dingnet" {
+		t.Errorf("type 1 should be migrated to dingnet, not %s", name)
+	}
+
+	for _, typeParser := range []string{"ttn", "-1", "2", "42"} {
+		if name, ok := MigrateType(typeParser); ok || name != typeParser {
+			t.Errorf("%s should not be migrated", typeParser)
+		}
+	}
+}
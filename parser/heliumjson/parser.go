@@ -0,0 +1,229 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package heliumjson
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/pkg/errors"
+)
+
+const Name = "helium"
+
+type heliumParser struct {
+	MetricName    string
+	DefaultTags   map[string]string
+	FieldDecoder  parser.FieldDecoder
+	LocationCodec parser.LocationCodec
+}
+
+type heliumJson struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	AppEUI      string         `json:"app_eui"`
+	DevEUI      string         `json:"dev_eui"`
+	DevAddr     string         `json:"devaddr,omitempty"`
+	FCnt        int            `json:"fcnt"`
+	Port        int            `json:"port"`
+	Payload     parser.Payload `json:"payload"`
+	PayloadSize int            `json:"payload_size"`
+	ReportedAt  int64          `json:"reported_at"`
+	Decoded     *decoded       `json:"decoded,omitempty"`
+	Hotspots    []hotspot      `json:"hotspots"`
+}
+
+type decoded struct {
+	Payload map[string]interface{} `json:"payload"`
+	Status  string                 `json:"status"`
+}
+
+type hotspot struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	ReportedAt int64   `json:"reported_at"`
+	Status     string  `json:"status,omitempty"`
+	RSSI       float64 `json:"rssi"`
+	SNR        float64 `json:"snr"`
+	Spreading  string  `json:"spreading"`
+	Frequency  float64 `json:"frequency"`
+	Channel    int     `json:"channel"`
+	Latitude   float64 `json:"lat,omitempty"`
+	Longitude  float64 `json:"long,omitempty"`
+}
+
+func (m heliumJson) reportedAt() int64 {
+	if m.ReportedAt != 0 {
+		return m.ReportedAt
+	}
+
+	return m.Hotspots[0].ReportedAt
+}
+
+func init() {
+	parser.Register(Name, func(metricName string, _ parser.Config) (parser.Parser, error) {
+		return New(metricName)
+	})
+}
+
+func New(name string) (parser.Parser, error) {
+	if len(name) == 0 {
+		return nil, errors.New("[HeliumParser] name cannot be empty")
+	}
+
+	p := heliumParser{
+		MetricName: name,
+	}
+
+	return &p, nil
+}
+
+func (p *heliumParser) Parse(buf []byte) ([]model.Metric, error) {
+	var metrics []model.Metric
+	var message heliumJson
+
+	err := json.Unmarshal(buf, &message)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[HeliumParser] error unmarshalling byte buffer: %s", string(buf))
+	}
+
+	if len(message.Hotspots) == 0 {
+		return nil, errors.New("[HeliumParser] wrong number of hotspots (0)")
+	}
+
+	deviceID := message.Name
+	if len(deviceID) == 0 {
+		deviceID = message.DevEUI
+	}
+
+	var decodedPayload map[string]interface{}
+	if message.Decoded != nil && message.Decoded.Status == "success" {
+		decodedPayload = message.Decoded.Payload
+	}
+
+	raw := parser.Uplink{
+		Application: message.AppEUI,
+		Device:      deviceID,
+		Port:        message.Port,
+		Payload:     message.Payload,
+	}
+
+	var decoded parser.Decoded
+	if p.MetricName != parser.LocationData {
+		decoded = parser.DecodeFields(p.FieldDecoder, decodedPayload, raw)
+	}
+
+	// Helium reports times in milliseconds since the epoch, without one the
+	// time of the first hotspot is used, or else the zero time, like the
+	// other parsers do for messages without a time
+	var timestamp time.Time
+	if reportedAt := message.reportedAt(); reportedAt != 0 {
+		timestamp = time.Unix(0, reportedAt*int64(time.Millisecond))
+	}
+
+	tags := make(map[string]string, len(p.DefaultTags))
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+
+	tags["device_id"] = deviceID
+	if p.MetricName == parser.LocationData {
+		location, err := parser.CoverageLocation(p.LocationCodec, p.FieldDecoder, decodedPayload, raw)
+		if err == nil {
+			for k, v := range location.Tags() {
+				tags[k] = v
+			}
+		}
+	}
+
+	for _, h := range message.Hotspots {
+		hotspotTags := make(map[string]string, len(tags)+6)
+		for k, v := range tags {
+			hotspotTags[k] = v
+		}
+
+		fields := map[string]interface{}{
+			"size": message.Payload.Size,
+		}
+
+		metric, err := model.NewMetric(p.MetricName, hotspotTags, fields, timestamp)
+		if err != nil {
+			return nil, errors.Wrap(err, "[HeliumParser] error creating metric")
+		}
+
+		// every hotspot reports the frequency and data rate it received on
+		metric.AddTag("frequency", strconv.FormatFloat(h.Frequency, 'f', -1, 64))
+		metric.AddTag("data_rate", h.Spreading)
+
+		// Helium reports RSSI as a float, the TTN parser writes it as an integer
+		rssi := int(math.Round(h.RSSI))
+
+		if p.MetricName == parser.LocationData {
+			metric.AddField("rssi", rssi)
+			metric.AddField("snr", h.SNR)
+		} else {
+			metric.AddTag("rssi", strconv.Itoa(rssi))
+			metric.AddTag("snr", strconv.FormatFloat(h.SNR, 'f', -1, 64))
+			for k, v := range decoded.Fields {
+				metric.AddField(k, v)
+			}
+			for k, v := range decoded.Tags {
+				metric.AddTag(k, v)
+			}
+
+			if p.MetricName == "adr" || p.MetricName == "ddr" {
+				metric.AddField("dr", parser.DataRateIndex(h.Spreading))
+			}
+		}
+
+		if h.Latitude != 0 || h.Longitude != 0 {
+			metric.AddTag("gateway_latitude", strconv.FormatFloat(h.Latitude, 'f', 4, 64))
+			metric.AddTag("gateway_longitude", strconv.FormatFloat(h.Longitude, 'f', 4, 64))
+		}
+
+		metric.AddTag("gateway_id", h.ID)
+		if len(h.Name) > 0 {
+			metric.AddTag("gateway_name", h.Name)
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+func (p *heliumParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+func (p *heliumParser) SetFieldDecoder(decoder parser.FieldDecoder) {
+	p.FieldDecoder = decoder
+}
+
+func (p *heliumParser) SetLocationCodec(codec parser.LocationCodec) {
+	p.LocationCodec = codec
+}
@@ -0,0 +1,251 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package heliumjson
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/parser"
+)
+
+const (
+	name        = "test"
+	jsonMessage = `{
+  "app_eui": "70B3D57ED0000000",
+  "dc": {
+    "balance": 9999,
+    "nonce": 1
+  },
+  "dev_eui": "003017737253C1D7",
+  "devaddr": "12000048",
+  "fcnt": 7,
+  "hotspots": [
+    {
+      "channel": 5,
+      "frequency": 867.5,
+      "hold_time": 0,
+      "id": "112Z6dbH2SYm9MMB9APhbPkadmfNySiw2PWyxqJ3P2rUEYxjKxdX",
+      "lat": 51.029312,
+      "long": 4.481187,
+      "name": "jolly-red-dog",
+      "reported_at": 1615663282827,
+      "rssi": -108.4,
+      "snr": -1.5,
+      "spreading": "SF12BW125",
+      "status": "success"
+    },
+    {
+      "channel": 5,
+      "frequency": 867.5,
+      "id": "11bVrjuLZGxTYeH9A5EJaVbgQ5QrcbMZFTkbsT4oJkUsL6ePZmk",
+      "name": "tiny-blue-cat",
+      "reported_at": 1615663282901,
+      "rssi": -97,
+      "snr": 7.25,
+      "spreading": "SF12BW125",
+      "status": "success"
+    }
+  ],
+  "id": "f2b5cf42-7b1a-4b7a-8f8a-64bcbd1e0a0c",
+  "metadata": {
+    "labels": [],
+    "organization_id": "b06b1ac6-cb1b-4a20-a6a4-3e5b4d1f6f6c"
+  },
+  "name": "sodaq_one_gps_1",
+  "payload": "B8hBALggAQ==",
+  "payload_size": 7,
+  "port": 1,
+  "reported_at": 1615663282827,
+  "decoded": {
+    "payload": {
+      "lat": 51.0017,
+      "lon": 4.7136,
+      "pwr": 1
+    },
+    "status": "success"
+  }
+}`
+	jsonMessageNoHotspots = `{
+  "dev_eui": "003017737253C1D7",
+  "name": "sodaq_one_gps_1",
+  "payload": "B8hBALggAQ==",
+  "port": 1,
+  "reported_at": 1615663282827,
+  "hotspots": []
+}`
+)
+
+func TestNew(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+	if p.(*heliumParser).MetricName != name {
+		t.Error("metric name should be initialized")
+	}
+
+	p, err = New("")
+	if err == nil {
+		t.Error("empty metric name should give an error")
+	}
+}
+
+func TestHeliumParser_Parse(t *testing.T) {
+	p, err := New(parser.LocationData)
+	if err != nil {
+		t.Error(err)
+	}
+
+	metrics, err := p.Parse([]byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 2 {
+		t.Fatal("should have 2 metrics")
+	}
+
+	metric := metrics[0]
+
+	if !(metric.HasTag("device_id") && metric.HasTag("frequency") && metric.HasTag("data_rate") &&
+		metric.HasTag("power") && metric.HasTag("latitude") && metric.HasTag("longitude") &&
+		metric.HasTag("gateway_id") && metric.HasTag("gateway_latitude") && metric.HasTag("gateway_longitude")) {
+		t.Error("missing one or more tags")
+	}
+
+	if !(metric.HasField("size") && metric.HasField("rssi") && metric.HasField("snr")) {
+		t.Error("missing one or more fields")
+	}
+
+	if metric.Tags()["device_id"] != "sodaq_one_gps_1" {
+		t.Errorf("wrong device id: %s", metric.Tags()["device_id"])
+	}
+
+	if metric.Tags()["frequency"] != "867.5" || metric.Tags()["data_rate"] != "SF12BW125" {
+		t.Errorf("wrong frequency or data rate: %s %s", metric.Tags()["frequency"], metric.Tags()["data_rate"])
+	}
+
+	if metric.Tags()["gateway_latitude"] != "51.0293" || metric.Tags()["gateway_longitude"] != "4.4812" {
+		t.Error("wrong hotspot location")
+	}
+
+	if metric.Fields()["rssi"] != -108 {
+		t.Errorf("wrong rssi: %v", metric.Fields()["rssi"])
+	}
+
+	if !metric.Time().Equal(time.Unix(1615663282, 827000000)) {
+		t.Errorf("wrong time: %s", metric.Time())
+	}
+
+	if metrics[1].Tags()["gateway_name"] != "tiny-blue-cat" || metrics[1].HasTag("gateway_latitude") {
+		t.Error("second metric should belong to the second hotspot")
+	}
+}
+
+func TestHeliumParser_Parse2(t *testing.T) {
+	p, err := New("adr")
+	if err != nil {
+		t.Error(err)
+	}
+
+	metrics, err := p.Parse([]byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metric := metrics[0]
+
+	if !(metric.HasTag("device_id") && metric.HasTag("frequency") && metric.HasTag("data_rate") &&
+		metric.HasTag("rssi") && metric.HasTag("snr") && metric.HasTag("gateway_id")) {
+		t.Error("missing one or more tags")
+	}
+
+	if !(metric.HasField("size") && metric.HasField("lat") && metric.HasField("lon") &&
+		metric.HasField("pwr") && metric.HasField("dr")) {
+		t.Error("missing one or more fields")
+	}
+
+	if metric.Fields()["dr"] != 0 {
+		t.Errorf("wrong dr: %v", metric.Fields()["dr"])
+	}
+}
+
+func TestHeliumParser_Parse3(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = p.Parse([]byte(jsonMessageNoHotspots))
+	if err == nil {
+		t.Error("should not be able to parse a json message without hotspots")
+	}
+}
+
+func TestHeliumParser_ParseTime(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		message string
+		time    time.Time
+	}{
+		{`{"name": "node_1", "payload": "AQ==", "reported_at": 0, "hotspots": [{"id": "gw", "reported_at": 1615663282901}]}`, time.Unix(1615663282, 901000000)},
+		{`{"name": "node_1", "payload": "AQ==", "hotspots": [{"id": "gw"}]}`, time.Time{}},
+	}
+
+	for _, test := range tests {
+		metrics, err := p.Parse([]byte(test.message))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !metrics[0].Time().Equal(test.time) {
+			t.Errorf("wrong time: %s != %s", metrics[0].Time(), test.time)
+		}
+	}
+}
+
+func TestHeliumParser_SetDefaultTags(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	tags := map[string]string{
+		"test": "a",
+	}
+
+	p.SetDefaultTags(tags)
+
+	if v, ok := p.(*heliumParser).DefaultTags["test"]; !ok {
+		t.Error("default tags is missing key 'test'")
+	} else {
+		if v != "a" {
+			t.Error("default tags has wrong value for key 'test'")
+		}
+	}
+}
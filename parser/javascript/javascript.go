@@ -0,0 +1,243 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package javascript runs TTN style payload formatters, either a
+// Decoder(bytes, port) function or a decodeUplink(input) function, in an
+// embedded JavaScript runtime.
+package javascript
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/dop251/goja"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultTimeout = 100 * time.Millisecond
+
+	maxCallStackSize = 1024
+)
+
+// Script is a payload formatter for the devices in Devices, or for all the
+// devices of Application when Devices is empty. The source is read from File
+// when it isn't given inline.
+type Script struct {
+	Application string   `yaml:"application,omitempty" mapstructure:"application"`
+	Devices     []string `yaml:"devices,omitempty" mapstructure:"devices"`
+	File        string   `yaml:"file,omitempty" mapstructure:"file"`
+	Source      string   `yaml:"source,omitempty" mapstructure:"source"`
+}
+
+// Options limit the time a script can run for a single payload, the depth of
+// its call stack is limited too. The runtime can't limit the memory of a
+// script, it's only bounded by what it can allocate before the timeout.
+type Options struct {
+	Timeout time.Duration `yaml:"timeout,omitempty" mapstructure:"timeout"`
+}
+
+type decoder struct {
+	scripts []*script
+	options Options
+}
+
+type script struct {
+	Script
+	name    string
+	program *goja.Program
+}
+
+func New(scripts []Script, options Options) (parser.FieldDecoder, error) {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+
+	d := decoder{options: options}
+
+	for i, s := range scripts {
+		if len(s.Application) == 0 && len(s.Devices) == 0 {
+			return nil, errors.Errorf("[JavaScript] script %d has no application or devices", i)
+		}
+
+		name := s.File
+		source := s.Source
+		if len(source) == 0 {
+			if len(s.File) == 0 {
+				return nil, errors.Errorf("[JavaScript] script %d has no source or file", i)
+			}
+
+			buf, err := ioutil.ReadFile(s.File)
+			if err != nil {
+				return nil, errors.Wrapf(err, "[JavaScript] error reading script %d", i)
+			}
+			source = string(buf)
+		} else if len(name) == 0 {
+			name = s.Application
+			if len(s.Devices) > 0 {
+				name = strings.Join(s.Devices, ",")
+			}
+		}
+
+		program, err := goja.Compile(name, source, false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[JavaScript] error compiling script %d", i)
+		}
+
+		d.scripts = append(d.scripts, &script{Script: s, name: name, program: program})
+	}
+
+	return &d, nil
+}
+
+// Decode runs the script of the device, or else the script of its
+// application, on the payload. Uplinks without a script aren't decoded.
+func (d *decoder) Decode(uplink parser.Uplink) (parser.Decoded, error) {
+	s := d.find(uplink)
+	if s == nil {
+		return parser.Decoded{}, nil
+	}
+
+	fields, err := s.run(uplink, d.options)
+	if err != nil {
+		return parser.Decoded{}, errors.Wrapf(err, "[JavaScript] script %s", s.name)
+	}
+
+	return parser.Decoded{Fields: fields}, nil
+}
+
+func (d *decoder) find(uplink parser.Uplink) *script {
+	for _, s := range d.scripts {
+		if len(s.Application) > 0 && s.Application != uplink.Application {
+			continue
+		}
+
+		for _, device := range s.Devices {
+			if device == uplink.Device {
+				return s
+			}
+		}
+	}
+
+	for _, s := range d.scripts {
+		if len(s.Devices) == 0 && s.Application == uplink.Application {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// run executes the script in a new runtime, so nothing is shared between
+// payloads, and interrupts it when it runs too long.
+func (s *script) run(uplink parser.Uplink, options Options) (map[string]interface{}, error) {
+	vm := goja.New()
+	vm.SetMaxCallStackSize(maxCallStackSize)
+
+	done := make(chan struct{})
+	defer close(done)
+	go watch(vm, options, done)
+
+	if _, err := vm.RunProgram(s.program); err != nil {
+		return nil, err
+	}
+
+	bytes := make([]interface{}, len(uplink.Payload.Bytes))
+	for i, b := range uplink.Payload.Bytes {
+		bytes[i] = int64(b)
+	}
+
+	if decodeUplink, ok := goja.AssertFunction(vm.Get("decodeUplink")); ok {
+		input := vm.NewObject()
+		input.Set("bytes", vm.NewArray(bytes...))
+		input.Set("fPort", uplink.Port)
+
+		result, err := decodeUplink(goja.Undefined(), input)
+		if err != nil {
+			return nil, err
+		}
+
+		return uplinkResult(vm, result)
+	}
+
+	if decoder, ok := goja.AssertFunction(vm.Get("Decoder")); ok {
+		result, err := decoder(goja.Undefined(), vm.NewArray(bytes...), vm.ToValue(uplink.Port))
+		if err != nil {
+			return nil, err
+		}
+
+		return export(result)
+	}
+
+	return nil, errors.New("no Decoder or decodeUplink function")
+}
+
+func uplinkResult(vm *goja.Runtime, result goja.Value) (map[string]interface{}, error) {
+	if isEmpty(result) {
+		return nil, nil
+	}
+
+	object := result.ToObject(vm)
+
+	if errs := object.Get("errors"); !isEmpty(errs) {
+		var messages []string
+		if err := vm.ExportTo(errs, &messages); err != nil {
+			return nil, errors.Wrap(err, "invalid errors")
+		}
+
+		if len(messages) > 0 {
+			return nil, errors.New(strings.Join(messages, ", "))
+		}
+	}
+
+	return export(object.Get("data"))
+}
+
+func export(value goja.Value) (map[string]interface{}, error) {
+	if isEmpty(value) {
+		return nil, nil
+	}
+
+	fields, ok := value.Export().(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("decoder returned %s instead of an object", value.String())
+	}
+
+	return fields, nil
+}
+
+func isEmpty(value goja.Value) bool {
+	return value == nil || goja.IsUndefined(value) || goja.IsNull(value)
+}
+
+func watch(vm *goja.Runtime, options Options, done chan struct{}) {
+	timeout := time.NewTimer(options.Timeout)
+	defer timeout.Stop()
+
+	select {
+	case <-done:
+	case <-timeout.C:
+		vm.Interrupt(errors.Errorf("timeout after %s", options.Timeout))
+	}
+}
@@ -0,0 +1,148 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package javascript
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/parser"
+)
+
+const ttnDecoder = `
+function Decoder(bytes, port) {
+  var decoded = {};
+  if (port === 1) {
+    decoded.temperature = ((bytes[0] << 8) | bytes[1]) / 100;
+    decoded.humidity = bytes[2];
+  }
+  return decoded;
+}`
+
+const ttsDecoder = `
+function decodeUplink(input) {
+  if (input.bytes.length < 2) {
+    return { errors: ["payload too short"] };
+  }
+  return {
+    data: { battery: input.bytes[0] / 10, port: input.fPort, on: input.bytes[1] === 1 },
+    warnings: []
+  };
+}`
+
+func uplink(application, device string, port int, buf []byte) parser.Uplink {
+	return parser.Uplink{
+		Application: application,
+		Device:      device,
+		Port:        port,
+		Payload:     parser.Payload{Size: len(buf), Bytes: buf},
+	}
+}
+
+func TestDecoder_Decode(t *testing.T) {
+	d, err := New([]Script{
+		{Application: "app", Source: ttnDecoder},
+		{Devices: []string{"node_2"}, Source: ttsDecoder},
+	}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := d.Decode(uplink("app", "node_1", 1, []byte{0x09, 0x29, 0x37}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Fields["temperature"] != 23.45 {
+		t.Errorf("wrong temperature: %v (%T)", decoded.Fields["temperature"], decoded.Fields["temperature"])
+	}
+
+	if decoded.Fields["humidity"] != int64(55) {
+		t.Errorf("wrong humidity: %v (%T)", decoded.Fields["humidity"], decoded.Fields["humidity"])
+	}
+
+	decoded, err = d.Decode(uplink("app", "node_2", 2, []byte{0x25, 0x01}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"battery": 3.7,
+		"port":    int64(2),
+		"on":      true,
+	}
+
+	for k, v := range expected {
+		if decoded.Fields[k] != v {
+			t.Errorf("wrong value for %s: %v (%T) != %v", k, decoded.Fields[k], decoded.Fields[k], v)
+		}
+	}
+
+	if _, err := d.Decode(uplink("app", "node_2", 2, []byte{0x25})); err == nil {
+		t.Error("errors returned by decodeUplink should give an error")
+	}
+
+	decoded, err = d.Decode(uplink("other", "node_1", 1, []byte{0x09, 0x29, 0x37}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !decoded.Empty() {
+		t.Error("devices without a script should not be decoded")
+	}
+}
+
+func TestDecoder_Limits(t *testing.T) {
+	d, err := New([]Script{
+		{Devices: []string{"loop"}, Source: "function Decoder(bytes, port) { while (true) {} }"},
+		{Devices: []string{"recursion"}, Source: "function Decoder(bytes, port) { return Decoder(bytes, port); }"},
+	}, Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, device := range []string{"loop", "recursion"} {
+		start := time.Now()
+		if _, err := d.Decode(uplink("", device, 1, []byte{0x01})); err == nil {
+			t.Errorf("%s should give an error", device)
+		}
+		if device != "loop" && time.Since(start) >= time.Second {
+			t.Errorf("%s should be stopped before the timeout", device)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	invalid := []Script{
+		{Source: ttnDecoder},
+		{Application: "app"},
+		{Application: "app", Source: "function Decoder(bytes, port) {"},
+		{Application: "app", File: "does-not-exist.js"},
+	}
+
+	for _, s := range invalid {
+		if _, err := New([]Script{s}, Options{}); err == nil {
+			t.Errorf("invalid script should give an error: %+v", s)
+		}
+	}
+}
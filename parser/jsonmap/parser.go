@@ -0,0 +1,547 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jsonmap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/pkg/errors"
+)
+
+const (
+	Name = "jsonmap"
+
+	EncodingBase64 = "base64"
+	EncodingHex    = "hex"
+
+	TimeRFC3339 = "rfc3339"
+	TimeUnix    = "unix"
+	TimeUnixMs  = "unix_ms"
+)
+
+// Config maps the values of a JSON message on the metrics. Every value is a
+// JSONPath expression, the ones of Gateway are relative to the elements of
+// the Gateways array. Only Payload is required.
+//
+// Numbers are float64 fields, so a field has the same type in every message,
+// except rssi, size and f_cnt, which are integers like the other parsers
+// write them. Types declares the type (int, uint, float, bool or string) of
+// the fields (including rssi and snr) that need another type.
+type Config struct {
+	DeviceID        string            `yaml:"device_id,omitempty" mapstructure:"device_id"`
+	Application     string            `yaml:"application,omitempty" mapstructure:"application"`
+	Port            string            `yaml:"port,omitempty" mapstructure:"port"`
+	Time            string            `yaml:"time,omitempty" mapstructure:"time"`
+	TimeFormat      string            `yaml:"time_format,omitempty" mapstructure:"time_format"`
+	Payload         string            `yaml:"payload" mapstructure:"payload"`
+	Encoding        string            `yaml:"encoding,omitempty" mapstructure:"encoding"`
+	DecodedPayload  string            `yaml:"decoded_payload,omitempty" mapstructure:"decoded_payload"`
+	Frequency       string            `yaml:"frequency,omitempty" mapstructure:"frequency"`
+	DataRate        string            `yaml:"data_rate,omitempty" mapstructure:"data_rate"`
+	SpreadingFactor string            `yaml:"spreading_factor,omitempty" mapstructure:"spreading_factor"`
+	Bandwidth       string            `yaml:"bandwidth,omitempty" mapstructure:"bandwidth"`
+	Gateways        string            `yaml:"gateways,omitempty" mapstructure:"gateways"`
+	Gateway         GatewayConfig     `yaml:"gateway,omitempty" mapstructure:"gateway"`
+	Tags            map[string]string `yaml:"tags,omitempty" mapstructure:"tags"`
+	Fields          map[string]string `yaml:"fields,omitempty" mapstructure:"fields"`
+	Types           map[string]string `yaml:"types,omitempty" mapstructure:"types"`
+}
+
+// defaultTypes are the types of the fields that the other parsers write too,
+// a field must have the same type in all of them.
+var defaultTypes = map[string]model.FieldType{
+	"rssi":  model.Int,
+	"size":  model.Int,
+	"f_cnt": model.Int,
+}
+
+type GatewayConfig struct {
+	ID     string            `yaml:"id,omitempty" mapstructure:"id"`
+	RSSI   string            `yaml:"rssi,omitempty" mapstructure:"rssi"`
+	SNR    string            `yaml:"snr,omitempty" mapstructure:"snr"`
+	Tags   map[string]string `yaml:"tags,omitempty" mapstructure:"tags"`
+	Fields map[string]string `yaml:"fields,omitempty" mapstructure:"fields"`
+}
+
+type jsonmapParser struct {
+	MetricName    string
+	DefaultTags   map[string]string
+	FieldDecoder  parser.FieldDecoder
+	LocationCodec parser.LocationCodec
+	Config        Config
+
+	paths        map[string]Path
+	gatewayPaths map[string]Path
+	tagPaths     map[string]Path
+	fieldPaths   map[string]Path
+	gwTagPaths   map[string]Path
+	gwFieldPaths map[string]Path
+	types        map[string]model.FieldType
+}
+
+func init() {
+	parser.Register(Name, func(metricName string, config parser.Config) (parser.Parser, error) {
+		var c Config
+		if err := config.Decode(&c); err != nil {
+			return nil, errors.Wrap(err, "[JSONMapParser] invalid config")
+		}
+
+		return New(metricName, c)
+	})
+}
+
+func New(name string, config Config) (parser.Parser, error) {
+	if len(name) == 0 {
+		return nil, errors.New("[JSONMapParser] name cannot be empty")
+	}
+
+	if len(config.Payload) == 0 {
+		return nil, errors.New("[JSONMapParser] payload path cannot be empty")
+	}
+
+	switch config.Encoding {
+	case "":
+		config.Encoding = EncodingBase64
+	case EncodingBase64, EncodingHex:
+	default:
+		return nil, errors.Errorf("[JSONMapParser] unknown payload encoding %s", config.Encoding)
+	}
+
+	p := jsonmapParser{
+		MetricName: name,
+		Config:     config,
+	}
+
+	var err error
+	if p.paths, err = compile(map[string]string{
+		"device_id":        config.DeviceID,
+		"application":      config.Application,
+		"port":             config.Port,
+		"time":             config.Time,
+		"payload":          config.Payload,
+		"decoded_payload":  config.DecodedPayload,
+		"frequency":        config.Frequency,
+		"data_rate":        config.DataRate,
+		"spreading_factor": config.SpreadingFactor,
+		"bandwidth":        config.Bandwidth,
+		"gateways":         config.Gateways,
+	}); err != nil {
+		return nil, err
+	}
+
+	if p.gatewayPaths, err = compile(map[string]string{
+		"id":   config.Gateway.ID,
+		"rssi": config.Gateway.RSSI,
+		"snr":  config.Gateway.SNR,
+	}); err != nil {
+		return nil, err
+	}
+
+	if p.tagPaths, err = compile(config.Tags); err != nil {
+		return nil, err
+	}
+
+	if p.fieldPaths, err = compile(config.Fields); err != nil {
+		return nil, err
+	}
+
+	if p.gwTagPaths, err = compile(config.Gateway.Tags); err != nil {
+		return nil, err
+	}
+
+	if p.gwFieldPaths, err = compile(config.Gateway.Fields); err != nil {
+		return nil, err
+	}
+
+	p.types = make(map[string]model.FieldType, len(defaultTypes)+len(config.Types))
+	for k, t := range defaultTypes {
+		p.types[k] = t
+	}
+	for k, name := range config.Types {
+		t, err := model.ParseFieldType(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[JSONMapParser] type of %s", k)
+		}
+		p.types[k] = t
+	}
+
+	return &p, nil
+}
+
+func compile(exprs map[string]string) (map[string]Path, error) {
+	paths := make(map[string]Path, len(exprs))
+
+	for name, expr := range exprs {
+		if len(expr) == 0 {
+			continue
+		}
+
+		path, err := Compile(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[JSONMapParser] %s", name)
+		}
+		paths[name] = path
+	}
+
+	return paths, nil
+}
+
+func (p *jsonmapParser) Parse(buf []byte) ([]model.Metric, error) {
+	var metrics []model.Metric
+	var message interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	if err := decoder.Decode(&message); err != nil {
+		return nil, errors.Wrapf(err, "[JSONMapParser] error unmarshalling byte buffer: %s", string(buf))
+	}
+
+	payload, err := p.payload(message)
+	if err != nil {
+		return nil, errors.Wrap(err, "[JSONMapParser] error decoding payload")
+	}
+
+	timestamp, err := p.time(message)
+	if err != nil {
+		return nil, errors.Wrap(err, "[JSONMapParser] error parsing time")
+	}
+
+	deviceID := lookupString(p.paths["device_id"], message)
+	port, _ := toFloat(lookup(p.paths["port"], message))
+
+	decodedPayload, _ := lookup(p.paths["decoded_payload"], message).(map[string]interface{})
+	raw := parser.Uplink{
+		Application: lookupString(p.paths["application"], message),
+		Device:      deviceID,
+		Port:        int(port),
+		Payload:     payload,
+	}
+
+	var decoded parser.Decoded
+	if p.MetricName != parser.LocationData {
+		decoded = parser.DecodeFields(p.FieldDecoder, exportFields(decodedPayload), raw)
+	}
+
+	dataRate := p.dataRate(message)
+
+	tags := make(map[string]string, len(p.DefaultTags))
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+
+	if len(deviceID) > 0 {
+		tags["device_id"] = deviceID
+	}
+	if frequency, ok := toFloat(lookup(p.paths["frequency"], message)); ok {
+		// frequencies in Hz are converted to MHz, like the other parsers report them
+		if frequency >= 1000000 {
+			frequency /= 1000000
+		}
+		tags["frequency"] = strconv.FormatFloat(frequency, 'f', -1, 64)
+	}
+	if len(dataRate) > 0 {
+		tags["data_rate"] = dataRate
+	}
+	for k, path := range p.tagPaths {
+		if v, ok := path.Lookup(message); ok {
+			tags[k] = toString(v)
+		}
+	}
+	if p.MetricName == parser.LocationData {
+		location, err := parser.CoverageLocation(p.LocationCodec, p.FieldDecoder, exportFields(decodedPayload), raw)
+		if err == nil {
+			for k, v := range location.Tags() {
+				tags[k] = v
+			}
+		}
+	}
+
+	gateways := []interface{}{message}
+	if path, ok := p.paths["gateways"]; ok {
+		v, _ := path.Lookup(message)
+		gateways, _ = v.([]interface{})
+		if len(gateways) == 0 {
+			return nil, errors.New("[JSONMapParser] wrong number of gateways (0)")
+		}
+	}
+
+	for _, g := range gateways {
+		gatewayTags := make(map[string]string, len(tags)+1)
+		for k, v := range tags {
+			gatewayTags[k] = v
+		}
+
+		fields := map[string]interface{}{
+			"size": payload.Size,
+		}
+		for k, path := range p.fieldPaths {
+			if v, ok := path.Lookup(message); ok {
+				fields[k] = p.field(k, v)
+			}
+		}
+
+		metric, err := model.NewMetric(p.MetricName, gatewayTags, fields, timestamp)
+		if err != nil {
+			return nil, errors.Wrap(err, "[JSONMapParser] error creating metric")
+		}
+
+		rssi := lookup(p.gatewayPaths["rssi"], g)
+		snr := lookup(p.gatewayPaths["snr"], g)
+
+		if p.MetricName == parser.LocationData {
+			if rssi != nil {
+				metric.AddField("rssi", p.field("rssi", rssi))
+			}
+			if snr != nil {
+				metric.AddField("snr", p.field("snr", snr))
+			}
+		} else {
+			if rssi != nil {
+				metric.AddTag("rssi", toString(rssi))
+			}
+			if snr != nil {
+				metric.AddTag("snr", toString(snr))
+			}
+			for k, v := range decoded.Fields {
+				metric.AddField(k, v)
+			}
+			for k, v := range decoded.Tags {
+				metric.AddTag(k, v)
+			}
+
+			if (p.MetricName == "adr" || p.MetricName == "ddr") && len(dataRate) > 0 {
+				metric.AddField("dr", parser.DataRateIndex(dataRate))
+			}
+		}
+
+		for k, path := range p.gwTagPaths {
+			if v, ok := path.Lookup(g); ok {
+				metric.AddTag(k, toString(v))
+			}
+		}
+		for k, path := range p.gwFieldPaths {
+			if v, ok := path.Lookup(g); ok {
+				metric.AddField(k, p.field(k, v))
+			}
+		}
+
+		if id := lookupString(p.gatewayPaths["id"], g); len(id) > 0 {
+			metric.AddTag("gateway_id", id)
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+func (p *jsonmapParser) payload(message interface{}) (parser.Payload, error) {
+	data, ok := lookup(p.paths["payload"], message).(string)
+	if !ok {
+		return parser.Payload{}, nil
+	}
+
+	var buf []byte
+	var err error
+	if p.Config.Encoding == EncodingHex {
+		buf, err = hex.DecodeString(data)
+	} else {
+		buf, err = base64.StdEncoding.DecodeString(data)
+	}
+	if err != nil {
+		return parser.Payload{}, err
+	}
+
+	return parser.Payload{Size: len(buf), Bytes: buf}, nil
+}
+
+func (p *jsonmapParser) time(message interface{}) (time.Time, error) {
+	v := lookup(p.paths["time"], message)
+	if v == nil {
+		return time.Now(), nil
+	}
+
+	switch p.Config.TimeFormat {
+	case TimeUnix, TimeUnixMs:
+		unit := time.Second
+		if p.Config.TimeFormat == TimeUnixMs {
+			unit = time.Millisecond
+		}
+
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return time.Unix(0, i*int64(unit)), nil
+			}
+		}
+
+		t, ok := toFloat(v)
+		if !ok {
+			return time.Time{}, errors.Errorf("%v is not a number", v)
+		}
+		return time.Unix(0, int64(math.Round(t*float64(unit)))), nil
+	case "", TimeRFC3339:
+		return time.Parse(time.RFC3339Nano, toString(v))
+	default:
+		return time.Parse(p.Config.TimeFormat, toString(v))
+	}
+}
+
+func (p *jsonmapParser) dataRate(message interface{}) string {
+	if dataRate := lookupString(p.paths["data_rate"], message); len(dataRate) > 0 {
+		return dataRate
+	}
+
+	sf, ok := toFloat(lookup(p.paths["spreading_factor"], message))
+	if !ok {
+		return ""
+	}
+
+	bw, ok := toFloat(lookup(p.paths["bandwidth"], message))
+	if !ok {
+		bw = 125
+	}
+	if bw >= 1000 {
+		bw /= 1000
+	}
+
+	return parser.DataRate(int(sf), int(bw))
+}
+
+func (p *jsonmapParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+func (p *jsonmapParser) SetFieldDecoder(decoder parser.FieldDecoder) {
+	p.FieldDecoder = decoder
+}
+
+func (p *jsonmapParser) SetLocationCodec(codec parser.LocationCodec) {
+	p.LocationCodec = codec
+}
+
+func lookup(path Path, v interface{}) interface{} {
+	if path == nil {
+		return nil
+	}
+
+	value, _ := path.Lookup(v)
+	return value
+}
+
+func lookupString(path Path, v interface{}) string {
+	value := lookup(path, v)
+	if value == nil {
+		return ""
+	}
+
+	return toString(value)
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		buf, _ := json.Marshal(v)
+		return string(buf)
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// field returns the value of a mapped field, converted to the type declared
+// in the config or its default type.
+func (p *jsonmapParser) field(k string, v interface{}) interface{} {
+	value := toField(v)
+
+	t, ok := p.types[k]
+	if !ok {
+		return value
+	}
+
+	converted, err := model.ConvertField(value, t)
+	if err != nil {
+		log.WithError(err).WithField("field", k).Debug("[JSONMapParser] field doesn't have the declared type")
+		return value
+	}
+
+	return converted
+}
+
+// toField converts numbers to a float64.
+func toField(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]interface{}:
+		return exportFields(v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = toField(v[i])
+		}
+		return values
+	default:
+		return v
+	}
+}
+
+func exportFields(object map[string]interface{}) map[string]interface{} {
+	if object == nil {
+		return nil
+	}
+
+	fields := make(map[string]interface{}, len(object))
+	for k, v := range object {
+		fields[k] = toField(v)
+	}
+
+	return fields
+}
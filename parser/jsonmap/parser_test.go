@@ -0,0 +1,290 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jsonmap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/parser"
+)
+
+const (
+	name = "test"
+
+	loriotMessage = `{
+  "cmd": "gw",
+  "EUI": "0004A30B001C0530",
+  "ts": 1514764800123,
+  "fcnt": 2,
+  "port": 1,
+  "freq": 868100000,
+  "dr": "SF7 BW125 4/5",
+  "sf": 7,
+  "bw": 125,
+  "data": "07c841ff47e0fe",
+  "gws": [
+    {"rssi": -110, "snr": 5.2, "gweui": "7276FF000B031F6B", "lat": 51.0, "lon": 3.7},
+    {"rssi": -97, "snr": 9.75, "gweui": "B827EBFFFE6A1C5D"}
+  ]
+}`
+
+	heliumMessage = `{
+  "dev_eui": "0004A30B001C0530",
+  "name": "node_1",
+  "app_eui": "70B3D57ED0000000",
+  "reported_at": 1514764800,
+  "port": 2,
+  "payload": "AWcA6gJoUA==",
+  "decoded": {"payload": {"temperature": 23.4, "humidity": 40}},
+  "hotspots": [
+    {"name": "jolly-red-dog", "rssi": -105.0, "snr": 3.5, "frequency": 867.3, "spreading": "SF9BW125"}
+  ]
+}`
+)
+
+var loriotConfig = Config{
+	DeviceID:        "$.EUI",
+	Port:            "$.port",
+	Time:            "$.ts",
+	TimeFormat:      TimeUnixMs,
+	Payload:         "$.data",
+	Encoding:        EncodingHex,
+	Frequency:       "$.freq",
+	SpreadingFactor: "$.sf",
+	Bandwidth:       "$.bw",
+	Gateways:        "$.gws",
+	Gateway: GatewayConfig{
+		ID:   "@.gweui",
+		RSSI: "@.rssi",
+		SNR:  "@.snr",
+		Tags: map[string]string{"gateway_latitude": "@.lat"},
+	},
+	Fields: map[string]string{"counter": "$['fcnt']", "f_cnt": "$.fcnt"},
+	Types:  map[string]string{"counter": "int"},
+}
+
+var heliumConfig = Config{
+	DeviceID:       "$.name",
+	Application:    "$.app_eui",
+	Port:           "$.port",
+	Time:           "$.reported_at",
+	TimeFormat:     TimeUnix,
+	Payload:        "$.payload",
+	DecodedPayload: "$.decoded.payload",
+	Gateways:       "$.hotspots",
+	Gateway: GatewayConfig{
+		ID:   "@.name",
+		RSSI: "@.rssi",
+		SNR:  "@.snr",
+	},
+	Tags: map[string]string{"frequency": "$.hotspots[0].frequency", "data_rate": "$.hotspots[-1].spreading"},
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(name, loriotConfig)
+	if err != nil {
+		t.Error(err)
+	}
+	if p.(*jsonmapParser).MetricName != name {
+		t.Error("metric name should be initialized")
+	}
+
+	if _, err := New("", loriotConfig); err == nil {
+		t.Error("empty metric name should give an error")
+	}
+
+	if _, err := New(name, Config{}); err == nil {
+		t.Error("missing payload path should give an error")
+	}
+
+	if _, err := New(name, Config{Payload: "$.data", Types: map[string]string{"rssi": "double"}}); err == nil {
+		t.Error("unknown field type should give an error")
+	}
+
+	if _, err := New(name, Config{Payload: "$.data", Encoding: "base32"}); err == nil {
+		t.Error("unknown encoding should give an error")
+	}
+
+	if _, err := New(name, Config{Payload: "$.data[0"}); err == nil {
+		t.Error("invalid path should give an error")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	document := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b c": []interface{}{"x", "y", map[string]interface{}{"d": "z"}},
+		},
+	}
+
+	tests := map[string]interface{}{
+		"$.a['b c'][0]":    "x",
+		`$["a"]["b c"][1]`: "y",
+		"a['b c'][2].d":    "z",
+		"$.a['b c'][-1].d": "z",
+	}
+
+	for expr, expected := range tests {
+		path, err := Compile(expr)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+
+		if v, ok := path.Lookup(document); !ok || v != expected {
+			t.Errorf("%s: %v != %v", expr, v, expected)
+		}
+	}
+
+	for _, expr := range []string{"$.a['b c'][3]", "$.x", "$.a.b"} {
+		path, err := Compile(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := path.Lookup(document); ok {
+			t.Errorf("%s should not match", expr)
+		}
+	}
+
+	for _, expr := range []string{"", "$..a", "$[x]", "$.a[0", "$a"} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("%q should give an error", expr)
+		}
+	}
+}
+
+func TestJsonmapParser_Parse(t *testing.T) {
+	p, err := New(parser.LocationData, loriotConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, err := p.Parse([]byte(loriotMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 2 {
+		t.Fatalf("should have 2 metrics, got %d", len(metrics))
+	}
+
+	metric := metrics[0]
+
+	expectedTags := map[string]string{
+		"device_id":        "0004A30B001C0530",
+		"frequency":        "868.1",
+		"data_rate":        "SF7BW125",
+		"latitude":         "51.0017",
+		"longitude":        "-4.7136",
+		"power":            "-2",
+		"gateway_id":       "7276FF000B031F6B",
+		"gateway_latitude": "51.0",
+	}
+
+	for k, v := range expectedTags {
+		if metric.Tags()[k] != v {
+			t.Errorf("wrong tag %s: %s != %s", k, metric.Tags()[k], v)
+		}
+	}
+
+	expectedFields := map[string]interface{}{
+		"size":    7,
+		"rssi":    int64(-110),
+		"snr":     5.2,
+		"counter": int64(2),
+		"f_cnt":   int64(2),
+	}
+
+	for k, v := range expectedFields {
+		if metric.Fields()[k] != v {
+			t.Errorf("wrong field %s: %v (%T) != %v", k, metric.Fields()[k], metric.Fields()[k], v)
+		}
+	}
+
+	if !metric.Time().Equal(time.Unix(1514764800, 123000000)) {
+		t.Errorf("wrong time: %s", metric.Time())
+	}
+
+	if metrics[1].Tags()["gateway_id"] != "B827EBFFFE6A1C5D" || metrics[1].HasTag("gateway_latitude") {
+		t.Error("gateway tags should not be shared between metrics")
+	}
+}
+
+func TestJsonmapParser_Parse2(t *testing.T) {
+	p, err := New("adr", heliumConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, err := p.Parse([]byte(heliumMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 {
+		t.Fatalf("should have 1 metric, got %d", len(metrics))
+	}
+
+	metric := metrics[0]
+
+	expectedTags := map[string]string{
+		"device_id":  "node_1",
+		"frequency":  "867.3",
+		"data_rate":  "SF9BW125",
+		"rssi":       "-105.0",
+		"snr":        "3.5",
+		"gateway_id": "jolly-red-dog",
+	}
+
+	for k, v := range expectedTags {
+		if metric.Tags()[k] != v {
+			t.Errorf("wrong tag %s: %s != %s", k, metric.Tags()[k], v)
+		}
+	}
+
+	expectedFields := map[string]interface{}{
+		"size":        7,
+		"temperature": 23.4,
+		"humidity":    40.0,
+	}
+
+	for k, v := range expectedFields {
+		if metric.Fields()[k] != v {
+			t.Errorf("wrong field %s: %v (%T) != %v", k, metric.Fields()[k], metric.Fields()[k], v)
+		}
+	}
+
+	if metric.HasField("dr") {
+		t.Error("data rate from a tag mapping should not add a dr field")
+	}
+
+	if !metric.Time().Equal(time.Unix(1514764800, 0)) {
+		t.Errorf("wrong time: %s", metric.Time())
+	}
+
+	if _, err := p.Parse([]byte(`{"payload": "AQ==", "hotspots": []}`)); err == nil {
+		t.Error("message without gateways should give an error")
+	}
+}
@@ -0,0 +1,125 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jsonmap
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Path is a compiled JSONPath expression. Only the subset needed to select a
+// single value is supported: $.name, $['name'], $[index] and combinations of
+// them, e.g. $.rxInfo[0].rssi. Negative indices count from the end of the
+// array. Paths starting with @ (or without $) are relative, which is how the
+// values of a gateway are selected.
+type Path []step
+
+type step struct {
+	key   string
+	index int
+	isKey bool
+}
+
+func Compile(expr string) (Path, error) {
+	var path Path
+
+	s := strings.TrimSpace(expr)
+	if len(s) == 0 {
+		return nil, errors.New("[JSONPath] empty expression")
+	}
+
+	if s[0] == '$' || s[0] == '@' {
+		s = s[1:]
+	} else {
+		s = "." + s
+	}
+
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			end := strings.IndexAny(s[1:], ".[")
+			if end < 0 {
+				end = len(s) - 1
+			}
+			key := s[1 : end+1]
+			if len(key) == 0 {
+				return nil, errors.Errorf("[JSONPath] empty name in %s", expr)
+			}
+			path = append(path, step{key: key, isKey: true})
+			s = s[end+1:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, errors.Errorf("[JSONPath] missing ] in %s", expr)
+			}
+			selector := s[1:end]
+			if len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0] {
+				path = append(path, step{key: selector[1 : len(selector)-1], isKey: true})
+			} else {
+				index, err := strconv.Atoi(selector)
+				if err != nil {
+					return nil, errors.Errorf("[JSONPath] invalid index %s in %s", selector, expr)
+				}
+				path = append(path, step{index: index})
+			}
+			s = s[end+1:]
+		default:
+			return nil, errors.Errorf("[JSONPath] unexpected %q in %s", s[0], expr)
+		}
+	}
+
+	return path, nil
+}
+
+// Lookup returns the value at the path in a decoded JSON document.
+func (p Path) Lookup(v interface{}) (interface{}, bool) {
+	for _, s := range p {
+		if s.isKey {
+			object, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = object[s.key]; !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		array, ok := v.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		index := s.index
+		if index < 0 {
+			index += len(array)
+		}
+		if index < 0 || index >= len(array) {
+			return nil, false
+		}
+		v = array[index]
+	}
+
+	return v, v != nil
+}
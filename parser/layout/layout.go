@@ -0,0 +1,238 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package layout decodes binary payloads with layouts that are declared in
+// the configuration, so a new payload format doesn't need a code change.
+package layout
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/pkg/errors"
+)
+
+const (
+	BigEndian    = "big"
+	LittleEndian = "little"
+)
+
+// Layout describes the fields in the payloads of the devices and/or ports
+// it applies to. An empty list of ports or devices matches all of them.
+type Layout struct {
+	Name    string   `yaml:"name" mapstructure:"name"`
+	Ports   []int    `yaml:"ports,omitempty" mapstructure:"ports"`
+	Devices []string `yaml:"devices,omitempty" mapstructure:"devices"`
+	Fields  []Field  `yaml:"fields" mapstructure:"fields"`
+}
+
+// Field is a (signed) integer of length bytes at offset in the payload. When
+// bit_length is set only those bits (starting at bit_offset from the least
+// significant bit) are used. Fields with a scale or value_offset are decoded
+// as value * scale + value_offset, the others as integers.
+type Field struct {
+	Name        string  `yaml:"name" mapstructure:"name"`
+	Offset      int     `yaml:"offset" mapstructure:"offset"`
+	Length      int     `yaml:"length" mapstructure:"length"`
+	Endianness  string  `yaml:"endianness,omitempty" mapstructure:"endianness"`
+	Signed      bool    `yaml:"signed,omitempty" mapstructure:"signed"`
+	Scale       float64 `yaml:"scale,omitempty" mapstructure:"scale"`
+	ValueOffset float64 `yaml:"value_offset,omitempty" mapstructure:"value_offset"`
+	BitOffset   int     `yaml:"bit_offset,omitempty" mapstructure:"bit_offset"`
+	BitLength   int     `yaml:"bit_length,omitempty" mapstructure:"bit_length"`
+	Tag         bool    `yaml:"tag,omitempty" mapstructure:"tag"`
+}
+
+type decoder struct {
+	layouts []Layout
+}
+
+func New(layouts []Layout) (parser.FieldDecoder, error) {
+	for i, l := range layouts {
+		if len(l.Fields) == 0 {
+			return nil, errors.Errorf("[Layout] layout %d (%s) has no fields", i, l.Name)
+		}
+
+		for _, f := range l.Fields {
+			if err := f.validate(); err != nil {
+				return nil, errors.Wrapf(err, "[Layout] layout %d (%s)", i, l.Name)
+			}
+		}
+	}
+
+	return &decoder{layouts: layouts}, nil
+}
+
+func (d *decoder) Decode(uplink parser.Uplink) (parser.Decoded, error) {
+	for _, l := range d.layouts {
+		if l.matches(uplink) {
+			return l.Decode(uplink.Payload.Bytes)
+		}
+	}
+
+	return parser.Decoded{}, nil
+}
+
+func (l Layout) matches(uplink parser.Uplink) bool {
+	if len(l.Ports) > 0 {
+		found := false
+		for _, port := range l.Ports {
+			if port == uplink.Port {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(l.Devices) > 0 {
+		for _, device := range l.Devices {
+			if device == uplink.Device {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func (l Layout) Decode(buf []byte) (parser.Decoded, error) {
+	decoded := parser.Decoded{
+		Fields: make(map[string]interface{}),
+		Tags:   make(map[string]string),
+	}
+
+	for _, f := range l.Fields {
+		value, err := f.Decode(buf)
+		if err != nil {
+			return parser.Decoded{}, errors.Wrapf(err, "[Layout] %s", l.Name)
+		}
+
+		if !f.Tag {
+			decoded.Fields[f.Name] = value
+			continue
+		}
+
+		switch v := value.(type) {
+		case int64:
+			decoded.Tags[f.Name] = strconv.FormatInt(v, 10)
+		case uint64:
+			decoded.Tags[f.Name] = strconv.FormatUint(v, 10)
+		case float64:
+			decoded.Tags[f.Name] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+
+	return decoded, nil
+}
+
+func (f Field) validate() error {
+	if len(f.Name) == 0 {
+		return errors.New("field without name")
+	}
+
+	if f.Offset < 0 {
+		return errors.Errorf("field %s: negative offset", f.Name)
+	}
+
+	if f.Length < 1 || f.Length > 8 {
+		return errors.Errorf("field %s: length must be between 1 and 8 bytes", f.Name)
+	}
+
+	if f.Endianness != "" && f.Endianness != BigEndian && f.Endianness != LittleEndian {
+		return errors.Errorf("field %s: unknown endianness %s", f.Name, f.Endianness)
+	}
+
+	if f.BitOffset < 0 || f.BitLength < 0 || f.BitOffset+f.BitLength > f.Length*8 {
+		return errors.Errorf("field %s: bits out of range", f.Name)
+	}
+
+	if f.BitLength == 0 && f.BitOffset != 0 {
+		return errors.Errorf("field %s: bit_offset without bit_length", f.Name)
+	}
+
+	return nil
+}
+
+// Decode returns the value of the field in buf, as an int64 or a float64.
+// Unsigned values that don't fit in an int64 are returned as a uint64.
+func (f Field) Decode(buf []byte) (interface{}, error) {
+	if len(buf) < f.Offset+f.Length {
+		return nil, errors.Errorf("payload too short for field %s (%d bytes)", f.Name, len(buf))
+	}
+
+	b := buf[f.Offset : f.Offset+f.Length]
+
+	var raw uint64
+	for i := range b {
+		if f.Endianness == LittleEndian {
+			raw = raw<<8 | uint64(b[len(b)-1-i])
+		} else {
+			raw = raw<<8 | uint64(b[i])
+		}
+	}
+
+	bits := uint(f.Length * 8)
+	if f.BitLength > 0 {
+		bits = uint(f.BitLength)
+		raw = raw >> uint(f.BitOffset) & (1<<bits - 1)
+	}
+
+	scale := f.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	// unsigned 8 byte values don't always fit in an int64
+	if !f.Signed && raw > math.MaxInt64 {
+		if f.Scale == 0 && f.ValueOffset == 0 {
+			return raw, nil
+		}
+		return round(float64(raw)*scale + f.ValueOffset), nil
+	}
+
+	value := int64(raw)
+	if f.Signed && bits < 64 && raw&(1<<(bits-1)) != 0 {
+		value = int64(raw) - 1<<bits
+	}
+
+	if f.Scale == 0 && f.ValueOffset == 0 {
+		return value, nil
+	}
+
+	return round(float64(value)*scale + f.ValueOffset), nil
+}
+
+// round drops the floating point noise of the scaling, so 510017 * 0.0001
+// becomes 51.0017 instead of 51.001700000000004.
+func round(v float64) float64 {
+	r, err := strconv.ParseFloat(strconv.FormatFloat(v, 'g', 15, 64), 64)
+	if err != nil {
+		return v
+	}
+
+	return r
+}
@@ -0,0 +1,185 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package layout
+
+import (
+	"testing"
+
+	"github.com/bullettime/lora-mqtt/parser"
+)
+
+var coverage = Layout{
+	Name:  "coverage",
+	Ports: []int{1},
+	Fields: []Field{
+		{Name: "latitude", Offset: 0, Length: 3, Signed: true, Scale: 0.0001},
+		{Name: "longitude", Offset: 3, Length: 3, Signed: true, Scale: 0.0001},
+		{Name: "power", Offset: 6, Length: 1, Signed: true, Tag: true},
+	},
+}
+
+func uplink(port int, device string, buf []byte) parser.Uplink {
+	return parser.Uplink{
+		Device:  device,
+		Port:    port,
+		Payload: parser.Payload{Size: len(buf), Bytes: buf},
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New([]Layout{coverage}); err != nil {
+		t.Error(err)
+	}
+
+	invalid := []Field{
+		{Name: "", Length: 1},
+		{Name: "a", Length: 0},
+		{Name: "a", Length: 9},
+		{Name: "a", Length: 1, Offset: -1},
+		{Name: "a", Length: 1, Endianness: "middle"},
+		{Name: "a", Length: 1, BitOffset: 4, BitLength: 5},
+		{Name: "a", Length: 1, BitOffset: 4},
+	}
+
+	for _, f := range invalid {
+		if _, err := New([]Layout{{Name: "invalid", Fields: []Field{f}}}); err == nil {
+			t.Errorf("invalid field should give an error: %+v", f)
+		}
+	}
+
+	if _, err := New([]Layout{{Name: "empty"}}); err == nil {
+		t.Error("layout without fields should give an error")
+	}
+}
+
+func TestDecoder_Decode(t *testing.T) {
+	d, err := New([]Layout{coverage})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := d.Decode(uplink(1, "", []byte{0x07, 0xc8, 0x41, 0xff, 0x47, 0xe0, 0xfe}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Fields["latitude"] != 51.0017 {
+		t.Errorf("wrong latitude: %v", decoded.Fields["latitude"])
+	}
+
+	if decoded.Fields["longitude"] != -4.7136 {
+		t.Errorf("wrong longitude: %v", decoded.Fields["longitude"])
+	}
+
+	if decoded.Tags["power"] != "-2" {
+		t.Errorf("wrong power tag: %v", decoded.Tags["power"])
+	}
+
+	decoded, err = d.Decode(uplink(2, "", []byte{0x07, 0xc8, 0x41, 0xff, 0x47, 0xe0, 0xfe}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !decoded.Empty() {
+		t.Error("layout should not match other ports")
+	}
+
+	if _, err := d.Decode(uplink(1, "", []byte{0x07, 0xc8})); err == nil {
+		t.Error("short payload should give an error")
+	}
+}
+
+func TestDecoder_Decode2(t *testing.T) {
+	d, err := New([]Layout{
+		{
+			Name:    "tracker",
+			Devices: []string{"tracker_1"},
+			Fields: []Field{
+				{Name: "battery", Offset: 0, Length: 2, Endianness: LittleEndian, Scale: 0.001},
+				{Name: "temperature", Offset: 2, Length: 2, Endianness: LittleEndian, Signed: true, Scale: 0.1, ValueOffset: -20},
+				{Name: "moving", Offset: 4, Length: 1, BitOffset: 7, BitLength: 1},
+				{Name: "satellites", Offset: 4, Length: 1, BitOffset: 0, BitLength: 4},
+				{Name: "delta", Offset: 4, Length: 1, BitOffset: 4, BitLength: 3, Signed: true},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := d.Decode(uplink(5, "tracker_1", []byte{0x74, 0x0e, 0xce, 0xff, 0xe9}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"battery":     3.7,
+		"temperature": -25.0,
+		"moving":      int64(1),
+		"satellites":  int64(9),
+		"delta":       int64(-2),
+	}
+
+	for k, v := range expected {
+		if decoded.Fields[k] != v {
+			t.Errorf("wrong value for %s: %v (%T) != %v", k, decoded.Fields[k], decoded.Fields[k], v)
+		}
+	}
+
+	decoded, err = d.Decode(uplink(5, "tracker_2", []byte{0x74, 0x0e, 0xce, 0xff, 0xe9}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !decoded.Empty() {
+		t.Error("layout should not match other devices")
+	}
+}
+
+func TestField_Decode(t *testing.T) {
+	buf := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}
+
+	value, err := Field{Name: "counter", Length: 8}.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != uint64(18446744073709551614) {
+		t.Errorf("unsigned 8 byte value should be a uint64: %v (%T)", value, value)
+	}
+
+	value, err = Field{Name: "counter", Length: 8, Signed: true}.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != int64(-2) {
+		t.Errorf("signed 8 byte value should be an int64: %v (%T)", value, value)
+	}
+
+	value, err = Field{Name: "counter", Length: 8}.Decode([]byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != int64(9223372036854775807) {
+		t.Errorf("unsigned value that fits should be an int64: %v (%T)", value, value)
+	}
+}
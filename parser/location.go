@@ -0,0 +1,264 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parser
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultLocationCodec = "int24"
+
+var InvalidLocationError = errors.New("invalid location")
+
+// Location is a position decoded from a coverage payload. Precision is the
+// number of decimals of the latitude and longitude that are meaningful.
+type Location struct {
+	Latitude    float64
+	Longitude   float64
+	Precision   int
+	Altitude    float64
+	HasAltitude bool
+	HDOP        float64
+	HasHDOP     bool
+	Power       int8
+	HasPower    bool
+}
+
+// LocationCodec decodes the location from the bytes of a payload.
+type LocationCodec interface {
+	DecodeLocation(buf []byte) (Location, error)
+}
+
+type LocationCodecFunc func(buf []byte) (Location, error)
+
+func (f LocationCodecFunc) DecodeLocation(buf []byte) (Location, error) {
+	return f(buf)
+}
+
+// LocationCodecSetter is implemented by the parsers that decode the location
+// of coverage uplinks, so every parser (or route) can use its own codec.
+type LocationCodecSetter interface {
+	SetLocationCodec(codec LocationCodec)
+}
+
+var (
+	locationMu     sync.RWMutex
+	locationCodecs = map[string]LocationCodec{
+		"int24":      LocationCodecFunc(decodeInt24),
+		"int32":      LocationCodecFunc(decodeInt32),
+		"ttnmapper":  LocationCodecFunc(decodeTTNMapper),
+		"cayennelpp": LocationCodecFunc(decodeCayenneGPS),
+	}
+)
+
+// RegisterLocationCodec makes a codec available to GetLocationCodec.
+func RegisterLocationCodec(name string, codec LocationCodec) {
+	locationMu.Lock()
+	defer locationMu.Unlock()
+
+	locationCodecs[name] = codec
+}
+
+// GetLocationCodec returns the registered codec with the name.
+func GetLocationCodec(name string) (LocationCodec, error) {
+	locationMu.RLock()
+	defer locationMu.RUnlock()
+
+	codec, ok := locationCodecs[name]
+	if !ok {
+		return nil, errors.Errorf("[Location] unknown codec %s (%s)", name, strings.Join(locationCodecNames(), ", "))
+	}
+
+	return codec, nil
+}
+
+// LocationCodecNames returns the names of the registered codecs.
+func LocationCodecNames() []string {
+	locationMu.RLock()
+	defer locationMu.RUnlock()
+
+	return locationCodecNames()
+}
+
+func locationCodecNames() []string {
+	var names []string
+	for name := range locationCodecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Location decodes the payload with the codec, or the default int24 codec
+// when it is nil, and rejects positions that are out of range.
+func (p Payload) Location(codec LocationCodec) (Location, error) {
+	if codec == nil {
+		codec = LocationCodecFunc(decodeInt24)
+	}
+
+	location, err := codec.DecodeLocation(p.Bytes)
+	if err != nil {
+		return Location{}, err
+	}
+
+	if err := location.Validate(); err != nil {
+		return Location{}, err
+	}
+
+	return location, nil
+}
+
+func (l Location) Validate() error {
+	if math.IsNaN(l.Latitude) || l.Latitude < -90 || l.Latitude > 90 {
+		return errors.Wrapf(InvalidLocationError, "latitude %f out of range", l.Latitude)
+	}
+
+	if math.IsNaN(l.Longitude) || l.Longitude < -180 || l.Longitude > 180 {
+		return errors.Wrapf(InvalidLocationError, "longitude %f out of range", l.Longitude)
+	}
+
+	return nil
+}
+
+// Tags returns the tags added to coverage metrics.
+func (l Location) Tags() map[string]string {
+	tags := map[string]string{
+		"latitude":  strconv.FormatFloat(l.Latitude, 'f', l.Precision, 64),
+		"longitude": strconv.FormatFloat(l.Longitude, 'f', l.Precision, 64),
+	}
+
+	if l.HasAltitude {
+		tags["altitude"] = strconv.FormatFloat(l.Altitude, 'f', -1, 64)
+	}
+
+	if l.HasHDOP {
+		tags["hdop"] = strconv.FormatFloat(l.HDOP, 'f', -1, 64)
+	}
+
+	if l.HasPower {
+		tags["power"] = strconv.Itoa(int(l.Power))
+	}
+
+	return tags
+}
+
+// decodeInt24 decodes the original coverage payload: latitude and longitude
+// as signed 24 bit integers in 1e-4 degrees, optionally followed by the
+// transmit power.
+func decodeInt24(buf []byte) (Location, error) {
+	if len(buf) < 6 || len(buf) > 7 {
+		return Location{}, InvalidPayloadError
+	}
+
+	location := Location{
+		Latitude:  float64(int24(buf[0:3])) / 1e4,
+		Longitude: float64(int24(buf[3:6])) / 1e4,
+		Precision: 4,
+	}
+
+	if len(buf) == 7 {
+		location.Power = int8(buf[6])
+		location.HasPower = true
+	}
+
+	return location, nil
+}
+
+// decodeInt32 decodes latitude and longitude as signed 32 bit integers in
+// 1e-7 degrees, optionally followed by the transmit power.
+func decodeInt32(buf []byte) (Location, error) {
+	if len(buf) < 8 || len(buf) > 9 {
+		return Location{}, InvalidPayloadError
+	}
+
+	location := Location{
+		Latitude:  float64(int32(uint32(buf[0])<<24|uint32(buf[1])<<16|uint32(buf[2])<<8|uint32(buf[3]))) / 1e7,
+		Longitude: float64(int32(uint32(buf[4])<<24|uint32(buf[5])<<16|uint32(buf[6])<<8|uint32(buf[7]))) / 1e7,
+		Precision: 7,
+	}
+
+	if len(buf) == 9 {
+		location.Power = int8(buf[8])
+		location.HasPower = true
+	}
+
+	return location, nil
+}
+
+// decodeTTNMapper decodes the payload of the TTN Mapper trackers: latitude
+// and longitude scaled to 24 bits, the altitude in meters and the HDOP in
+// tenths.
+func decodeTTNMapper(buf []byte) (Location, error) {
+	if len(buf) != 9 {
+		return Location{}, InvalidPayloadError
+	}
+
+	latitude := uint32(buf[0])<<16 | uint32(buf[1])<<8 | uint32(buf[2])
+	longitude := uint32(buf[3])<<16 | uint32(buf[4])<<8 | uint32(buf[5])
+
+	return Location{
+		Latitude:    round(float64(latitude)/16777215*180-90, 6),
+		Longitude:   round(float64(longitude)/16777215*360-180, 6),
+		Precision:   6,
+		Altitude:    float64(uint16(buf[6])<<8 | uint16(buf[7])),
+		HasAltitude: true,
+		HDOP:        float64(buf[8]) / 10,
+		HasHDOP:     true,
+	}, nil
+}
+
+// decodeCayenneGPS decodes a Cayenne LPP payload starting with a GPS
+// location: latitude and longitude in 1e-4 degrees and the altitude in
+// centimeters, all signed 24 bit integers.
+func decodeCayenneGPS(buf []byte) (Location, error) {
+	if len(buf) < 11 || buf[1] != 0x88 {
+		return Location{}, InvalidPayloadError
+	}
+
+	return Location{
+		Latitude:    float64(int24(buf[2:5])) / 1e4,
+		Longitude:   float64(int24(buf[5:8])) / 1e4,
+		Precision:   4,
+		Altitude:    float64(int24(buf[8:11])) / 100,
+		HasAltitude: true,
+	}, nil
+}
+
+func int24(b []byte) int32 {
+	v := int32(b[0])<<16 | int32(b[1])<<8 | int32(b[2])
+	if v&0x800000 != 0 {
+		v -= 1 << 24
+	}
+	return v
+}
+
+func round(v float64, decimals int) float64 {
+	pow := math.Pow(10, float64(decimals))
+	return math.Round(v*pow) / pow
+}
@@ -0,0 +1,127 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parser
+
+import (
+	"testing"
+)
+
+func TestPayload_Location(t *testing.T) {
+	tests := []struct {
+		codec    string
+		buf      []byte
+		expected Location
+	}{
+		{
+			codec:    "int24",
+			buf:      []byte{0x07, 0xc8, 0x41, 0x00, 0x47, 0xe0, 0x0e},
+			expected: Location{Latitude: 51.0017, Longitude: 1.8400, Precision: 4, Power: 14, HasPower: true},
+		},
+		{
+			codec:    "int24",
+			buf:      []byte{0xfa, 0xd5, 0x58, 0xf5, 0x38, 0x69},
+			expected: Location{Latitude: -33.8600, Longitude: -70.6455, Precision: 4},
+		},
+		{
+			codec:    "int32",
+			buf:      []byte{0xeb, 0xd0, 0x07, 0x6d, 0x5a, 0x20, 0xb5, 0x4c, 0xfe},
+			expected: Location{Latitude: -33.8688147, Longitude: 151.2093004, Precision: 7, Power: -2, HasPower: true},
+		},
+		{
+			codec:    "ttnmapper",
+			buf:      []byte{0xc8, 0x88, 0x88, 0x80, 0xd6, 0x5b, 0x00, 0x12, 0x0b},
+			expected: Location{Latitude: 51.000003, Longitude: 1.177501, Precision: 6, Altitude: 18, HasAltitude: true, HDOP: 1.1, HasHDOP: true},
+		},
+		{
+			codec:    "cayennelpp",
+			buf:      []byte{0x01, 0x88, 0x06, 0x76, 0x5f, 0xf2, 0x96, 0x0a, 0x00, 0x03, 0xe8},
+			expected: Location{Latitude: 42.3519, Longitude: -87.9094, Precision: 4, Altitude: 10, HasAltitude: true},
+		},
+	}
+
+	for _, test := range tests {
+		codec, err := GetLocationCodec(test.codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		location, err := Payload{Size: len(test.buf), Bytes: test.buf}.Location(codec)
+		if err != nil {
+			t.Errorf("%s: %v", test.codec, err)
+			continue
+		}
+
+		if location != test.expected {
+			t.Errorf("%s: %+v != %+v", test.codec, location, test.expected)
+		}
+	}
+}
+
+func TestPayload_Location2(t *testing.T) {
+	codec, err := GetLocationCodec("int32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 100 degrees latitude
+	buf := []byte{0x3b, 0x9a, 0xca, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if _, err := (Payload{Size: len(buf), Bytes: buf}).Location(codec); err == nil {
+		t.Error("latitude out of range should give an error")
+	}
+
+	if _, _, err := (Payload{Size: 3, Bytes: []byte{0x00, 0x01, 0x02}}).GetLocation(); err == nil {
+		t.Error("short payload should give an error")
+	}
+
+	// the default codec expects 6 or 7 bytes
+	if _, err := (Payload{Size: len(buf), Bytes: buf}).Location(nil); err == nil {
+		t.Error("int32 payload should not be decoded by the default codec")
+	}
+
+	if _, err := GetLocationCodec("unknown"); err == nil {
+		t.Error("unknown codec should give an error")
+	}
+}
+
+func TestLocation_Tags(t *testing.T) {
+	location := Location{Latitude: -33.86, Longitude: 151.2, Precision: 4, Altitude: 18, HasAltitude: true, HDOP: 1.1, HasHDOP: true, Power: -2, HasPower: true}
+
+	expected := map[string]string{
+		"latitude":  "-33.8600",
+		"longitude": "151.2000",
+		"altitude":  "18",
+		"hdop":      "1.1",
+		"power":     "-2",
+	}
+
+	tags := location.Tags()
+	if len(tags) != len(expected) {
+		t.Errorf("wrong number of tags: %v", tags)
+	}
+
+	for k, v := range expected {
+		if tags[k] != v {
+			t.Errorf("wrong tag %s: %s != %s", k, tags[k], v)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package lorawan decodes LoRaWAN 1.0.x data frames (PHYPayload), verifies
+// their MIC and decrypts the FRMPayload with the session keys of the device.
+package lorawan
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/pkg/errors"
+)
+
+type MType byte
+
+const (
+	JoinRequest MType = iota
+	JoinAccept
+	UnconfirmedDataUp
+	UnconfirmedDataDown
+	ConfirmedDataUp
+	ConfirmedDataDown
+	RFU
+	Proprietary
+)
+
+const (
+	micSize      = 4
+	minFrameSize = 1 + 7 + micSize
+)
+
+var (
+	UnsupportedFrameError = errors.New("[LoRaWAN] unsupported frame type")
+	UnknownDeviceError    = errors.New("[LoRaWAN] no session keys for device address")
+	InvalidMICError       = errors.New("[LoRaWAN] invalid MIC")
+)
+
+type Keys struct {
+	NwkSKey [16]byte
+	AppSKey [16]byte
+}
+
+// KeyTable holds the session keys of devices, indexed by their device address
+// as 8 lowercase hex characters.
+type KeyTable map[string]Keys
+
+type Frame struct {
+	MType      MType
+	Major      byte
+	DevAddr    string
+	ADR        bool
+	ACK        bool
+	FOptsLen   int
+	FCnt       uint32
+	FOpts      []byte
+	FPort      *int
+	FRMPayload []byte
+	MIC        [micSize]byte
+}
+
+// Counters remembers the last verified frame counter of every device address,
+// the upper 16 bits of the frame counters aren't transmitted. It's safe for
+// concurrent use.
+type Counters struct {
+	mu   sync.Mutex
+	last map[string]uint32
+}
+
+func NewCounters() *Counters {
+	return &Counters{
+		last: make(map[string]uint32),
+	}
+}
+
+func NewKeys(nwkSKey, appSKey string) (Keys, error) {
+	var keys Keys
+
+	if err := decodeKey(keys.NwkSKey[:], nwkSKey); err != nil {
+		return keys, errors.Wrap(err, "[LoRaWAN] invalid NwkSKey")
+	}
+
+	if err := decodeKey(keys.AppSKey[:], appSKey); err != nil {
+		return keys, errors.Wrap(err, "[LoRaWAN] invalid AppSKey")
+	}
+
+	return keys, nil
+}
+
+func decodeKey(dst []byte, key string) error {
+	b, err := hex.DecodeString(key)
+	if err != nil {
+		return err
+	}
+
+	if len(b) != len(dst) {
+		return errors.Errorf("key must be %d bytes, got %d", len(dst), len(b))
+	}
+
+	copy(dst, b)
+	return nil
+}
+
+func (t KeyTable) Add(devAddr string, keys Keys) error {
+	b, err := hex.DecodeString(devAddr)
+	if err != nil || len(b) != 4 {
+		return errors.Errorf("[LoRaWAN] invalid device address: %s", devAddr)
+	}
+
+	t[strings.ToLower(devAddr)] = keys
+	return nil
+}
+
+// Decode parses a data frame, verifies its MIC with the NwkSKey and decrypts
+// the FRMPayload with the AppSKey (or the NwkSKey for FPort 0).
+//
+// Only the 16 least significant bits of the frame counter are transmitted,
+// the MIC is verified with the upper 16 bits zero, and one after the counter
+// rolled over. Use Counters.Decode for devices that send more frames.
+func Decode(phyPayload []byte, keys KeyTable) (*Frame, error) {
+	return decode(phyPayload, keys, nil)
+}
+
+// Decode is like Decode, but restores the upper 16 bits of the frame counter
+// from the last frame counter of the device, or the next ones when the lower
+// bits rolled over.
+func (c *Counters) Decode(phyPayload []byte, keys KeyTable) (*Frame, error) {
+	return decode(phyPayload, keys, c)
+}
+
+func decode(phyPayload []byte, keys KeyTable, counters *Counters) (*Frame, error) {
+	frame, err := Parse(phyPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	k, ok := keys[frame.DevAddr]
+	if !ok {
+		return frame, UnknownDeviceError
+	}
+
+	nwkSKey, err := aes.NewCipher(k.NwkSKey[:])
+	if err != nil {
+		return frame, errors.Wrap(err, "[LoRaWAN] error creating cipher")
+	}
+
+	fCnt := frame.FCnt
+	verified := false
+	for _, candidate := range counters.candidates(frame.DevAddr, fCnt) {
+		frame.FCnt = candidate
+		mic := frame.computeMIC(nwkSKey, phyPayload[:len(phyPayload)-micSize])
+		if subtle.ConstantTimeCompare(mic, frame.MIC[:]) == 1 {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		frame.FCnt = fCnt
+		return frame, InvalidMICError
+	}
+	counters.verified(frame.DevAddr, frame.FCnt)
+
+	if frame.FPort != nil && len(frame.FRMPayload) > 0 {
+		block := nwkSKey
+		if *frame.FPort != 0 {
+			block, err = aes.NewCipher(k.AppSKey[:])
+			if err != nil {
+				return frame, errors.Wrap(err, "[LoRaWAN] error creating cipher")
+			}
+		}
+
+		frame.FRMPayload = frame.crypt(block, frame.FRMPayload)
+	}
+
+	return frame, nil
+}
+
+// candidates returns the frame counters with the lower 16 bits fCnt that the
+// device can have sent, the most likely first: the upper bits of its last
+// frame counter, the next ones after a rollover and zero after a reset.
+func (c *Counters) candidates(devAddr string, fCnt uint32) []uint32 {
+	var last uint32
+	if c != nil {
+		c.mu.Lock()
+		last = c.last[devAddr]
+		c.mu.Unlock()
+	}
+
+	upper := last &^ 0xffff
+	current, next := upper|fCnt, (upper+0x10000)|fCnt
+
+	candidates := []uint32{current, next}
+	if current < last {
+		candidates = []uint32{next, current}
+	}
+	if upper > 0 {
+		candidates = append(candidates, fCnt)
+	}
+
+	return candidates
+}
+
+func (c *Counters) verified(devAddr string, fCnt uint32) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.last[devAddr] = fCnt
+}
+
+// Parse parses the header of a data frame without verifying or decrypting it.
+func Parse(phyPayload []byte) (*Frame, error) {
+	if len(phyPayload) < minFrameSize {
+		return nil, errors.Errorf("[LoRaWAN] frame too short (%d bytes)", len(phyPayload))
+	}
+
+	frame := &Frame{
+		MType: MType(phyPayload[0] >> 5),
+		Major: phyPayload[0] & 0x03,
+	}
+
+	if frame.MType < UnconfirmedDataUp || frame.MType > ConfirmedDataDown {
+		return nil, UnsupportedFrameError
+	}
+
+	macPayload := phyPayload[1 : len(phyPayload)-micSize]
+	copy(frame.MIC[:], phyPayload[len(phyPayload)-micSize:])
+
+	devAddr := make([]byte, 4)
+	for i := 0; i < 4; i++ {
+		devAddr[i] = macPayload[3-i]
+	}
+	frame.DevAddr = hex.EncodeToString(devAddr)
+
+	fCtrl := macPayload[4]
+	frame.ADR = fCtrl&0x80 != 0
+	frame.ACK = fCtrl&0x20 != 0
+	frame.FOptsLen = int(fCtrl & 0x0f)
+	frame.FCnt = uint32(binary.LittleEndian.Uint16(macPayload[5:7]))
+
+	rest := macPayload[7:]
+	if len(rest) < frame.FOptsLen {
+		return nil, errors.New("[LoRaWAN] frame too short for FOpts")
+	}
+	frame.FOpts = rest[:frame.FOptsLen]
+	rest = rest[frame.FOptsLen:]
+
+	if len(rest) > 0 {
+		port := int(rest[0])
+		frame.FPort = &port
+		frame.FRMPayload = append([]byte(nil), rest[1:]...)
+	}
+
+	return frame, nil
+}
+
+func (f *Frame) Uplink() bool {
+	return f.MType == UnconfirmedDataUp || f.MType == ConfirmedDataUp
+}
+
+// Payload returns the FRMPayload in the form the parsers use for payloads
+// that were decrypted by a network server.
+func (f *Frame) Payload() parser.Payload {
+	return parser.Payload{
+		Size:  len(f.FRMPayload),
+		Bytes: f.FRMPayload,
+	}
+}
+
+// block builds the B0 / Ai blocks used for the MIC and the encryption.
+func (f *Frame) block(first byte, last byte) []byte {
+	b := make([]byte, aes.BlockSize)
+	b[0] = first
+	if !f.Uplink() {
+		b[5] = 1
+	}
+	devAddr, _ := hex.DecodeString(f.DevAddr)
+	for i := 0; i < 4; i++ {
+		b[6+i] = devAddr[3-i]
+	}
+	binary.LittleEndian.PutUint32(b[10:14], f.FCnt)
+	b[15] = last
+
+	return b
+}
+
+func (f *Frame) computeMIC(nwkSKey cipher.Block, msg []byte) []byte {
+	b0 := f.block(0x49, byte(len(msg)))
+
+	return cmac(nwkSKey, append(b0, msg...))[:micSize]
+}
+
+func (f *Frame) crypt(key cipher.Block, payload []byte) []byte {
+	out := make([]byte, len(payload))
+	s := make([]byte, aes.BlockSize)
+
+	for i := 0; i < len(payload); i += aes.BlockSize {
+		key.Encrypt(s, f.block(0x01, byte(i/aes.BlockSize+1)))
+		end := i + aes.BlockSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		xorBytes(out[i:end], payload[i:end], s)
+	}
+
+	return out
+}
@@ -0,0 +1,193 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package lorawan
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+const (
+	phyPayload = "40F17DBE4900020001954378762B11FF0D"
+	devAddr    = "49be7df1"
+	nwkSKey    = "44024241ed4ce9a68c6a8bc055233fd3"
+	appSKey    = "ec925802ae430ca77fd3dd73cb2cc588"
+)
+
+func keyTable(t *testing.T) KeyTable {
+	keys, err := NewKeys(nwkSKey, appSKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := KeyTable{}
+	if err := table.Add(devAddr, keys); err != nil {
+		t.Fatal(err)
+	}
+
+	return table
+}
+
+func TestCMAC(t *testing.T) {
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RFC 4493 test vectors
+	tests := []struct {
+		msg string
+		mac string
+	}{
+		{"", "bb1d6929e95937287fa37d129b756746"},
+		{"6bc1bee22e409f96e93d7e117393172a", "070a16b46b4d4144f79bdd9dd04a287c"},
+		{"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411", "dfa66747de9ae63030ca32611497c827"},
+	}
+
+	for _, test := range tests {
+		msg, _ := hex.DecodeString(test.msg)
+		mac := hex.EncodeToString(cmac(block, msg))
+		if mac != test.mac {
+			t.Errorf("wrong cmac for %s: %s != %s", test.msg, mac, test.mac)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	phy, _ := hex.DecodeString(phyPayload)
+
+	frame, err := Decode(phy, keyTable(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if frame.MType != UnconfirmedDataUp {
+		t.Errorf("wrong message type: %d", frame.MType)
+	}
+
+	if frame.DevAddr != devAddr {
+		t.Errorf("wrong device address: %s", frame.DevAddr)
+	}
+
+	if frame.FCnt != 2 {
+		t.Errorf("wrong frame counter: %d", frame.FCnt)
+	}
+
+	if frame.FPort == nil || *frame.FPort != 1 {
+		t.Error("wrong FPort")
+	}
+
+	if !bytes.Equal(frame.FRMPayload, []byte("test")) {
+		t.Errorf("wrong decrypted payload: %x", frame.FRMPayload)
+	}
+
+	if frame.Payload().Size != 4 {
+		t.Error("wrong payload size")
+	}
+}
+
+func TestDecode2(t *testing.T) {
+	phy, _ := hex.DecodeString(phyPayload)
+	phy[len(phy)-1] ^= 0xff
+
+	if _, err := Decode(phy, keyTable(t)); err != InvalidMICError {
+		t.Errorf("tampered frame should give an invalid MIC error, got %v", err)
+	}
+
+	phy, _ = hex.DecodeString(phyPayload)
+
+	if _, err := Decode(phy, KeyTable{}); err != UnknownDeviceError {
+		t.Errorf("unknown device should give an error, got %v", err)
+	}
+
+	if _, err := Decode(phy[:8], keyTable(t)); err == nil {
+		t.Error("truncated frame should give an error")
+	}
+}
+
+// frameWithFCnt encrypts and signs the payload of the test frame again with a
+// frame counter that doesn't fit in the 16 transmitted bits.
+func frameWithFCnt(t *testing.T, fCnt uint32) []byte {
+	phy, _ := hex.DecodeString(phyPayload)
+	keys := keyTable(t)[devAddr]
+
+	frame, err := Parse(phy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame.FCnt = fCnt
+
+	appSKey, _ := aes.NewCipher(keys.AppSKey[:])
+	nwkSKey, _ := aes.NewCipher(keys.NwkSKey[:])
+
+	header := len(phy) - micSize - len(frame.FRMPayload)
+	msg := append([]byte(nil), phy[:header]...)
+	binary.LittleEndian.PutUint16(msg[6:8], uint16(fCnt))
+	msg = append(msg, frame.crypt(appSKey, []byte("test"))...)
+
+	return append(msg, frame.computeMIC(nwkSKey, msg)...)
+}
+
+func TestCounters_Decode(t *testing.T) {
+	counters := NewCounters()
+	keys := keyTable(t)
+
+	tests := []uint32{0xfffe, 0x10001, 0x10005, 0x2ffff, 0x30000, 3}
+	for _, fCnt := range tests {
+		frame, err := counters.Decode(frameWithFCnt(t, fCnt), keys)
+		if err != nil {
+			t.Fatalf("frame counter %d: %v", fCnt, err)
+		}
+
+		if frame.FCnt != fCnt {
+			t.Errorf("wrong frame counter: %d != %d", frame.FCnt, fCnt)
+		}
+
+		if !bytes.Equal(frame.FRMPayload, []byte("test")) {
+			t.Errorf("wrong decrypted payload for frame counter %d: %x", fCnt, frame.FRMPayload)
+		}
+	}
+
+	// without counters only the first rollover is found
+	if frame, err := Decode(frameWithFCnt(t, 0x10001), keys); err != nil || frame.FCnt != 0x10001 {
+		t.Errorf("rolled over frame counter should be verified: %v", err)
+	}
+}
+
+func TestNewKeys(t *testing.T) {
+	if _, err := NewKeys(nwkSKey, appSKey); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewKeys("0011", appSKey); err == nil {
+		t.Error("short key should give an error")
+	}
+
+	if _, err := NewKeys(nwkSKey, "zz"); err == nil {
+		t.Error("invalid hex key should give an error")
+	}
+}
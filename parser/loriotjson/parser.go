@@ -0,0 +1,225 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package loriotjson
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/pkg/errors"
+)
+
+const (
+	Name = "loriot"
+
+	commandRx = "rx"
+	commandGw = "gw"
+)
+
+type loriotParser struct {
+	MetricName    string
+	DefaultTags   map[string]string
+	FieldDecoder  parser.FieldDecoder
+	LocationCodec parser.LocationCodec
+}
+
+type loriotJson struct {
+	Cmd       string    `json:"cmd"`
+	EUI       string    `json:"EUI"`
+	Timestamp int64     `json:"ts"`
+	FCnt      int       `json:"fcnt"`
+	Port      int       `json:"port"`
+	Frequency float64   `json:"freq"`
+	DataRate  string    `json:"dr"`
+	RSSI      float64   `json:"rssi"`
+	SNR       float64   `json:"snr"`
+	Airtime   float64   `json:"toa"`
+	Data      string    `json:"data"`
+	Gateways  []gateway `json:"gws,omitempty"`
+}
+
+type gateway struct {
+	GatewayEUI string  `json:"gweui"`
+	RSSI       float64 `json:"rssi"`
+	SNR        float64 `json:"snr"`
+	Timestamp  int64   `json:"ts"`
+	Latitude   float64 `json:"lat,omitempty"`
+	Longitude  float64 `json:"lon,omitempty"`
+}
+
+func init() {
+	parser.Register(Name, func(metricName string, _ parser.Config) (parser.Parser, error) {
+		return New(metricName)
+	})
+}
+
+func New(name string) (parser.Parser, error) {
+	if len(name) == 0 {
+		return nil, errors.New("[LoriotParser] name cannot be empty")
+	}
+
+	p := loriotParser{
+		MetricName: name,
+	}
+
+	return &p, nil
+}
+
+func (p *loriotParser) Parse(buf []byte) ([]model.Metric, error) {
+	var metrics []model.Metric
+	var message loriotJson
+
+	err := json.Unmarshal(buf, &message)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[LoriotParser] error unmarshalling byte buffer: %s", string(buf))
+	}
+
+	if message.Cmd != commandRx && message.Cmd != commandGw {
+		return nil, errors.Errorf("[LoriotParser] unsupported command: %s", message.Cmd)
+	}
+
+	// rx messages only have the rssi and snr of the best gateway
+	gateways := message.Gateways
+	if message.Cmd == commandRx || len(gateways) == 0 {
+		gateways = []gateway{{RSSI: message.RSSI, SNR: message.SNR}}
+	}
+
+	data, err := hex.DecodeString(message.Data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[LoriotParser] invalid payload: %s", message.Data)
+	}
+	payload := parser.Payload{Size: len(data), Bytes: data}
+
+	raw := parser.Uplink{
+		Device:  message.EUI,
+		Port:    message.Port,
+		Payload: payload,
+	}
+
+	var decoded parser.Decoded
+	if p.MetricName != parser.LocationData {
+		decoded = parser.DecodeFields(p.FieldDecoder, nil, raw)
+	}
+
+	dataRate := normalizeDataRate(message.DataRate)
+	timestamp := time.Unix(0, message.Timestamp*int64(time.Millisecond))
+
+	tags := make(map[string]string, len(p.DefaultTags))
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+
+	tags["device_id"] = message.EUI
+	tags["frequency"] = strconv.FormatFloat(message.Frequency/1000000, 'f', -1, 64)
+	tags["data_rate"] = dataRate
+	if p.MetricName == parser.LocationData {
+		location, err := parser.CoverageLocation(p.LocationCodec, p.FieldDecoder, nil, raw)
+		if err == nil {
+			for k, v := range location.Tags() {
+				tags[k] = v
+			}
+		}
+	}
+
+	for _, g := range gateways {
+		gatewayTags := make(map[string]string, len(tags)+1)
+		for k, v := range tags {
+			gatewayTags[k] = v
+		}
+
+		fields := map[string]interface{}{
+			"size": payload.Size,
+		}
+
+		metric, err := model.NewMetric(p.MetricName, gatewayTags, fields, timestamp)
+		if err != nil {
+			return nil, errors.Wrap(err, "[LoriotParser] error creating metric")
+		}
+
+		rssi := int(math.Round(g.RSSI))
+
+		if p.MetricName == parser.LocationData {
+			metric.AddField("rssi", rssi)
+			metric.AddField("snr", g.SNR)
+
+			if g.Latitude != 0 || g.Longitude != 0 {
+				metric.AddTag("gateway_latitude", strconv.FormatFloat(g.Latitude, 'f', 4, 64))
+				metric.AddTag("gateway_longitude", strconv.FormatFloat(g.Longitude, 'f', 4, 64))
+			}
+		} else {
+			metric.AddTag("rssi", strconv.Itoa(rssi))
+			metric.AddTag("snr", strconv.FormatFloat(g.SNR, 'f', -1, 64))
+			for k, v := range decoded.Fields {
+				metric.AddField(k, v)
+			}
+			for k, v := range decoded.Tags {
+				metric.AddTag(k, v)
+			}
+
+			if p.MetricName == "adr" || p.MetricName == "ddr" {
+				metric.AddField("dr", parser.DataRateIndex(dataRate))
+
+				metric.AddField("airtime", message.Airtime/1000)
+			}
+		}
+
+		// every gateway of a gw message has its own metric, rx messages
+		// don't say which gateway received them
+		if len(g.GatewayEUI) > 0 {
+			metric.AddTag("gateway_id", g.GatewayEUI)
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+func (p *loriotParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+func (p *loriotParser) SetFieldDecoder(decoder parser.FieldDecoder) {
+	p.FieldDecoder = decoder
+}
+
+func (p *loriotParser) SetLocationCodec(codec parser.LocationCodec) {
+	p.LocationCodec = codec
+}
+
+// normalizeDataRate turns the "SF7 BW125 4/5" data rate of Loriot into the
+// "SF7BW125" notation of the other parsers.
+func normalizeDataRate(dataRate string) string {
+	parts := strings.Fields(dataRate)
+	if len(parts) < 2 {
+		return dataRate
+	}
+
+	return parts[0] + parts[1]
+}
@@ -0,0 +1,226 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package loriotjson
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/parser"
+)
+
+const (
+	name          = "test"
+	jsonMessageRx = `{
+  "cmd": "rx",
+  "EUI": "003017737253C1D7",
+  "ts": 1615663282827,
+  "ack": false,
+  "bat": 255,
+  "fcnt": 7,
+  "port": 1,
+  "encdata": "1a2b3c4d5e6f70",
+  "data": "07c84100b82001",
+  "freq": 868300000,
+  "dr": "SF12 BW125 4/5",
+  "rssi": -84,
+  "snr": 8,
+  "toa": 1319
+}`
+	jsonMessageGw = `{
+  "cmd": "gw",
+  "seqno": 2014,
+  "EUI": "003017737253C1D7",
+  "ts": 1615663282827,
+  "fcnt": 7,
+  "port": 1,
+  "freq": 868300000,
+  "toa": 1319,
+  "dr": "SF12 BW125 4/5",
+  "ack": false,
+  "gws": [
+    {
+      "rssi": -84,
+      "snr": 8,
+      "ts": 1615663282827,
+      "time": "2021-03-13T19:21:22.827000Z",
+      "gweui": "008000000000B88D",
+      "ant": 0,
+      "lat": 51.0,
+      "lon": 4.7
+    },
+    {
+      "rssi": -101,
+      "snr": -2.5,
+      "ts": 1615663282830,
+      "time": "2021-03-13T19:21:22.830000Z",
+      "gweui": "008000000000B88E",
+      "ant": 0
+    }
+  ],
+  "bat": 255,
+  "data": "07c84100b82001"
+}`
+	jsonMessageTx = `{
+  "cmd": "tx",
+  "EUI": "003017737253C1D7",
+  "port": 1,
+  "data": "0102"
+}`
+)
+
+func TestNew(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+	if p.(*loriotParser).MetricName != name {
+		t.Error("metric name should be initialized")
+	}
+
+	p, err = New("")
+	if err == nil {
+		t.Error("empty metric name should give an error")
+	}
+}
+
+func TestLoriotParser_Parse(t *testing.T) {
+	p, err := New(parser.LocationData)
+	if err != nil {
+		t.Error(err)
+	}
+
+	metrics, err := p.Parse([]byte(jsonMessageGw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 2 {
+		t.Fatal("should have 2 metrics")
+	}
+
+	metric := metrics[0]
+
+	if !(metric.HasTag("device_id") && metric.HasTag("frequency") && metric.HasTag("data_rate") &&
+		metric.HasTag("power") && metric.HasTag("latitude") && metric.HasTag("longitude") &&
+		metric.HasTag("gateway_id")) {
+		t.Error("missing one or more tags")
+	}
+
+	if !(metric.HasField("size") && metric.HasField("rssi") && metric.HasField("snr")) {
+		t.Error("missing one or more fields")
+	}
+
+	if metric.Tags()["frequency"] != "868.3" || metric.Tags()["data_rate"] != "SF12BW125" {
+		t.Errorf("wrong frequency or data rate: %s %s", metric.Tags()["frequency"], metric.Tags()["data_rate"])
+	}
+
+	if !metric.Time().Equal(time.Unix(1615663282, 827000000)) {
+		t.Errorf("wrong time: %s", metric.Time())
+	}
+
+	if metrics[1].Fields()["rssi"] != -101 || metrics[1].Tags()["gateway_id"] != "008000000000B88E" {
+		t.Error("second metric should belong to the second gateway")
+	}
+
+	expectedGateways := []map[string]string{
+		{"gateway_id": "008000000000B88D", "gateway_latitude": "51.0000", "gateway_longitude": "4.7000"},
+		{"gateway_id": "008000000000B88E"},
+	}
+
+	for i, expected := range expectedGateways {
+		for k, v := range expected {
+			if metrics[i].Tags()[k] != v {
+				t.Errorf("wrong tag %s of gateway %d: %s != %s", k, i, metrics[i].Tags()[k], v)
+			}
+		}
+	}
+
+	if metrics[1].HasTag("gateway_latitude") {
+		t.Error("gateway tags should not be shared between metrics")
+	}
+}
+
+func TestLoriotParser_Parse2(t *testing.T) {
+	p, err := New("adr")
+	if err != nil {
+		t.Error(err)
+	}
+
+	metrics, err := p.Parse([]byte(jsonMessageRx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 {
+		t.Fatal("should have 1 metric")
+	}
+
+	metric := metrics[0]
+
+	if !(metric.HasTag("device_id") && metric.HasTag("frequency") && metric.HasTag("data_rate") &&
+		metric.HasTag("rssi") && metric.HasTag("snr")) {
+		t.Error("missing one or more tags")
+	}
+
+	if metric.HasTag("gateway_id") {
+		t.Error("rx messages don't have a gateway id")
+	}
+
+	if metric.Fields()["dr"] != 0 || metric.Fields()["airtime"] != 1.319 {
+		t.Errorf("wrong dr or airtime: %v %v", metric.Fields()["dr"], metric.Fields()["airtime"])
+	}
+}
+
+func TestLoriotParser_Parse3(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = p.Parse([]byte(jsonMessageTx))
+	if err == nil {
+		t.Error("should not be able to parse a tx message")
+	}
+}
+
+func TestLoriotParser_SetDefaultTags(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	tags := map[string]string{
+		"test": "a",
+	}
+
+	p.SetDefaultTags(tags)
+
+	if v, ok := p.(*loriotParser).DefaultTags["test"]; !ok {
+		t.Error("default tags is missing key 'test'")
+	} else {
+		if v != "a" {
+			t.Error("default tags has wrong value for key 'test'")
+		}
+	}
+}
@@ -33,3 +33,18 @@ type Parser interface {
 	//ParseLine(line string) (model.Metric, error)
 	SetDefaultTags(tags map[string]string)
 }
+
+// TopicParser is implemented by the parsers that need the MQTT topic of a
+// message, e.g. because the device id is only part of the topic.
+type TopicParser interface {
+	ParseTopic(topic string, buf []byte) ([]model.Metric, error)
+}
+
+// ParseTopic parses buf with the topic when p is a TopicParser.
+func ParseTopic(p Parser, topic string, buf []byte) ([]model.Metric, error) {
+	if tp, ok := p.(TopicParser); ok {
+		return tp.ParseTopic(topic, buf)
+	}
+
+	return p.Parse(buf)
+}
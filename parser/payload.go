@@ -59,27 +59,24 @@ func (p *Payload) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// GetLocation returns the latitude and longitude decoded by the default
+// location codec.
 func (p Payload) GetLocation() (float64, float64, error) {
-	if !p.IsValidPayload() {
-		return 0, 0, InvalidPayloadError
+	location, err := p.Location(nil)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	multiplier := float64(10000)
-
-	latitude := float64(uint32(p.Bytes[2])|uint32(p.Bytes[1])<<8|uint32(p.Bytes[0])<<16) / multiplier
-	longitude := float64(uint32(p.Bytes[5])|uint32(p.Bytes[4])<<8|uint32(p.Bytes[3])<<16) / multiplier
-
-	return latitude, longitude, nil
+	return location.Latitude, location.Longitude, nil
 }
 
 func (p Payload) GetPower() (int8, error) {
-	if !p.IsValidPayload() || len(p.Bytes) < 7 {
+	location, err := p.Location(nil)
+	if err != nil || !location.HasPower {
 		return 127, InvalidPayloadError
 	}
 
-	power := int8(p.Bytes[6])
-
-	return power, nil
+	return location.Power, nil
 }
 
 func (p Payload) IsValidPayload() bool {
@@ -0,0 +1,97 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package parser
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Config decodes the configuration block of a parser into v.
+type Config interface {
+	Decode(v interface{}) error
+}
+
+// Constructor creates a parser for the metric name with its configuration.
+type Constructor func(metricName string, config Config) (Parser, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Constructor)
+)
+
+// Register makes a parser available by name. It is meant to be called from
+// the init function of the parser package and panics on duplicate names.
+func Register(name string, constructor Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if constructor == nil {
+		panic("parser: Register constructor is nil")
+	}
+
+	if _, dup := registry[name]; dup {
+		panic("parser: Register called twice for " + name)
+	}
+
+	registry[name] = constructor
+}
+
+// Create returns a new parser of the registered type name.
+func Create(name, metricName string, config Config) (Parser, error) {
+	registryMu.RLock()
+	constructor, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, errors.Errorf("[Parser] unknown parser type: %s", name)
+	}
+
+	if config == nil {
+		config = emptyConfig{}
+	}
+
+	return constructor(metricName, config)
+}
+
+// Names returns the sorted names of the registered parsers.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+type emptyConfig struct{}
+
+func (emptyConfig) Decode(v interface{}) error {
+	return nil
+}
@@ -0,0 +1,319 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package semtechjson
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/bullettime/lora-mqtt/parser/lorawan"
+	"github.com/pkg/errors"
+)
+
+// StatMetricName is the measurement the gateway status reports are written to.
+const StatMetricName = "gateway_stats"
+
+const statTimeLayout = "2006-01-02 15:04:05 MST"
+
+type semtechParser struct {
+	MetricName    string
+	DefaultTags   map[string]string
+	FieldDecoder  parser.FieldDecoder
+	LocationCodec parser.LocationCodec
+	Keys          lorawan.KeyTable
+	Counters      *lorawan.Counters
+}
+
+type semtechJson struct {
+	RXPK []rxpk `json:"rxpk"`
+	Stat *stat  `json:"stat"`
+}
+
+type rxpk struct {
+	Time string  `json:"time"`
+	Tmst uint32  `json:"tmst"`
+	Chan int     `json:"chan"`
+	RFCh int     `json:"rfch"`
+	Freq float64 `json:"freq"`
+	Stat int     `json:"stat"`
+	Modu string  `json:"modu"`
+	DatR datr    `json:"datr"`
+	CodR string  `json:"codr"`
+	RSSI int     `json:"rssi"`
+	LSNR float64 `json:"lsnr"`
+	Size int     `json:"size"`
+	Data string  `json:"data"`
+}
+
+type stat struct {
+	Time string  `json:"time"`
+	Lati float64 `json:"lati"`
+	Long float64 `json:"long"`
+	Alti float64 `json:"alti"`
+	RXNb int     `json:"rxnb"`
+	RXOK int     `json:"rxok"`
+	RXFW int     `json:"rxfw"`
+	ACKR float64 `json:"ackr"`
+	DWNb int     `json:"dwnb"`
+	TXNb int     `json:"txnb"`
+}
+
+// datr is a LoRa data rate string ("SF7BW125") or an FSK bit rate (number).
+type datr string
+
+func (d *datr) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*d = datr(s)
+		return nil
+	}
+
+	*d = datr(string(data))
+	return nil
+}
+
+func New(name string) (parser.Parser, error) {
+	if len(name) == 0 {
+		return nil, errors.New("[SemtechParser] name cannot be empty")
+	}
+
+	p := semtechParser{
+		MetricName: name,
+	}
+
+	return &p, nil
+}
+
+// NewWithKeys creates a parser that decodes the LoRaWAN frames in the
+// received packets, using the session keys to verify and decrypt them.
+func NewWithKeys(name string, keys lorawan.KeyTable) (parser.Parser, error) {
+	if len(name) == 0 {
+		return nil, errors.New("[SemtechParser] name cannot be empty")
+	}
+
+	p := semtechParser{
+		MetricName: name,
+		Keys:       keys,
+		Counters:   lorawan.NewCounters(),
+	}
+
+	return &p, nil
+}
+
+func (p *semtechParser) Parse(buf []byte) ([]model.Metric, error) {
+	var metrics []model.Metric
+	var message semtechJson
+
+	err := json.Unmarshal(buf, &message)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[SemtechParser] error unmarshalling byte buffer: %s", string(buf))
+	}
+
+	if len(message.RXPK) == 0 && message.Stat == nil {
+		return nil, errors.New("[SemtechParser] no rxpk or stat objects")
+	}
+
+	for _, packet := range message.RXPK {
+		// skip packets with a failed CRC, their metadata is unreliable
+		if packet.Stat == -1 {
+			continue
+		}
+
+		metric, err := p.packetMetric(packet)
+		if err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	if message.Stat != nil {
+		metric, err := p.statMetric(*message.Stat)
+		if err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+func (p *semtechParser) packetMetric(packet rxpk) (model.Metric, error) {
+	tags := p.tags()
+	tags["frequency"] = strconv.FormatFloat(packet.Freq, 'f', -1, 64)
+	tags["data_rate"] = string(packet.DatR)
+	tags["modulation"] = packet.Modu
+	if len(packet.CodR) > 0 {
+		tags["coding_rate"] = packet.CodR
+	}
+
+	fields := map[string]interface{}{
+		"size": packet.Size,
+		"rssi": packet.RSSI,
+	}
+
+	if strings.EqualFold(packet.Modu, "LORA") {
+		fields["snr"] = packet.LSNR
+	}
+
+	if p.Keys != nil {
+		p.addFrame(packet, tags, fields)
+	}
+
+	var timestamp time.Time
+	if len(packet.Time) > 0 {
+		var err error
+		timestamp, err = time.Parse(time.RFC3339Nano, packet.Time)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[SemtechParser] invalid rxpk time: %s", packet.Time)
+		}
+	}
+
+	metric, err := model.NewMetric(p.MetricName, tags, fields, timestamp)
+	if err != nil {
+		return nil, errors.Wrap(err, "[SemtechParser] error creating metric")
+	}
+
+	return metric, nil
+}
+
+// addFrame adds the information of the LoRaWAN frame in the packet. Packets
+// that can't be decoded keep their radio metadata only.
+func (p *semtechParser) addFrame(packet rxpk, tags map[string]string, fields map[string]interface{}) {
+	phyPayload, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(packet.Data, "="))
+	if err != nil {
+		log.WithError(err).Debug("[SemtechParser] invalid base64 data")
+		return
+	}
+
+	frame, err := p.Counters.Decode(phyPayload, p.Keys)
+	if frame == nil {
+		log.WithError(err).Debug("[SemtechParser] not a LoRaWAN data frame")
+		return
+	}
+
+	tags["dev_addr"] = frame.DevAddr
+	if err == lorawan.InvalidMICError {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_addr": frame.DevAddr,
+			"f_cnt":    frame.FCnt,
+		}).Warn("[SemtechParser] could not verify frame, wrong session keys or frame counter")
+		return
+	}
+	if err != nil {
+		log.WithError(err).WithField("dev_addr", frame.DevAddr).Debug("[SemtechParser] could not decrypt frame")
+		return
+	}
+
+	fields["f_cnt"] = int(frame.FCnt)
+
+	raw := parser.Uplink{
+		Device:  frame.DevAddr,
+		Payload: frame.Payload(),
+	}
+	if frame.FPort != nil {
+		raw.Port = *frame.FPort
+	}
+
+	if p.MetricName == parser.LocationData {
+		location, err := parser.CoverageLocation(p.LocationCodec, p.FieldDecoder, nil, raw)
+		if err == nil {
+			for k, v := range location.Tags() {
+				tags[k] = v
+			}
+		}
+	} else if raw.Port != 0 {
+		decoded := parser.DecodeFields(p.FieldDecoder, nil, raw)
+
+		for k, v := range decoded.Fields {
+			fields[k] = v
+		}
+		for k, v := range decoded.Tags {
+			tags[k] = v
+		}
+	}
+}
+
+func (p *semtechParser) statMetric(s stat) (model.Metric, error) {
+	tags := p.tags()
+	if s.Lati != 0 || s.Long != 0 {
+		tags["gateway_latitude"] = strconv.FormatFloat(s.Lati, 'f', 4, 64)
+		tags["gateway_longitude"] = strconv.FormatFloat(s.Long, 'f', 4, 64)
+	}
+
+	fields := map[string]interface{}{
+		"rxnb": s.RXNb,
+		"rxok": s.RXOK,
+		"rxfw": s.RXFW,
+		"ackr": s.ACKR,
+		"dwnb": s.DWNb,
+		"txnb": s.TXNb,
+	}
+
+	var timestamp time.Time
+	if len(s.Time) > 0 {
+		var err error
+		timestamp, err = time.Parse(statTimeLayout, s.Time)
+		if err != nil {
+			return nil, errors.Wrapf(err, "[SemtechParser] invalid stat time: %s", s.Time)
+		}
+	}
+
+	metric, err := model.NewMetric(StatMetricName, tags, fields, timestamp)
+	if err != nil {
+		return nil, errors.Wrap(err, "[SemtechParser] error creating metric")
+	}
+
+	return metric, nil
+}
+
+func (p *semtechParser) tags() map[string]string {
+	tags := make(map[string]string, len(p.DefaultTags))
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+
+	return tags
+}
+
+func (p *semtechParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+func (p *semtechParser) SetFieldDecoder(decoder parser.FieldDecoder) {
+	p.FieldDecoder = decoder
+}
+
+func (p *semtechParser) SetLocationCodec(codec parser.LocationCodec) {
+	p.LocationCodec = codec
+}
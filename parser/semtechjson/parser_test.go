@@ -0,0 +1,246 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package semtechjson
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/bullettime/lora-mqtt/parser/lorawan"
+)
+
+const (
+	name        = "test"
+	jsonMessage = `{
+  "rxpk": [
+    {
+      "time": "2013-03-31T16:21:17.528002Z",
+      "tmst": 3512348611,
+      "chan": 2,
+      "rfch": 0,
+      "freq": 866.349812,
+      "stat": 1,
+      "modu": "LORA",
+      "datr": "SF7BW125",
+      "codr": "4/6",
+      "rssi": -35,
+      "lsnr": 5.1,
+      "size": 32,
+      "data": "QAQDAiaAAQABJmgNBXRJRbKeDNLgKOlFNoQwzOiwuyMjJ23h"
+    },
+    {
+      "time": "2013-03-31T16:21:17.530974Z",
+      "tmst": 3512348514,
+      "chan": 9,
+      "rfch": 1,
+      "freq": 869.1,
+      "stat": 1,
+      "modu": "FSK",
+      "datr": 50000,
+      "rssi": -75,
+      "size": 16,
+      "data": "VEVTVF9QQUNLRVRfMTIzNA=="
+    },
+    {
+      "tmst": 3512348515,
+      "freq": 868.1,
+      "stat": -1,
+      "modu": "LORA",
+      "datr": "SF12BW125",
+      "rssi": -120,
+      "lsnr": -15,
+      "size": 0,
+      "data": ""
+    }
+  ],
+  "stat": {
+    "time": "2014-01-12 08:59:28 GMT",
+    "lati": 46.24000,
+    "long": 3.25230,
+    "alti": 145,
+    "rxnb": 2,
+    "rxok": 2,
+    "rxfw": 2,
+    "ackr": 100.0,
+    "dwnb": 2,
+    "txnb": 2
+  }
+}`
+	jsonMessageStat  = `{"stat":{"time":"2014-01-12 08:59:28 GMT","rxnb":0,"rxok":0,"rxfw":0,"ackr":0.0,"dwnb":0,"txnb":0}}`
+	jsonMessageEmpty = `{}`
+)
+
+func TestNew(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+	if p.(*semtechParser).MetricName != name {
+		t.Error("metric name should be initialized")
+	}
+
+	p, err = New("")
+	if err == nil {
+		t.Error("empty metric name should give an error")
+	}
+}
+
+func TestSemtechParser_Parse(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	p.SetDefaultTags(map[string]string{"gateway_id": "b827ebfffe123456"})
+
+	metrics, err := p.Parse([]byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 3 {
+		t.Fatalf("should have 2 packet metrics and 1 stat metric, got %d", len(metrics))
+	}
+
+	metric := metrics[0]
+
+	if metric.Name() != name {
+		t.Errorf("wrong metric name: %s", metric.Name())
+	}
+
+	if !(metric.HasTag("gateway_id") && metric.HasTag("frequency") && metric.HasTag("data_rate") &&
+		metric.HasTag("coding_rate")) {
+		t.Error("missing one or more tags")
+	}
+
+	if !(metric.HasField("size") && metric.HasField("rssi") && metric.HasField("snr")) {
+		t.Error("missing one or more fields")
+	}
+
+	if metrics[1].Tags()["data_rate"] != "50000" {
+		t.Errorf("wrong fsk data rate: %s", metrics[1].Tags()["data_rate"])
+	}
+
+	if metrics[1].HasField("snr") {
+		t.Error("fsk packets should not have a snr field")
+	}
+
+	stat := metrics[2]
+
+	if stat.Name() != StatMetricName {
+		t.Errorf("wrong stat metric name: %s", stat.Name())
+	}
+
+	if !(stat.HasField("rxnb") && stat.HasField("rxok") && stat.HasField("rxfw") && stat.HasField("ackr")) {
+		t.Error("missing one or more stat fields")
+	}
+
+	if !(stat.HasTag("gateway_id") && stat.HasTag("gateway_latitude") && stat.HasTag("gateway_longitude")) {
+		t.Error("missing one or more stat tags")
+	}
+}
+
+func TestSemtechParser_Parse2(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	metrics, err := p.Parse([]byte(jsonMessageStat))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 || metrics[0].Name() != StatMetricName {
+		t.Error("should only have a stat metric")
+	}
+}
+
+func TestSemtechParser_Parse3(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = p.Parse([]byte(jsonMessageEmpty))
+	if err == nil {
+		t.Error("should not be able to parse a message without rxpk or stat")
+	}
+}
+
+// coverageFrame returns an uplink from device 260b1234 with FCnt 1 on FPort 1
+// carrying the location payload 07c84100b82001, encrypted with appSKey.
+func coverageFrame(t *testing.T) (string, lorawan.KeyTable) {
+	const (
+		nwkSKey = "2b7e151628aed2a6abf7158809cf4f3c"
+		appSKey = "000102030405060708090a0b0c0d0e0f"
+		frame   = "4034120b2600010001050abc781b3ce741355558"
+	)
+
+	keys, err := lorawan.NewKeys(nwkSKey, appSKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := lorawan.KeyTable{}
+	if err := table.Add("260b1234", keys); err != nil {
+		t.Fatal(err)
+	}
+
+	phy, _ := hex.DecodeString(frame)
+
+	return base64.StdEncoding.EncodeToString(phy), table
+}
+
+func TestSemtechParser_Parse4(t *testing.T) {
+	data, keys := coverageFrame(t)
+
+	p, err := NewWithKeys(parser.LocationData, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := fmt.Sprintf(`{"rxpk":[{"freq":868.1,"stat":1,"modu":"LORA","datr":"SF7BW125","rssi":-60,"lsnr":9.5,"size":20,"data":"%s"}]}`, data)
+
+	metrics, err := p.Parse([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metric := metrics[0]
+
+	if metric.Tags()["dev_addr"] != "260b1234" {
+		t.Errorf("wrong device address: %s", metric.Tags()["dev_addr"])
+	}
+
+	if metric.Tags()["latitude"] != "51.0017" || metric.Tags()["longitude"] != "4.7136" || metric.Tags()["power"] != "1" {
+		t.Errorf("wrong location tags: %v", metric.Tags())
+	}
+
+	if !metric.HasField("f_cnt") {
+		t.Error("missing frame counter")
+	}
+}
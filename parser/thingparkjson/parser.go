@@ -0,0 +1,233 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package thingparkjson
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/pkg/errors"
+)
+
+const Name = "thingpark"
+
+type thingparkParser struct {
+	MetricName    string
+	DefaultTags   map[string]string
+	FieldDecoder  parser.FieldDecoder
+	LocationCodec parser.LocationCodec
+}
+
+type thingparkJson struct {
+	Uplink *uplink `json:"DevEUI_uplink"`
+}
+
+type uplink struct {
+	Time       time.Time              `json:"Time"`
+	DevEUI     string                 `json:"DevEUI"`
+	FPort      int                    `json:"FPort"`
+	FCntUp     int                    `json:"FCntUp"`
+	PayloadHex hexPayload             `json:"payload_hex"`
+	Payload    map[string]interface{} `json:"payload,omitempty"`
+	Lrrid      string                 `json:"Lrrid"`
+	LrrRSSI    float64                `json:"LrrRSSI"`
+	LrrSNR     float64                `json:"LrrSNR"`
+	LrrLAT     float64                `json:"LrrLAT,omitempty"`
+	LrrLON     float64                `json:"LrrLON,omitempty"`
+	SpFact     int                    `json:"SpFact"`
+	Frequency  float64                `json:"Frequency,omitempty"`
+	Lrrs       lrrs                   `json:"Lrrs"`
+	CustomerID string                 `json:"CustomerID,omitempty"`
+}
+
+type lrrs struct {
+	Lrr []lrr `json:"Lrr"`
+}
+
+type lrr struct {
+	Lrrid   string  `json:"Lrrid"`
+	Chain   int     `json:"Chain"`
+	LrrRSSI float64 `json:"LrrRSSI"`
+	LrrSNR  float64 `json:"LrrSNR"`
+	LrrESP  float64 `json:"LrrESP"`
+}
+
+type hexPayload parser.Payload
+
+func (p *hexPayload) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.Wrap(err, "[Payload] error unmarshalling raw Payload (hex)")
+	}
+
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		return errors.Wrap(err, "[Payload] error unmarshalling raw Payload (hex)")
+	}
+
+	p.Size = len(buf)
+	p.Bytes = buf
+	return nil
+}
+
+func init() {
+	parser.Register(Name, func(metricName string, _ parser.Config) (parser.Parser, error) {
+		return New(metricName)
+	})
+}
+
+func New(name string) (parser.Parser, error) {
+	if len(name) == 0 {
+		return nil, errors.New("[ThingParkParser] name cannot be empty")
+	}
+
+	p := thingparkParser{
+		MetricName: name,
+	}
+
+	return &p, nil
+}
+
+func (p *thingparkParser) Parse(buf []byte) ([]model.Metric, error) {
+	var metrics []model.Metric
+	var message thingparkJson
+
+	err := json.Unmarshal(buf, &message)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[ThingParkParser] error unmarshalling byte buffer: %s", string(buf))
+	}
+
+	if message.Uplink == nil {
+		return nil, errors.New("[ThingParkParser] not an uplink (DevEUI_uplink missing)")
+	}
+
+	u := message.Uplink
+	payload := parser.Payload(u.PayloadHex)
+
+	// older ThingPark versions only report the best LRR
+	gateways := u.Lrrs.Lrr
+	if len(gateways) == 0 {
+		if len(u.Lrrid) == 0 {
+			return nil, errors.New("[ThingParkParser] wrong number of gateways (0)")
+		}
+		gateways = []lrr{{Lrrid: u.Lrrid, LrrRSSI: u.LrrRSSI, LrrSNR: u.LrrSNR}}
+	}
+
+	raw := parser.Uplink{
+		Application: u.CustomerID,
+		Device:      u.DevEUI,
+		Port:        u.FPort,
+		Payload:     payload,
+	}
+
+	var decoded parser.Decoded
+	if p.MetricName != parser.LocationData {
+		decoded = parser.DecodeFields(p.FieldDecoder, u.Payload, raw)
+	}
+
+	// ThingPark doesn't report the bandwidth, EU868 uplinks use 125 kHz
+	dataRate := parser.DataRate(u.SpFact, 125)
+
+	tags := make(map[string]string, len(p.DefaultTags))
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+
+	tags["device_id"] = u.DevEUI
+	if u.Frequency != 0 {
+		tags["frequency"] = strconv.FormatFloat(u.Frequency, 'f', -1, 64)
+	}
+	tags["data_rate"] = dataRate
+	if p.MetricName == parser.LocationData {
+		location, err := parser.CoverageLocation(p.LocationCodec, p.FieldDecoder, u.Payload, raw)
+		if err == nil {
+			for k, v := range location.Tags() {
+				tags[k] = v
+			}
+		}
+	}
+
+	for _, g := range gateways {
+		gatewayTags := make(map[string]string, len(tags)+1)
+		for k, v := range tags {
+			gatewayTags[k] = v
+		}
+
+		fields := map[string]interface{}{
+			"size": payload.Size,
+		}
+
+		metric, err := model.NewMetric(p.MetricName, gatewayTags, fields, u.Time)
+		if err != nil {
+			return nil, errors.Wrap(err, "[ThingParkParser] error creating metric")
+		}
+
+		rssi := int(math.Round(g.LrrRSSI))
+
+		if p.MetricName == parser.LocationData {
+			metric.AddField("rssi", rssi)
+			metric.AddField("snr", g.LrrSNR)
+
+			if g.Lrrid == u.Lrrid && (u.LrrLAT != 0 || u.LrrLON != 0) {
+				metric.AddTag("gateway_latitude", strconv.FormatFloat(u.LrrLAT, 'f', 4, 64))
+				metric.AddTag("gateway_longitude", strconv.FormatFloat(u.LrrLON, 'f', 4, 64))
+			}
+		} else {
+			metric.AddTag("rssi", strconv.Itoa(rssi))
+			metric.AddTag("snr", strconv.FormatFloat(g.LrrSNR, 'f', -1, 64))
+			for k, v := range decoded.Fields {
+				metric.AddField(k, v)
+			}
+			for k, v := range decoded.Tags {
+				metric.AddTag(k, v)
+			}
+
+			if p.MetricName == "adr" || p.MetricName == "ddr" {
+				metric.AddField("dr", parser.DataRateIndex(dataRate))
+			}
+		}
+
+		metric.AddTag("gateway_id", g.Lrrid)
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+func (p *thingparkParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+func (p *thingparkParser) SetFieldDecoder(decoder parser.FieldDecoder) {
+	p.FieldDecoder = decoder
+}
+
+func (p *thingparkParser) SetLocationCodec(codec parser.LocationCodec) {
+	p.LocationCodec = codec
+}
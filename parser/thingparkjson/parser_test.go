@@ -0,0 +1,208 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package thingparkjson
+
+import (
+	"testing"
+
+	"github.com/bullettime/lora-mqtt/parser"
+)
+
+const (
+	name        = "test"
+	jsonMessage = `{
+  "DevEUI_uplink": {
+    "Time": "2021-03-13T20:21:22.827+01:00",
+    "DevEUI": "003017737253C1D7",
+    "FPort": 1,
+    "FCntUp": 7,
+    "ADRbit": 1,
+    "MType": 2,
+    "FCntDn": 3,
+    "payload_hex": "07c84100b82001",
+    "mic_hex": "3a4b5c6d",
+    "Lrcid": "00000201",
+    "LrrRSSI": -84.0,
+    "LrrSNR": 8.0,
+    "SpFact": 12,
+    "SubBand": "G1",
+    "Channel": "LC2",
+    "DevLrrCnt": 2,
+    "Lrrid": "08050BD2",
+    "Late": 0,
+    "LrrLAT": 51.0,
+    "LrrLON": 4.7,
+    "Lrrs": {
+      "Lrr": [
+        {
+          "Lrrid": "08050BD2",
+          "Chain": 0,
+          "LrrRSSI": -84.0,
+          "LrrSNR": 8.0,
+          "LrrESP": -84.64
+        },
+        {
+          "Lrrid": "08050BD3",
+          "Chain": 0,
+          "LrrRSSI": -101.0,
+          "LrrSNR": -2.5,
+          "LrrESP": -104.57
+        }
+      ]
+    },
+    "CustomerID": "100000507",
+    "CustomerData": {
+      "alr": {
+        "pro": "SODAQ/ONE",
+        "ver": "1"
+      }
+    },
+    "ModelCfg": "0",
+    "DevAddr": "260B1234",
+    "Frequency": 868.3,
+    "payload": {
+      "lat": 51.0017,
+      "lon": 4.7136,
+      "pwr": 1
+    }
+  }
+}`
+	jsonMessageDownlinkSent = `{
+  "DevEUI_downlink_Sent": {
+    "Time": "2021-03-13T20:21:22.827+01:00",
+    "DevEUI": "003017737253C1D7"
+  }
+}`
+)
+
+func TestNew(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+	if p.(*thingparkParser).MetricName != name {
+		t.Error("metric name should be initialized")
+	}
+
+	p, err = New("")
+	if err == nil {
+		t.Error("empty metric name should give an error")
+	}
+}
+
+func TestThingparkParser_Parse(t *testing.T) {
+	p, err := New(parser.LocationData)
+	if err != nil {
+		t.Error(err)
+	}
+
+	metrics, err := p.Parse([]byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 2 {
+		t.Fatal("should have 2 metrics")
+	}
+
+	metric := metrics[0]
+
+	if !(metric.HasTag("device_id") && metric.HasTag("frequency") && metric.HasTag("data_rate") &&
+		metric.HasTag("power") && metric.HasTag("latitude") && metric.HasTag("longitude") &&
+		metric.HasTag("gateway_id") && metric.HasTag("gateway_latitude") && metric.HasTag("gateway_longitude")) {
+		t.Error("missing one or more tags")
+	}
+
+	if !(metric.HasField("size") && metric.HasField("rssi") && metric.HasField("snr")) {
+		t.Error("missing one or more fields")
+	}
+
+	if metric.Tags()["frequency"] != "868.3" || metric.Tags()["data_rate"] != "SF12BW125" {
+		t.Errorf("wrong frequency or data rate: %s %s", metric.Tags()["frequency"], metric.Tags()["data_rate"])
+	}
+
+	if metrics[1].Fields()["rssi"] != -101 || metrics[1].Tags()["gateway_id"] != "08050BD3" {
+		t.Error("second metric should belong to the second gateway")
+	}
+
+	if metrics[1].HasTag("gateway_latitude") {
+		t.Error("only the best gateway has a location")
+	}
+}
+
+func TestThingparkParser_Parse2(t *testing.T) {
+	p, err := New("adr")
+	if err != nil {
+		t.Error(err)
+	}
+
+	metrics, err := p.Parse([]byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metric := metrics[0]
+
+	if !(metric.HasTag("device_id") && metric.HasTag("frequency") && metric.HasTag("data_rate") &&
+		metric.HasTag("rssi") && metric.HasTag("snr") && metric.HasTag("gateway_id")) {
+		t.Error("missing one or more tags")
+	}
+
+	if !(metric.HasField("size") && metric.HasField("lat") && metric.HasField("lon") &&
+		metric.HasField("pwr") && metric.HasField("dr")) {
+		t.Error("missing one or more fields")
+	}
+}
+
+func TestThingparkParser_Parse3(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = p.Parse([]byte(jsonMessageDownlinkSent))
+	if err == nil {
+		t.Error("should not be able to parse a message that isn't an uplink")
+	}
+}
+
+func TestThingparkParser_SetDefaultTags(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	tags := map[string]string{
+		"test": "a",
+	}
+
+	p.SetDefaultTags(tags)
+
+	if v, ok := p.(*thingparkParser).DefaultTags["test"]; !ok {
+		t.Error("default tags is missing key 'test'")
+	} else {
+		if v != "a" {
+			t.Error("default tags has wrong value for key 'test'")
+		}
+	}
+}
@@ -32,9 +32,13 @@ import (
 	"github.com/pkg/errors"
 )
 
+const Name = "ttn"
+
 type ttnParser struct {
-	MetricName  string
-	DefaultTags map[string]string
+	MetricName    string
+	DefaultTags   map[string]string
+	FieldDecoder  parser.FieldDecoder
+	LocationCodec parser.LocationCodec
 }
 
 type ttnJson struct {
@@ -77,6 +81,12 @@ type gateway struct {
 	Altitude  float64   `json:"altitude,omitempty"`
 }
 
+func init() {
+	parser.Register(Name, func(metricName string, _ parser.Config) (parser.Parser, error) {
+		return New(metricName)
+	})
+}
+
 func New(name string) (parser.Parser, error) {
 	if len(name) == 0 {
 		return nil, errors.New("[TTNParser] name cannot be empty")
@@ -102,6 +112,18 @@ func (p *ttnParser) Parse(buf []byte) ([]model.Metric, error) {
 		return nil, errors.New("[TTNParser] wrong number of gateways (0)")
 	}
 
+	raw := parser.Uplink{
+		Application: message.AppID,
+		Device:      message.DevID,
+		Port:        message.Port,
+		Payload:     message.PayloadRaw,
+	}
+
+	var decoded parser.Decoded
+	if p.MetricName != parser.LocationData {
+		decoded = parser.DecodeFields(p.FieldDecoder, message.PayloadFields, raw)
+	}
+
 	tags := make(map[string]string, len(p.DefaultTags))
 	for k, v := range p.DefaultTags {
 		tags[k] = v
@@ -111,14 +133,11 @@ func (p *ttnParser) Parse(buf []byte) ([]model.Metric, error) {
 	tags["frequency"] = strconv.FormatFloat(message.Metadata.Frequency, 'f', -1, 64)
 	tags["data_rate"] = message.Metadata.DataRate
 	if p.MetricName == parser.LocationData {
-		power, err := message.PayloadRaw.GetPower()
+		location, err := parser.CoverageLocation(p.LocationCodec, p.FieldDecoder, message.PayloadFields, raw)
 		if err == nil {
-			tags["power"] = strconv.Itoa(int(power))
-		}
-		lat, lon, err := message.PayloadRaw.GetLocation()
-		if err == nil {
-			tags["latitude"] = strconv.FormatFloat(lat, 'f', 4, 64)
-			tags["longitude"] = strconv.FormatFloat(lon, 'f', 4, 64)
+			for k, v := range location.Tags() {
+				tags[k] = v
+			}
 		}
 	}
 
@@ -126,11 +145,14 @@ func (p *ttnParser) Parse(buf []byte) ([]model.Metric, error) {
 		"size": message.PayloadRaw.Size,
 	}
 
+	// every gateway changes its own copy of the metric of the uplink
+	uplink, err := model.NewMetric(p.MetricName, tags, fields, message.Metadata.Time)
+	if err != nil {
+		return nil, errors.Wrap(err, "[TTNParser] error creating metric")
+	}
+
 	for _, g := range message.Metadata.Gateways {
-		metric, err := model.NewMetric(p.MetricName, tags, fields, message.Metadata.Time)
-		if err != nil {
-			return nil, errors.Wrap(err, "[TTNParser] error creating metric")
-		}
+		metric := uplink.Copy()
 
 		if p.MetricName == parser.LocationData {
 			metric.AddField("rssi", g.RSSI)
@@ -138,29 +160,15 @@ func (p *ttnParser) Parse(buf []byte) ([]model.Metric, error) {
 		} else {
 			metric.AddTag("rssi", strconv.Itoa(g.RSSI))
 			metric.AddTag("snr", strconv.FormatFloat(g.SNR, 'f', -1, 64))
-			for k, v := range message.PayloadFields {
+			for k, v := range decoded.Fields {
 				metric.AddField(k, v)
 			}
+			for k, v := range decoded.Tags {
+				metric.AddTag(k, v)
+			}
 
 			if p.MetricName == "adr" || p.MetricName == "ddr" {
-				dr := 0
-
-				switch message.Metadata.DataRate {
-				case "SF7BW125":
-					dr = 5
-				case "SF8BW125":
-					dr = 4
-				case "SF9BW125":
-					dr = 3
-				case "SF10BW125":
-					dr = 2
-				case "SF11BW125":
-					dr = 1
-				case "SF12BW125":
-					dr = 0
-				}
-
-				metric.AddField("dr", dr)
+				metric.AddField("dr", parser.DataRateIndex(message.Metadata.DataRate))
 
 				metric.AddField("airtime", message.Metadata.Airtime.Seconds())
 			}
@@ -177,3 +185,11 @@ func (p *ttnParser) Parse(buf []byte) ([]model.Metric, error) {
 func (p *ttnParser) SetDefaultTags(tags map[string]string) {
 	p.DefaultTags = tags
 }
+
+func (p *ttnParser) SetFieldDecoder(decoder parser.FieldDecoder) {
+	p.FieldDecoder = decoder
+}
+
+func (p *ttnParser) SetLocationCodec(codec parser.LocationCodec) {
+	p.LocationCodec = codec
+}
@@ -23,8 +23,13 @@
 package ttnjson
 
 import (
-	"github.com/bullettime/lora-mqtt/parser"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/bullettime/lora-mqtt/parser/cayennelpp"
+	"github.com/bullettime/lora-mqtt/parser/layout"
 )
 
 const (
@@ -81,6 +86,22 @@ const (
 	"data_rate": "SF12BW125",
 	"coding_rate": "4/5",
   }
+}`
+	jsonMessageGateways = `{
+  "app_id": "lora_coverage_mapping",
+  "dev_id": "sodaq_one_gps_1",
+  "port": 1,
+  "payload_raw": "B8hBALggAQ==",
+  "metadata": {
+    "time": "2018-03-13T19:21:22.827671626Z",
+    "frequency": 868.3,
+    "data_rate": "SF12BW125",
+    "gateways": [
+      {"gtw_id": "gw-1", "rssi": -84, "snr": 8},
+      {"gtw_id": "gw-2", "rssi": -97, "snr": 1.5},
+      {"gtw_id": "gw-3", "rssi": -112, "snr": -7.25}
+    ]
+  }
 }`
 )
 
@@ -167,6 +188,155 @@ func TestTtnParser_Parse3(t *testing.T) {
 	}
 }
 
+func TestTtnParser_SetFieldDecoder(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	p.(parser.FieldDecoderSetter).SetFieldDecoder(cayennelpp.New())
+
+	// temperature_3 = 27.2 in cayenne lpp, without payload_fields
+	message := strings.Replace(jsonMessage, `"payload_raw": "B8hBALggAQ==",`, `"payload_raw": "A2cBEA==",`, 1)
+	message = strings.Replace(message, `"payload_fields"`, `"unused_fields"`, 1)
+
+	metrics, err := p.Parse([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics[0].Fields()["temperature_3"] != 27.2 {
+		t.Errorf("payload should be decoded with the field decoder: %v", metrics[0].Fields())
+	}
+
+	// fields decoded by the network server take precedence
+	metrics, err = p.Parse([]byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !metrics[0].HasField("lat") || metrics[0].HasField("temperature_3") {
+		t.Error("payload_fields should be used when present")
+	}
+}
+
+func TestTtnParser_SetFieldDecoderCoverage(t *testing.T) {
+	p, err := New(parser.LocationData)
+	if err != nil {
+		t.Error(err)
+	}
+
+	p.(parser.FieldDecoderSetter).SetFieldDecoder(cayennelpp.New())
+
+	// the coverage payload isn't valid cayenne lpp
+	message := strings.Replace(jsonMessage, `"payload_fields"`, `"unused_fields"`, 1)
+
+	metrics, err := p.Parse([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 || !metrics[0].HasTag("latitude") || !metrics[0].HasField("rssi") {
+		t.Errorf("coverage uplinks should not be decoded: %v", metrics)
+	}
+}
+
+func TestTtnParser_SetFieldDecoderLocation(t *testing.T) {
+	p, err := New(parser.LocationData)
+	if err != nil {
+		t.Error(err)
+	}
+
+	d, err := layout.New([]layout.Layout{{
+		Name: "gps",
+		Fields: []layout.Field{
+			{Name: "lat", Offset: 0, Length: 4, Signed: true, Scale: 1e-7},
+			{Name: "lon", Offset: 4, Length: 4, Signed: true, Scale: 1e-7},
+			{Name: "pwr", Offset: 8, Length: 1, Signed: true},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.(parser.FieldDecoderSetter).SetFieldDecoder(d)
+
+	// 51.0017, -4.7136 and 14 dBm, which isn't an int24 coverage payload
+	message := strings.Replace(jsonMessage, `"payload_raw": "B8hBALggAQ==",`, `"payload_raw": "HmY96P0wwwAO",`, 1)
+	message = strings.Replace(message, `"payload_fields"`, `"unused_fields"`, 1)
+
+	metrics, err := p.Parse([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := metrics[0].Tags()
+	if tags["latitude"] != "51.0017" || tags["longitude"] != "-4.7136" || tags["power"] != "14" {
+		t.Errorf("coverage location should be decoded with the field decoder: %v", tags)
+	}
+}
+
+func TestTtnParser_SetLocationCodec(t *testing.T) {
+	p, err := New(parser.LocationData)
+	if err != nil {
+		t.Error(err)
+	}
+
+	codec, err := parser.GetLocationCodec("int32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.(parser.LocationCodecSetter).SetLocationCodec(codec)
+
+	// 51.0017, -4.7136 and 14 dBm as int32 coverage payload
+	message := strings.Replace(jsonMessage, `"payload_raw": "B8hBALggAQ==",`, `"payload_raw": "HmY96P0wwwAO",`, 1)
+	message = strings.Replace(message, `"payload_fields"`, `"unused_fields"`, 1)
+
+	metrics, err := p.Parse([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := metrics[0].Tags()
+	if tags["latitude"] != "51.0017000" || tags["longitude"] != "-4.7136000" || tags["power"] != "14" {
+		t.Errorf("coverage location should be decoded with the int32 codec: %v", tags)
+	}
+
+	// the codec of one parser doesn't change the others
+	other, err := New(parser.LocationData)
+	if err != nil {
+		t.Error(err)
+	}
+
+	metrics, err = other.Parse([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := metrics[0].Tags()["latitude"]; ok {
+		t.Errorf("int32 payload should not be decoded by the default codec: %v", metrics[0].Tags())
+	}
+}
+
+func TestTtnParser_SetFieldDecoderError(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	p.(parser.FieldDecoderSetter).SetFieldDecoder(cayennelpp.New())
+
+	message := strings.Replace(jsonMessage, `"payload_fields"`, `"unused_fields"`, 1)
+
+	metrics, err := p.Parse([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 || !metrics[0].HasTag("gateway_id") || !metrics[0].HasField("size") {
+		t.Errorf("gateway metrics should be kept when decoding fails: %v", metrics)
+	}
+}
+
 func TestTtnParser_SetDefaultTags(t *testing.T) {
 	p, err := New(name)
 	if err != nil {
@@ -187,3 +357,54 @@ func TestTtnParser_SetDefaultTags(t *testing.T) {
 		}
 	}
 }
+
+func TestTtnParser_ParseGateways(t *testing.T) {
+	expected := []struct {
+		gateway string
+		rssi    int
+		snr     float64
+	}{
+		{"gw-1", -84, 8},
+		{"gw-2", -97, 1.5},
+		{"gw-3", -112, -7.25},
+	}
+
+	p, err := New(parser.LocationData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, err := p.Parse([]byte(jsonMessageGateways))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != len(expected) {
+		t.Fatalf("should have %d metrics", len(expected))
+	}
+
+	for i, e := range expected {
+		if metrics[i].Tags()["gateway_id"] != e.gateway {
+			t.Errorf("metric %d has gateway %s instead of %s", i, metrics[i].Tags()["gateway_id"], e.gateway)
+		}
+		if metrics[i].Fields()["rssi"] != e.rssi || metrics[i].Fields()["snr"] != e.snr {
+			t.Errorf("metric %d has the signal of another gateway: %v", i, metrics[i].Fields())
+		}
+	}
+
+	p, err = New("adr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, err = p.Parse([]byte(jsonMessageGateways))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, e := range expected {
+		if metrics[i].Tags()["gateway_id"] != e.gateway || metrics[i].Tags()["rssi"] != strconv.Itoa(e.rssi) {
+			t.Errorf("metric %d has the tags of another gateway: %v", i, metrics[i].Tags())
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ttsjson
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/pkg/errors"
+)
+
+const Name = "tts"
+
+type ttsParser struct {
+	MetricName    string
+	DefaultTags   map[string]string
+	FieldDecoder  parser.FieldDecoder
+	LocationCodec parser.LocationCodec
+}
+
+type ttsJson struct {
+	EndDeviceIDs  endDeviceIDs  `json:"end_device_ids"`
+	ReceivedAt    time.Time     `json:"received_at"`
+	UplinkMessage uplinkMessage `json:"uplink_message"`
+}
+
+type endDeviceIDs struct {
+	DeviceID       string         `json:"device_id"`
+	ApplicationIDs applicationIDs `json:"application_ids"`
+	DevEUI         string         `json:"dev_eui,omitempty"`
+	DevAddr        string         `json:"dev_addr,omitempty"`
+}
+
+type applicationIDs struct {
+	ApplicationID string `json:"application_id"`
+}
+
+type uplinkMessage struct {
+	FPort           int                    `json:"f_port"`
+	FCnt            int                    `json:"f_cnt"`
+	FrmPayload      parser.Payload         `json:"frm_payload"`
+	DecodedPayload  map[string]interface{} `json:"decoded_payload,omitempty"`
+	RxMetadata      []rxMetadata           `json:"rx_metadata"`
+	Settings        settings               `json:"settings"`
+	ReceivedAt      time.Time              `json:"received_at"`
+	ConsumedAirtime string                 `json:"consumed_airtime,omitempty"`
+}
+
+type rxMetadata struct {
+	GatewayIDs  gatewayIDs `json:"gateway_ids"`
+	Time        time.Time  `json:"time"`
+	Timestamp   uint64     `json:"timestamp"`
+	RSSI        float64    `json:"rssi"`
+	ChannelRSSI float64    `json:"channel_rssi"`
+	SNR         float64    `json:"snr"`
+	Location    *location  `json:"location,omitempty"`
+}
+
+type gatewayIDs struct {
+	GatewayID string `json:"gateway_id"`
+	EUI       string `json:"eui,omitempty"`
+}
+
+type location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+	Source    string  `json:"source,omitempty"`
+}
+
+type settings struct {
+	DataRate   dataRate  `json:"data_rate"`
+	CodingRate string    `json:"coding_rate"`
+	Frequency  string    `json:"frequency"`
+	Time       time.Time `json:"time"`
+}
+
+type dataRate struct {
+	LoRa loraDataRate `json:"lora"`
+}
+
+type loraDataRate struct {
+	Bandwidth       int    `json:"bandwidth"`
+	SpreadingFactor int    `json:"spreading_factor"`
+	CodingRate      string `json:"coding_rate,omitempty"`
+}
+
+func init() {
+	parser.Register(Name, func(metricName string, _ parser.Config) (parser.Parser, error) {
+		return New(metricName)
+	})
+}
+
+func New(name string) (parser.Parser, error) {
+	if len(name) == 0 {
+		return nil, errors.New("[TTSParser] name cannot be empty")
+	}
+
+	p := ttsParser{
+		MetricName: name,
+	}
+
+	return &p, nil
+}
+
+func (p *ttsParser) Parse(buf []byte) ([]model.Metric, error) {
+	var metrics []model.Metric
+	var message ttsJson
+
+	err := json.Unmarshal(buf, &message)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[TTSParser] error unmarshalling byte buffer: %s", string(buf))
+	}
+
+	uplink := message.UplinkMessage
+
+	if len(uplink.RxMetadata) == 0 {
+		return nil, errors.New("[TTSParser] wrong number of gateways (0)")
+	}
+
+	frequency, err := strconv.ParseFloat(uplink.Settings.Frequency, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[TTSParser] invalid frequency: %s", uplink.Settings.Frequency)
+	}
+
+	dataRate := parser.DataRate(uplink.Settings.DataRate.LoRa.SpreadingFactor, uplink.Settings.DataRate.LoRa.Bandwidth/1000)
+
+	timestamp := uplink.ReceivedAt
+	if timestamp.IsZero() {
+		timestamp = message.ReceivedAt
+	}
+
+	raw := parser.Uplink{
+		Application: message.EndDeviceIDs.ApplicationIDs.ApplicationID,
+		Device:      message.EndDeviceIDs.DeviceID,
+		Port:        uplink.FPort,
+		Payload:     uplink.FrmPayload,
+	}
+
+	var decoded parser.Decoded
+	if p.MetricName != parser.LocationData {
+		decoded = parser.DecodeFields(p.FieldDecoder, uplink.DecodedPayload, raw)
+	}
+
+	tags := make(map[string]string, len(p.DefaultTags))
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+
+	tags["device_id"] = message.EndDeviceIDs.DeviceID
+	tags["frequency"] = strconv.FormatFloat(frequency/1000000, 'f', -1, 64)
+	tags["data_rate"] = dataRate
+	if p.MetricName == parser.LocationData {
+		location, err := parser.CoverageLocation(p.LocationCodec, p.FieldDecoder, uplink.DecodedPayload, raw)
+		if err == nil {
+			for k, v := range location.Tags() {
+				tags[k] = v
+			}
+		}
+	}
+
+	for _, g := range uplink.RxMetadata {
+		gatewayTags := make(map[string]string, len(tags)+1)
+		for k, v := range tags {
+			gatewayTags[k] = v
+		}
+
+		fields := map[string]interface{}{
+			"size": uplink.FrmPayload.Size,
+		}
+
+		metric, err := model.NewMetric(p.MetricName, gatewayTags, fields, timestamp)
+		if err != nil {
+			return nil, errors.Wrap(err, "[TTSParser] error creating metric")
+		}
+
+		// TTS reports RSSI as a float, the v2 parser writes it as an integer
+		rssi := int(math.Round(g.RSSI))
+		channelRSSI := int(math.Round(g.ChannelRSSI))
+		if rssi == 0 {
+			rssi = channelRSSI
+		}
+
+		if p.MetricName == parser.LocationData {
+			metric.AddField("rssi", rssi)
+			metric.AddField("channel_rssi", channelRSSI)
+			metric.AddField("snr", g.SNR)
+
+			if g.Location != nil {
+				metric.AddTag("gateway_latitude", strconv.FormatFloat(g.Location.Latitude, 'f', 4, 64))
+				metric.AddTag("gateway_longitude", strconv.FormatFloat(g.Location.Longitude, 'f', 4, 64))
+			}
+		} else {
+			metric.AddTag("rssi", strconv.Itoa(rssi))
+			metric.AddTag("snr", strconv.FormatFloat(g.SNR, 'f', -1, 64))
+			for k, v := range decoded.Fields {
+				metric.AddField(k, v)
+			}
+			for k, v := range decoded.Tags {
+				metric.AddTag(k, v)
+			}
+
+			if p.MetricName == "adr" || p.MetricName == "ddr" {
+				metric.AddField("dr", parser.DataRateIndex(dataRate))
+
+				if airtime, err := time.ParseDuration(uplink.ConsumedAirtime); err == nil {
+					metric.AddField("airtime", airtime.Seconds())
+				}
+			}
+		}
+
+		metric.AddTag("gateway_id", g.GatewayIDs.GatewayID)
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+func (p *ttsParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+func (p *ttsParser) SetFieldDecoder(decoder parser.FieldDecoder) {
+	p.FieldDecoder = decoder
+}
+
+func (p *ttsParser) SetLocationCodec(codec parser.LocationCodec) {
+	p.LocationCodec = codec
+}
@@ -0,0 +1,230 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ttsjson
+
+import (
+	"testing"
+
+	"github.com/bullettime/lora-mqtt/parser"
+)
+
+const (
+	name        = "test"
+	jsonMessage = `{
+  "end_device_ids": {
+    "device_id": "sodaq_one_gps_1",
+    "application_ids": {
+      "application_id": "lora_coverage_mapping"
+    },
+    "dev_eui": "003017737253C1D7",
+    "dev_addr": "260B1234"
+  },
+  "received_at": "2021-03-13T19:21:22.827671626Z",
+  "uplink_message": {
+    "f_port": 1,
+    "f_cnt": 7,
+    "frm_payload": "B8hBALggAQ==",
+    "decoded_payload": {
+      "lat": 51.0017,
+      "lon": 4.7136,
+      "pwr": 1
+    },
+    "rx_metadata": [
+      {
+        "gateway_ids": {
+          "gateway_id": "eui-008000000000b88d",
+          "eui": "008000000000B88D"
+        },
+        "time": "2021-03-13T19:21:22.671066Z",
+        "timestamp": 3239248428,
+        "rssi": -84,
+        "channel_rssi": -84,
+        "snr": 8,
+        "location": {
+          "latitude": 51.0,
+          "longitude": 4.7,
+          "altitude": 10,
+          "source": "SOURCE_REGISTRY"
+        }
+      },
+      {
+        "gateway_ids": {
+          "gateway_id": "eui-008000000000b88e"
+        },
+        "timestamp": 3239248430,
+        "channel_rssi": -101,
+        "snr": -2.5
+      }
+    ],
+    "settings": {
+      "data_rate": {
+        "lora": {
+          "bandwidth": 125000,
+          "spreading_factor": 12
+        }
+      },
+      "coding_rate": "4/5",
+      "frequency": "868300000"
+    },
+    "received_at": "2021-03-13T19:21:22.827671626Z",
+    "consumed_airtime": "1.318912s"
+  }
+}`
+	jsonMessageNoGateways = `{
+  "end_device_ids": {
+    "device_id": "sodaq_one_gps_1",
+    "application_ids": {
+      "application_id": "lora_coverage_mapping"
+    }
+  },
+  "uplink_message": {
+    "f_port": 1,
+    "frm_payload": "B8hBALggAQ==",
+    "settings": {
+      "data_rate": {
+        "lora": {
+          "bandwidth": 125000,
+          "spreading_factor": 12
+        }
+      },
+      "frequency": "868300000"
+    }
+  }
+}`
+)
+
+func TestNew(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+	if p.(*ttsParser).MetricName != name {
+		t.Error("metric name should be initialized")
+	}
+
+	p, err = New("")
+	if err == nil {
+		t.Error("empty metric name should give an error")
+	}
+}
+
+func TestTtsParser_Parse(t *testing.T) {
+	p, err := New(parser.LocationData)
+	if err != nil {
+		t.Error(err)
+	}
+
+	metrics, err := p.Parse([]byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 2 {
+		t.Fatal("should have 2 metrics")
+	}
+
+	metric := metrics[0]
+
+	if !(metric.HasTag("device_id") && metric.HasTag("frequency") && metric.HasTag("data_rate") &&
+		metric.HasTag("power") && metric.HasTag("latitude") && metric.HasTag("longitude") &&
+		metric.HasTag("gateway_id") && metric.HasTag("gateway_latitude") && metric.HasTag("gateway_longitude")) {
+		t.Error("missing one or more tags")
+	}
+
+	if !(metric.HasField("size") && metric.HasField("rssi") && metric.HasField("snr")) {
+		t.Error("missing one or more fields")
+	}
+
+	if metric.Tags()["frequency"] != "868.3" {
+		t.Errorf("wrong frequency: %s", metric.Tags()["frequency"])
+	}
+
+	if metric.Tags()["data_rate"] != "SF12BW125" {
+		t.Errorf("wrong data rate: %s", metric.Tags()["data_rate"])
+	}
+
+	if metrics[1].Fields()["rssi"] != -101 {
+		t.Error("rssi should fall back to the channel rssi")
+	}
+
+	if metrics[1].Tags()["gateway_id"] != "eui-008000000000b88e" {
+		t.Error("second metric should belong to the second gateway")
+	}
+}
+
+func TestTtsParser_Parse2(t *testing.T) {
+	p, err := New("adr")
+	if err != nil {
+		t.Error(err)
+	}
+
+	metrics, err := p.Parse([]byte(jsonMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metric := metrics[0]
+
+	if !(metric.HasTag("device_id") && metric.HasTag("frequency") && metric.HasTag("data_rate") &&
+		metric.HasTag("rssi") && metric.HasTag("snr") && metric.HasTag("gateway_id")) {
+		t.Error("missing one or more tags")
+	}
+
+	if !(metric.HasField("size") && metric.HasField("lat") && metric.HasField("lon") &&
+		metric.HasField("pwr") && metric.HasField("dr") && metric.HasField("airtime")) {
+		t.Error("missing one or more fields")
+	}
+}
+
+func TestTtsParser_Parse3(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = p.Parse([]byte(jsonMessageNoGateways))
+	if err == nil {
+		t.Error("should not be able to parse a json message without rx metadata")
+	}
+}
+
+func TestTtsParser_SetDefaultTags(t *testing.T) {
+	p, err := New(name)
+	if err != nil {
+		t.Error(err)
+	}
+
+	tags := map[string]string{
+		"test": "a",
+	}
+
+	p.SetDefaultTags(tags)
+
+	if v, ok := p.(*ttsParser).DefaultTags["test"]; !ok {
+		t.Error("default tags is missing key 'test'")
+	} else {
+		if v != "a" {
+			t.Error("default tags has wrong value for key 'test'")
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package router picks the parser and output of an MQTT message by matching
+// its topic against the topic filters of the configured routes.
+package router
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bullettime/lora-mqtt/database"
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/parser"
+	"github.com/bullettime/lora-mqtt/topic"
+	"github.com/pkg/errors"
+)
+
+const UnmatchedTopicMetric = "unmatched_topic"
+
+// Route sends the messages on the topics matching Filter to Parser and
+// Output. The default tags of the parser are Tags and the named segments of
+// the topic in Template.
+type Route struct {
+	Name     string
+	Filter   string
+	Template *topic.Template
+	Tags     map[string]string
+	Parser   parser.Parser
+	Output   database.Database
+}
+
+type Router struct {
+	routes []Route
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Add appends a route, routes are matched in the order they were added.
+func (r *Router) Add(route Route) error {
+	if len(route.Filter) == 0 && route.Template != nil {
+		route.Filter = route.Template.Filter()
+	}
+
+	if err := ValidFilter(route.Filter); err != nil {
+		return errors.Wrapf(err, "[Router] route %s", route.Name)
+	}
+
+	if route.Parser == nil || route.Output == nil {
+		return errors.Errorf("[Router] route %s needs a parser and an output", route.Name)
+	}
+
+	r.routes = append(r.routes, route)
+	return nil
+}
+
+// Route returns the first route with a filter that matches the topic.
+func (r *Router) Route(topic string) (Route, bool) {
+	for _, route := range r.routes {
+		if Match(route.Filter, topic) {
+			return route, true
+		}
+	}
+
+	return Route{}, false
+}
+
+func (r *Router) Routes() []Route {
+	return r.routes
+}
+
+// Filters returns the distinct topic filters to subscribe to.
+func (r *Router) Filters() []string {
+	var filters []string
+	seen := make(map[string]bool)
+
+	for _, route := range r.routes {
+		if !seen[route.Filter] {
+			seen[route.Filter] = true
+			filters = append(filters, route.Filter)
+		}
+	}
+
+	return filters
+}
+
+// ValidFilter checks the use of the + and # wildcards in an MQTT topic filter.
+func ValidFilter(filter string) error {
+	if len(filter) == 0 {
+		return errors.New("empty topic filter")
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return errors.Errorf("# must be the last level of topic filter %s", filter)
+		}
+
+		if strings.Contains(level, "+") && level != "+" {
+			return errors.Errorf("+ must be a whole level of topic filter %s", filter)
+		}
+	}
+
+	return nil
+}
+
+// Match reports whether the topic matches the MQTT topic filter. Like the
+// brokers do, wildcards at the first level don't match topics starting
+// with $.
+func Match(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "+" || filterLevels[0] == "#") {
+		return false
+	}
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+
+		if i >= len(topicLevels) {
+			return false
+		}
+
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
+
+// TopicTags returns the tags of the route together with the segments of the
+// topic, and reports whether the topic matched the template.
+func (r Route) TopicTags(t string) (map[string]string, bool) {
+	tags := make(map[string]string, len(r.Tags))
+	for k, v := range r.Tags {
+		tags[k] = v
+	}
+
+	if r.Template == nil {
+		return tags, true
+	}
+
+	values, ok := r.Template.Match(t)
+	for k, v := range values {
+		tags[k] = v
+	}
+
+	return tags, ok
+}
+
+// UnmatchedTopic returns the metric that reports a topic that didn't match
+// the template of the route.
+func (r Route) UnmatchedTopic(t string) (model.Metric, error) {
+	tags := map[string]string{
+		"route":    r.Name,
+		"template": r.Template.String(),
+	}
+
+	fields := map[string]interface{}{
+		"topic": t,
+		"count": 1,
+	}
+
+	return model.NewMetric(UnmatchedTopicMetric, tags, fields, time.Now())
+}
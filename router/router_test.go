@@ -0,0 +1,191 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package router
+
+import (
+	"testing"
+
+	"github.com/bullettime/lora-mqtt/model"
+	"github.com/bullettime/lora-mqtt/topic"
+)
+
+type testParser struct {
+	name string
+}
+
+func (p *testParser) Parse(buf []byte) ([]model.Metric, error) {
+	return nil, nil
+}
+
+func (p *testParser) SetDefaultTags(tags map[string]string) {
+}
+
+type testDatabase struct{}
+
+func (testDatabase) Connect() error {
+	return nil
+}
+
+func (testDatabase) Write([]model.Metric) error {
+	return nil
+}
+
+func (testDatabase) Close() error {
+	return nil
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		{"app/devices/+/up", "app/devices/node_1/up", true},
+		{"app/devices/+/up", "app/devices/node_1/down", false},
+		{"app/devices/+/up", "app/devices/up", false},
+		{"app/#", "app/devices/node_1/up", true},
+		{"app/#", "app", true},
+		{"app/+", "app", false},
+		{"#", "app/devices/node_1/up", true},
+		{"+/devices/+/up", "other/devices/node_1/up", true},
+		{"app/devices/node_1/up", "app/devices/node_1/up", true},
+		{"app/devices/node_1/up", "app/devices/node_1/up/extra", false},
+		{"+/+", "/up", true},
+		{"#", "$SYS/broker/uptime", false},
+		{"+/broker/uptime", "$SYS/broker/uptime", false},
+		{"$SYS/#", "$SYS/broker/uptime", true},
+	}
+
+	for _, test := range tests {
+		if Match(test.filter, test.topic) != test.match {
+			t.Errorf("%s matching %s should be %v", test.filter, test.topic, test.match)
+		}
+	}
+}
+
+func TestValidFilter(t *testing.T) {
+	for _, filter := range []string{"#", "+", "app/+/up", "app/#", "/app"} {
+		if err := ValidFilter(filter); err != nil {
+			t.Errorf("%s should be valid: %v", filter, err)
+		}
+	}
+
+	for _, filter := range []string{"", "app/#/up", "app/dev+/up", "app#"} {
+		if err := ValidFilter(filter); err == nil {
+			t.Errorf("%s should be invalid", filter)
+		}
+	}
+}
+
+func TestRouter_Route(t *testing.T) {
+	r := New()
+
+	routes := []Route{
+		{Name: "node_1", Filter: "app/devices/node_1/up", Parser: &testParser{"node_1"}, Output: testDatabase{}},
+		{Name: "app", Filter: "app/devices/+/up", Parser: &testParser{"app"}, Output: testDatabase{}},
+		{Name: "app_down", Filter: "app/devices/+/up", Parser: &testParser{"app_down"}, Output: testDatabase{}},
+	}
+
+	for _, route := range routes {
+		if err := r.Add(route); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.Add(Route{Name: "invalid", Filter: "app/#/up", Parser: &testParser{}, Output: testDatabase{}}); err == nil {
+		t.Error("route with an invalid filter should give an error")
+	}
+
+	if err := r.Add(Route{Name: "no parser", Filter: "app/#", Output: testDatabase{}}); err == nil {
+		t.Error("route without a parser should give an error")
+	}
+
+	route, ok := r.Route("app/devices/node_1/up")
+	if !ok || route.Name != "node_1" {
+		t.Errorf("wrong route: %s", route.Name)
+	}
+
+	route, ok = r.Route("app/devices/node_2/up")
+	if !ok || route.Name != "app" {
+		t.Errorf("wrong route: %s", route.Name)
+	}
+
+	if _, ok := r.Route("other/devices/node_2/up"); ok {
+		t.Error("topic should not match any route")
+	}
+
+	if filters := r.Filters(); len(filters) != 2 {
+		t.Errorf("duplicate filters should only be subscribed once: %v", filters)
+	}
+}
+
+func TestRoute_TopicTags(t *testing.T) {
+	r := New()
+
+	err := r.Add(Route{
+		Name:     "ttn",
+		Template: topic.MustCompile("{app_id}/devices/{device_id}/up"),
+		Tags:     map[string]string{"project": "coverage"},
+		Parser:   &testParser{},
+		Output:   testDatabase{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route, ok := r.Route("my-app/devices/sodaq-one/up")
+	if !ok {
+		t.Fatal("filter should be derived from the template")
+	}
+
+	tags, ok := route.TopicTags("my-app/devices/sodaq-one/up")
+	if !ok {
+		t.Error("topic should match the template")
+	}
+
+	expected := map[string]string{
+		"project":   "coverage",
+		"app_id":    "my-app",
+		"device_id": "sodaq-one",
+	}
+
+	for k, v := range expected {
+		if tags[k] != v {
+			t.Errorf("wrong tag %s: %s != %s", k, tags[k], v)
+		}
+	}
+
+	tags, ok = route.TopicTags("my-app/devices/up")
+	if ok || tags["project"] != "coverage" || len(tags) != 1 {
+		t.Errorf("topic should not match the template: %v", tags)
+	}
+
+	metric, err := route.UnmatchedTopic("my-app/devices/up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metric.Name() != UnmatchedTopicMetric || metric.Fields()["topic"] != "my-app/devices/up" || metric.Tags()["route"] != "ttn" {
+		t.Error("wrong unmatched topic metric")
+	}
+}
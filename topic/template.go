@@ -0,0 +1,145 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package topic extracts named segments from MQTT topics with templates like
+// {app_id}/devices/{dev_id}/up.
+package topic
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var variable = regexp.MustCompile(`^([^{}]*)\{([A-Za-z_][A-Za-z0-9_]*)\}([^{}]*)$`)
+
+// Template matches topics level by level. A level is either literal, the +
+// or # wildcard, or contains one {name} variable with an optional literal
+// prefix and suffix, e.g. eui-{gateway_id}.
+type Template struct {
+	template string
+	levels   []level
+}
+
+type level struct {
+	literal string
+	prefix  string
+	name    string
+	suffix  string
+}
+
+func Compile(template string) (*Template, error) {
+	if len(template) == 0 {
+		return nil, errors.New("[Topic] empty template")
+	}
+
+	t := Template{template: template}
+	names := make(map[string]bool)
+
+	parts := strings.Split(template, "/")
+	for i, part := range parts {
+		switch {
+		case part == "#" && i != len(parts)-1:
+			return nil, errors.Errorf("[Topic] # must be the last level of template %s", template)
+		case !strings.ContainsAny(part, "{}"):
+			if part != "+" && part != "#" && strings.ContainsAny(part, "+#") {
+				return nil, errors.Errorf("[Topic] wildcards must be a whole level of template %s", template)
+			}
+			t.levels = append(t.levels, level{literal: part})
+		default:
+			m := variable.FindStringSubmatch(part)
+			if m == nil {
+				return nil, errors.Errorf("[Topic] invalid level %s in template %s", part, template)
+			}
+			if names[m[2]] {
+				return nil, errors.Errorf("[Topic] duplicate name %s in template %s", m[2], template)
+			}
+			names[m[2]] = true
+			t.levels = append(t.levels, level{prefix: m[1], name: m[2], suffix: m[3]})
+		}
+	}
+
+	return &t, nil
+}
+
+func MustCompile(template string) *Template {
+	t, err := Compile(template)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Match returns the values of the named segments of the topic and reports
+// whether the topic matches the template.
+func (t *Template) Match(topic string) (map[string]string, bool) {
+	parts := strings.Split(topic, "/")
+	values := make(map[string]string)
+
+	for i, l := range t.levels {
+		if l.literal == "#" {
+			return values, true
+		}
+
+		if i >= len(parts) {
+			return nil, false
+		}
+		part := parts[i]
+
+		switch {
+		case len(l.name) > 0:
+			if len(part) <= len(l.prefix)+len(l.suffix) || !strings.HasPrefix(part, l.prefix) || !strings.HasSuffix(part, l.suffix) {
+				return nil, false
+			}
+			values[l.name] = part[len(l.prefix) : len(part)-len(l.suffix)]
+		case l.literal != "+" && l.literal != part:
+			return nil, false
+		}
+	}
+
+	if len(parts) != len(t.levels) {
+		return nil, false
+	}
+
+	return values, true
+}
+
+// Filter returns the MQTT topic filter that subscribes to all the topics
+// matching the template.
+func (t *Template) Filter() string {
+	levels := make([]string, len(t.levels))
+
+	for i, l := range t.levels {
+		if len(l.name) > 0 {
+			levels[i] = "+"
+		} else {
+			levels[i] = l.literal
+		}
+	}
+
+	return strings.Join(levels, "/")
+}
+
+func (t *Template) String() string {
+	return t.template
+}
@@ -0,0 +1,88 @@
+// The MIT License (MIT)
+//
+// Copyright © 2018 Sven Agneessens <sven.agneessens@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package topic
+
+import (
+	"testing"
+)
+
+func TestTemplate_Match(t *testing.T) {
+	tests := []struct {
+		template string
+		topic    string
+		values   map[string]string
+	}{
+		{"{app_id}/devices/{dev_id}/up", "my-app/devices/sodaq-one-1/up", map[string]string{"app_id": "my-app", "dev_id": "sodaq-one-1"}},
+		{"application/{app}/device/{dev_eui}/event/up", "application/12/device/0004a30b001c0530/event/up", map[string]string{"app": "12", "dev_eui": "0004a30b001c0530"}},
+		{"v3/{app_id}/devices/{dev_id}/up", "v3/my-app@ttn/devices/node-1/up", map[string]string{"app_id": "my-app@ttn", "dev_id": "node-1"}},
+		{"gateway/eui-{gateway_id}/#", "gateway/eui-b827ebfffe6a1c5d/event/up", map[string]string{"gateway_id": "b827ebfffe6a1c5d"}},
+		{"+/devices/{dev_id}/up", "app/devices/node_1/up", map[string]string{"dev_id": "node_1"}},
+		{"{app_id}/devices/{dev_id}/up", "app/devices/node_1/down", nil},
+		{"{app_id}/devices/{dev_id}/up", "app/devices/up", nil},
+		{"{app_id}/devices/{dev_id}/up", "app/devices/node_1/up/extra", nil},
+		{"{app_id}/devices/{dev_id}/up", "app/devices//up", nil},
+		{"gateway/eui-{gateway_id}/#", "gateway/eui-/event/up", nil},
+	}
+
+	for _, test := range tests {
+		template, err := Compile(test.template)
+		if err != nil {
+			t.Errorf("%s: %v", test.template, err)
+			continue
+		}
+
+		values, ok := template.Match(test.topic)
+		if ok != (test.values != nil) {
+			t.Errorf("%s matching %s should be %v", test.template, test.topic, test.values != nil)
+			continue
+		}
+
+		for k, v := range test.values {
+			if values[k] != v {
+				t.Errorf("%s: wrong value for %s: %s != %s", test.template, k, values[k], v)
+			}
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	for _, template := range []string{"", "{app}/#/up", "{app}/{app}/up", "{app}{dev}/up", "v3/{app_id}@{tenant}/up", "app/{1dev}/up", "app/dev+/up", "app/{dev/up"} {
+		if _, err := Compile(template); err == nil {
+			t.Errorf("%q should give an error", template)
+		}
+	}
+}
+
+func TestTemplate_Filter(t *testing.T) {
+	tests := map[string]string{
+		"{app_id}/devices/{dev_id}/up": "+/devices/+/up",
+		"gateway/eui-{gateway_id}/#":   "gateway/+/#",
+		"application/+/device/{dev}":   "application/+/device/+",
+	}
+
+	for template, filter := range tests {
+		if f := MustCompile(template).Filter(); f != filter {
+			t.Errorf("%s: %s != %s", template, f, filter)
+		}
+	}
+}